@@ -14,6 +14,10 @@ var conf = config.Map{
 		Usage:     "comma-delimited list of hostnames that are allowed to connect to the websocket",
 		Shorthand: "H",
 	},
+	"allowed-origins": &config.StringSlice{
+		Default: []string{},
+		Usage:   "comma-delimited list of origins that are allowed to open the websocket (defaults to same-origin only)",
+	},
 	"arguments": &config.StringSlice{
 		Default:   []string{},
 		Usage:     "comma-delimited list of arguments that should be passed to the terminal command",
@@ -41,6 +41,7 @@ func runE(_ *cobra.Command, _ []string) error {
 	connectionErrorLimit := conf.GetInt("connection-error-limit")
 	arguments := conf.GetStringSlice("arguments")
 	allowedHostnames := conf.GetStringSlice("allowed-hostnames")
+	allowedOrigins := conf.GetStringSlice("allowed-origins")
 	keepalivePingTimeout := time.Duration(conf.GetInt("keepalive-ping-timeout")) * time.Second
 	maxBufferSizeBytes := conf.GetInt("max-buffer-size-bytes")
 	pathLiveness := conf.GetString("path-liveness")
@@ -59,11 +60,16 @@ func runE(_ *cobra.Command, _ []string) error {
 		}
 		workingDirectory = path.Join(wd, workingDirectory)
 	}
+	if err := xtermjs.ValidateAllowedOrigins(allowedOrigins); err != nil {
+		log.Error(err.Error())
+		return err
+	}
 	log.Infof("working directory     : '%s'", workingDirectory)
 	log.Infof("command               : '%s'", command)
 	log.Infof("arguments             : ['%s']", strings.Join(arguments, "', '"))
 
 	log.Infof("allowed hosts         : ['%s']", strings.Join(allowedHostnames, "', '"))
+	log.Infof("allowed origins       : ['%s']", strings.Join(allowedOrigins, "', '"))
 	log.Infof("connection error limit: %v", connectionErrorLimit)
 	log.Infof("keepalive ping timeout: %v", keepalivePingTimeout)
 	log.Infof("max buffer size       : %v bytes", maxBufferSizeBytes)
@@ -81,6 +87,7 @@ func runE(_ *cobra.Command, _ []string) error {
 	// this is the endpoint for xterm.js to connect to
 	xtermjsHandlerOptions := xtermjs.HandlerOpts{
 		AllowedHostnames:     allowedHostnames,
+		AllowedOrigins:       allowedOrigins,
 		Arguments:            arguments,
 		Command:              command,
 		ConnectionErrorLimit: connectionErrorLimit,
@@ -5,11 +5,17 @@ package cmd
 
 import (
 	"context"
+	"encoding/json"
 	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/docktermj/cloudshell/pkg/xtermjs"
 	"github.com/docktermj/cloudshell/xtermserver"
+	"github.com/docktermj/cloudshell/xtermservice"
 	"github.com/senzing/senzing-tools/constant"
 	"github.com/senzing/senzing-tools/helper"
 	"github.com/senzing/senzing-tools/option"
@@ -18,44 +24,203 @@ import (
 )
 
 const (
-	defaultServerAddress             string = "0.0.0.0"
-	defaultServerPort                int    = 8261
-	defaultXtermCommand              string = "/bin/bash"
-	defaultXtermConnectionErrorLimit int    = 10
-	defaultXtermHtmlTitle            string = "Cloudshell"
-	defaultXtermKeepalivePingTimeout int    = 20
-	defaultXtermMaxBufferSizeBytes   int    = 512
-	defaultXtermUrlRoutePrefix       string = ""
-	envarServerAddress               string = "SENZING_TOOLS_SERVER_ADDRESS"
-	envarServerPort                  string = "SENZING_TOOLS_SERVER_PORT"
-	envarXtermAllowedHostnames       string = "SENZING_TOOLS_XTERM_ALLOWED_HOSTNAMES"
-	envarXtermArguments              string = "SENZING_TOOLS_XTERM_ARGUMENTS"
-	envarXtermCommand                string = "SENZING_TOOLS_XTERM_COMMAND"
-	envarXtermConnectionErrorLimit   string = "SENZING_TOOLS_XTERM_CONNECTION_ERROR_LIMIT"
-	envarXtermHtmlTitle              string = "SENZING_TOOLS_XTERM_HTML_TITLE"
-	envarXtermKeepalivePingTimeout   string = "SENZING_TOOLS_XTERM_KEEPALIVE_PING_TIMEOUT"
-	envarXtermMaxBufferSizeBytes     string = "SENZING_TOOLS_XTERM_MAX_BUFFER_SIZE_BYTES"
-	envarXtermUrlRoutePrefix         string = "SENZING_TOOLS_XTERM_URL_ROUTE_PREFIX"
-	optionServerAddress              string = "server-addr"
-	optionServerPort                 string = "server-port"
-	optionXtermAllowedHostnames      string = "xterm-allowed-hostnames"
-	optionXtermArguments             string = "xterm-arguments"
-	optionXtermCommand               string = "xterm-command"
-	optionXtermConnectionErrorLimit  string = "xterm-connection-error-limit"
-	optionXtermHtmlTitle             string = "xterm-html-title"
-	optionXtermKeepalivePingTimeout  string = "xterm-keepalive-ping-timeout"
-	optionXtermMaxBufferSizeBytes    string = "xterm-max-buffer-size-bytes"
-	optionXtermUrlRoutePrefix        string = "xterm-url-route-prefix"
-	Short                            string = "view-xterm short description"
-	Use                              string = "view-xterm"
-	Long                             string = `
+	defaultServerAddress                      string = "0.0.0.0"
+	defaultServerPort                         int    = 8261
+	defaultServerShutdownDrainPeriod          int    = 10
+	defaultServerTlsCertFile                  string = ""
+	defaultServerTlsClientCaFile              string = ""
+	defaultServerTlsKeyFile                   string = ""
+	defaultServerTlsSelfSigned                bool   = false
+	defaultXtermAuthHtpasswdFile              string = ""
+	defaultXtermCommand                       string = "/bin/bash"
+	defaultXtermCompressionLevel              int    = 1
+	defaultXtermCompressionThresholdBytes     int    = 128
+	defaultXtermConnectionErrorLimit          int    = 10
+	defaultXtermEnableCompression             bool   = false
+	defaultXtermFlowControlHighWatermarkBytes int    = 524288
+	defaultXtermFlowControlLowWatermarkBytes  int    = 131072
+	defaultXtermHtmlTitle                     string = "Cloudshell"
+	defaultXtermKeepalivePingTimeout          int    = 20
+	defaultXtermLimitAddressSpaceBytes        int    = 0
+	defaultXtermLimitCgroupParent             string = ""
+	defaultXtermLimitCpuTime                  int    = 0
+	defaultXtermLimitCpuWeight                int    = 0
+	defaultXtermLimitMemoryBytes              int    = 0
+	defaultXtermLimitOpenFiles                int    = 0
+	defaultXtermLimitPids                     int    = 0
+	defaultXtermLimitProcesses                int    = 0
+	defaultXtermMaxBufferSizeBytes            int    = 512
+	defaultXtermMaxOutputFrameSizeBytes       int    = 32768
+	defaultXtermOidcClientId                  string = ""
+	defaultXtermOidcClientSecret              string = ""
+	defaultXtermOidcIssuerUrl                 string = ""
+	defaultXtermOidcPostLogoutRedirectUrl     string = ""
+	defaultXtermOidcRedirectUrl               string = ""
+	defaultXtermOidcSessionSecret             string = ""
+	defaultXtermOidcUsernameClaim             string = ""
+	defaultXtermOutputLatencyWindow           int    = 5
+	defaultXtermOutputTextFrames              bool   = false
+	defaultXtermProfiles                      string = ""
+	defaultXtermRecordingDir                  string = ""
+	defaultXtermRecordingInput                bool   = false
+	defaultXtermRunAsUser                     string = ""
+	defaultXtermSandbox                       bool   = false
+	defaultXtermSandboxHomeDirectory          string = ""
+	defaultXtermSandboxNetwork                bool   = false
+	defaultXtermSessionDetachTimeout          int    = 0
+	defaultXtermSessionReplayBufferSizeBytes  int    = 65536
+	defaultXtermSubreaper                     bool   = true
+	defaultXtermTerminationGracePeriod        int    = 5
+	defaultXtermTtydProtocol                  bool   = false
+	defaultXtermUrlRoutePrefix                string = ""
+	defaultXtermWorkingDirectory              string = ""
+	defaultXtermWorkingDirectorySkeleton      string = ""
+	envarServerAddress                        string = "SENZING_TOOLS_SERVER_ADDRESS"
+	envarServerPort                           string = "SENZING_TOOLS_SERVER_PORT"
+	envarServerShutdownDrainPeriod            string = "SENZING_TOOLS_SERVER_SHUTDOWN_DRAIN_PERIOD"
+	envarServerTlsCertFile                    string = "SENZING_TOOLS_SERVER_TLS_CERT_FILE"
+	envarServerTlsClientCaFile                string = "SENZING_TOOLS_SERVER_TLS_CLIENT_CA_FILE"
+	envarServerTlsKeyFile                     string = "SENZING_TOOLS_SERVER_TLS_KEY_FILE"
+	envarServerTlsSelfSigned                  string = "SENZING_TOOLS_SERVER_TLS_SELF_SIGNED"
+	envarXtermAdministrators                  string = "SENZING_TOOLS_XTERM_ADMINISTRATORS"
+	envarXtermAllowedHostnames                string = "SENZING_TOOLS_XTERM_ALLOWED_HOSTNAMES"
+	envarXtermAllowedOrigins                  string = "SENZING_TOOLS_XTERM_ALLOWED_ORIGINS"
+	envarXtermArguments                       string = "SENZING_TOOLS_XTERM_ARGUMENTS"
+	envarXtermAuthBearerTokens                string = "SENZING_TOOLS_XTERM_AUTH_BEARER_TOKENS"
+	envarXtermAuthHtpasswdFile                string = "SENZING_TOOLS_XTERM_AUTH_HTPASSWD_FILE"
+	envarXtermCommand                         string = "SENZING_TOOLS_XTERM_COMMAND"
+	envarXtermCompressionLevel                string = "SENZING_TOOLS_XTERM_COMPRESSION_LEVEL"
+	envarXtermCompressionThresholdBytes       string = "SENZING_TOOLS_XTERM_COMPRESSION_THRESHOLD_BYTES"
+	envarXtermConnectionErrorLimit            string = "SENZING_TOOLS_XTERM_CONNECTION_ERROR_LIMIT"
+	envarXtermEnableCompression               string = "SENZING_TOOLS_XTERM_ENABLE_COMPRESSION"
+	envarXtermEnv                             string = "SENZING_TOOLS_XTERM_ENV"
+	envarXtermEnvAllowlist                    string = "SENZING_TOOLS_XTERM_ENV_ALLOWLIST"
+	envarXtermEnvDenylist                     string = "SENZING_TOOLS_XTERM_ENV_DENYLIST"
+	envarXtermFlowControlHighWatermarkBytes   string = "SENZING_TOOLS_XTERM_FLOW_CONTROL_HIGH_WATERMARK_BYTES"
+	envarXtermFlowControlLowWatermarkBytes    string = "SENZING_TOOLS_XTERM_FLOW_CONTROL_LOW_WATERMARK_BYTES"
+	envarXtermHtmlTitle                       string = "SENZING_TOOLS_XTERM_HTML_TITLE"
+	envarXtermKeepalivePingTimeout            string = "SENZING_TOOLS_XTERM_KEEPALIVE_PING_TIMEOUT"
+	envarXtermLimitAddressSpaceBytes          string = "SENZING_TOOLS_XTERM_LIMIT_ADDRESS_SPACE_BYTES"
+	envarXtermLimitCgroupParent               string = "SENZING_TOOLS_XTERM_LIMIT_CGROUP_PARENT"
+	envarXtermLimitCpuTime                    string = "SENZING_TOOLS_XTERM_LIMIT_CPU_TIME"
+	envarXtermLimitCpuWeight                  string = "SENZING_TOOLS_XTERM_LIMIT_CPU_WEIGHT"
+	envarXtermLimitMemoryBytes                string = "SENZING_TOOLS_XTERM_LIMIT_MEMORY_BYTES"
+	envarXtermLimitOpenFiles                  string = "SENZING_TOOLS_XTERM_LIMIT_OPEN_FILES"
+	envarXtermLimitPids                       string = "SENZING_TOOLS_XTERM_LIMIT_PIDS"
+	envarXtermLimitProcesses                  string = "SENZING_TOOLS_XTERM_LIMIT_PROCESSES"
+	envarXtermMaxBufferSizeBytes              string = "SENZING_TOOLS_XTERM_MAX_BUFFER_SIZE_BYTES"
+	envarXtermMaxOutputFrameSizeBytes         string = "SENZING_TOOLS_XTERM_MAX_OUTPUT_FRAME_SIZE_BYTES"
+	envarXtermOidcClientId                    string = "SENZING_TOOLS_XTERM_OIDC_CLIENT_ID"
+	envarXtermOidcClientSecret                string = "SENZING_TOOLS_XTERM_OIDC_CLIENT_SECRET"
+	envarXtermOidcIssuerUrl                   string = "SENZING_TOOLS_XTERM_OIDC_ISSUER_URL"
+	envarXtermOidcPostLogoutRedirectUrl       string = "SENZING_TOOLS_XTERM_OIDC_POST_LOGOUT_REDIRECT_URL"
+	envarXtermOidcRedirectUrl                 string = "SENZING_TOOLS_XTERM_OIDC_REDIRECT_URL"
+	envarXtermOidcScopes                      string = "SENZING_TOOLS_XTERM_OIDC_SCOPES"
+	envarXtermOidcSessionSecret               string = "SENZING_TOOLS_XTERM_OIDC_SESSION_SECRET"
+	envarXtermOidcUsernameClaim               string = "SENZING_TOOLS_XTERM_OIDC_USERNAME_CLAIM"
+	envarXtermOutputLatencyWindow             string = "SENZING_TOOLS_XTERM_OUTPUT_LATENCY_WINDOW"
+	envarXtermOutputTextFrames                string = "SENZING_TOOLS_XTERM_OUTPUT_TEXT_FRAMES"
+	envarXtermProfiles                        string = "SENZING_TOOLS_XTERM_PROFILES"
+	envarXtermRecordingDir                    string = "SENZING_TOOLS_XTERM_RECORDING_DIR"
+	envarXtermRecordingInput                  string = "SENZING_TOOLS_XTERM_RECORDING_INPUT"
+	envarXtermRunAsUser                       string = "SENZING_TOOLS_XTERM_RUN_AS_USER"
+	envarXtermSandbox                         string = "SENZING_TOOLS_XTERM_SANDBOX"
+	envarXtermSandboxHomeDirectory            string = "SENZING_TOOLS_XTERM_SANDBOX_HOME_DIRECTORY"
+	envarXtermSandboxNetwork                  string = "SENZING_TOOLS_XTERM_SANDBOX_NETWORK"
+	envarXtermSandboxReadOnlyPaths            string = "SENZING_TOOLS_XTERM_SANDBOX_READ_ONLY_PATHS"
+	envarXtermSessionDetachTimeout            string = "SENZING_TOOLS_XTERM_SESSION_DETACH_TIMEOUT"
+	envarXtermSessionReplayBufferSizeBytes    string = "SENZING_TOOLS_XTERM_SESSION_REPLAY_BUFFER_SIZE_BYTES"
+	envarXtermSubreaper                       string = "SENZING_TOOLS_XTERM_SUBREAPER"
+	envarXtermTerminationGracePeriod          string = "SENZING_TOOLS_XTERM_TERMINATION_GRACE_PERIOD"
+	envarXtermTtydProtocol                    string = "SENZING_TOOLS_XTERM_TTYD_PROTOCOL"
+	envarXtermUserAccounts                    string = "SENZING_TOOLS_XTERM_USER_ACCOUNTS"
+	envarXtermUserCommands                    string = "SENZING_TOOLS_XTERM_USER_COMMANDS"
+	envarXtermUrlRoutePrefix                  string = "SENZING_TOOLS_XTERM_URL_ROUTE_PREFIX"
+	envarXtermWorkingDirectory                string = "SENZING_TOOLS_XTERM_WORKING_DIRECTORY"
+	envarXtermWorkingDirectorySkeleton        string = "SENZING_TOOLS_XTERM_WORKING_DIRECTORY_SKELETON"
+	optionServerAddress                       string = "server-addr"
+	optionServerPort                          string = "server-port"
+	optionServerShutdownDrainPeriod           string = "server-shutdown-drain-period"
+	optionServerTlsCertFile                   string = "server-tls-cert-file"
+	optionServerTlsClientCaFile               string = "server-tls-client-ca-file"
+	optionServerTlsKeyFile                    string = "server-tls-key-file"
+	optionServerTlsSelfSigned                 string = "server-tls-self-signed"
+	optionXtermAdministrators                 string = "xterm-administrators"
+	optionXtermAllowedHostnames               string = "xterm-allowed-hostnames"
+	optionXtermAllowedOrigins                 string = "xterm-allowed-origins"
+	optionXtermArguments                      string = "xterm-arguments"
+	optionXtermAuthBearerTokens               string = "xterm-auth-bearer-tokens"
+	optionXtermAuthHtpasswdFile               string = "xterm-auth-htpasswd-file"
+	optionXtermCommand                        string = "xterm-command"
+	optionXtermCompressionLevel               string = "xterm-compression-level"
+	optionXtermCompressionThresholdBytes      string = "xterm-compression-threshold-bytes"
+	optionXtermConnectionErrorLimit           string = "xterm-connection-error-limit"
+	optionXtermEnableCompression              string = "xterm-enable-compression"
+	optionXtermEnv                            string = "xterm-env"
+	optionXtermEnvAllowlist                   string = "xterm-env-allowlist"
+	optionXtermEnvDenylist                    string = "xterm-env-denylist"
+	optionXtermFlowControlHighWatermarkBytes  string = "xterm-flow-control-high-watermark-bytes"
+	optionXtermFlowControlLowWatermarkBytes   string = "xterm-flow-control-low-watermark-bytes"
+	optionXtermHtmlTitle                      string = "xterm-html-title"
+	optionXtermKeepalivePingTimeout           string = "xterm-keepalive-ping-timeout"
+	optionXtermLimitAddressSpaceBytes         string = "xterm-limit-address-space-bytes"
+	optionXtermLimitCgroupParent              string = "xterm-limit-cgroup-parent"
+	optionXtermLimitCpuTime                   string = "xterm-limit-cpu-time"
+	optionXtermLimitCpuWeight                 string = "xterm-limit-cpu-weight"
+	optionXtermLimitMemoryBytes               string = "xterm-limit-memory-bytes"
+	optionXtermLimitOpenFiles                 string = "xterm-limit-open-files"
+	optionXtermLimitPids                      string = "xterm-limit-pids"
+	optionXtermLimitProcesses                 string = "xterm-limit-processes"
+	optionXtermMaxBufferSizeBytes             string = "xterm-max-buffer-size-bytes"
+	optionXtermMaxOutputFrameSizeBytes        string = "xterm-max-output-frame-size-bytes"
+	optionXtermOidcClientId                   string = "xterm-oidc-client-id"
+	optionXtermOidcClientSecret               string = "xterm-oidc-client-secret"
+	optionXtermOidcIssuerUrl                  string = "xterm-oidc-issuer-url"
+	optionXtermOidcPostLogoutRedirectUrl      string = "xterm-oidc-post-logout-redirect-url"
+	optionXtermOidcRedirectUrl                string = "xterm-oidc-redirect-url"
+	optionXtermOidcScopes                     string = "xterm-oidc-scopes"
+	optionXtermOidcSessionSecret              string = "xterm-oidc-session-secret"
+	optionXtermOidcUsernameClaim              string = "xterm-oidc-username-claim"
+	optionXtermOutputLatencyWindow            string = "xterm-output-latency-window"
+	optionXtermOutputTextFrames               string = "xterm-output-text-frames"
+	optionXtermProfiles                       string = "xterm-profiles"
+	optionXtermRecordingDir                   string = "xterm-recording-dir"
+	optionXtermRecordingInput                 string = "xterm-recording-input"
+	optionXtermRunAsUser                      string = "xterm-run-as-user"
+	optionXtermSandbox                        string = "xterm-sandbox"
+	optionXtermSandboxHomeDirectory           string = "xterm-sandbox-home-directory"
+	optionXtermSandboxNetwork                 string = "xterm-sandbox-network"
+	optionXtermSandboxReadOnlyPaths           string = "xterm-sandbox-read-only-paths"
+	optionXtermSessionDetachTimeout           string = "xterm-session-detach-timeout"
+	optionXtermSessionReplayBufferSizeBytes   string = "xterm-session-replay-buffer-size-bytes"
+	optionXtermSubreaper                      string = "xterm-subreaper"
+	optionXtermTerminationGracePeriod         string = "xterm-termination-grace-period"
+	optionXtermTtydProtocol                   string = "xterm-ttyd-protocol"
+	optionXtermUserAccounts                   string = "xterm-user-accounts"
+	optionXtermUserCommands                   string = "xterm-user-commands"
+	optionXtermUrlRoutePrefix                 string = "xterm-url-route-prefix"
+	optionXtermWorkingDirectory               string = "xterm-working-directory"
+	optionXtermWorkingDirectorySkeleton       string = "xterm-working-directory-skeleton"
+	Short                                     string = "view-xterm short description"
+	Use                                       string = "view-xterm"
+	Long                                      string = `
 view-xterm long description.
 	`
 )
 
 var (
-	defaultAllowedHostnames []string = []string{"localhost"}
-	defaultArguments        []string
+	defaultAdministrators       []string
+	defaultAllowedHostnames     []string = []string{"localhost"}
+	defaultAllowedOrigins       []string
+	defaultArguments            []string
+	defaultAuthBearerTokens     []string
+	defaultEnv                  []string
+	defaultEnvAllowlist         []string
+	defaultEnvDenylist          []string = []string{"SENZING_TOOLS_*"}
+	defaultOidcScopes           []string = []string{"openid", "profile", "email"}
+	defaultSandboxReadOnlyPaths []string = xtermjs.DefaultSandboxReadOnlyPaths
+	defaultUserAccounts         []string
+	defaultUserCommands         []string
 )
 
 // ----------------------------------------------------------------------------
@@ -64,16 +229,200 @@ var (
 
 // Since init() is always invoked, define command line parameters.
 func init() {
+	RootCmd.Flags().Bool(optionServerTlsSelfSigned, defaultServerTlsSelfSigned, fmt.Sprintf("Serve HTTPS with a generated self-signed certificate, for development [%s]", envarServerTlsSelfSigned))
+	RootCmd.Flags().Bool(optionXtermEnableCompression, defaultXtermEnableCompression, fmt.Sprintf("Offer per-message compression of websocket messages to browsers [%s]", envarXtermEnableCompression))
+	RootCmd.Flags().Bool(optionXtermOutputTextFrames, defaultXtermOutputTextFrames, fmt.Sprintf("Send terminal output in websocket text messages of valid UTF-8 [%s]", envarXtermOutputTextFrames))
+	RootCmd.Flags().Bool(optionXtermRecordingInput, defaultXtermRecordingInput, fmt.Sprintf("Include terminal input in session recordings [%s]", envarXtermRecordingInput))
+	RootCmd.Flags().Bool(optionXtermSandbox, defaultXtermSandbox, fmt.Sprintf("Start each terminal session in Linux user, mount, PID, IPC and network namespaces of its own, with a read-only root and a private /tmp and home directory [%s]", envarXtermSandbox))
+	RootCmd.Flags().Bool(optionXtermSandboxNetwork, defaultXtermSandboxNetwork, fmt.Sprintf("Share the server's network with sandboxed terminal sessions rather than giving them only a loopback interface [%s]", envarXtermSandboxNetwork))
+	RootCmd.Flags().Bool(optionXtermSubreaper, defaultXtermSubreaper, fmt.Sprintf("Adopt the processes terminal sessions leave behind, terminating those still in a closed session and reaping all of them once they exit [%s]", envarXtermSubreaper))
+	RootCmd.Flags().Bool(optionXtermTtydProtocol, defaultXtermTtydProtocol, fmt.Sprintf("Accept ttyd clients on the ws and token routes [%s]", envarXtermTtydProtocol))
+	RootCmd.Flags().Int(optionXtermCompressionLevel, defaultXtermCompressionLevel, fmt.Sprintf("Flate level of compressed websocket messages, from -2 (Huffman only) to 9 (best compression); 0 selects the default of 1 [%s]", envarXtermCompressionLevel))
+	RootCmd.Flags().Int(optionXtermCompressionThresholdBytes, defaultXtermCompressionThresholdBytes, fmt.Sprintf("Size below which websocket messages are sent uncompressed [%s]", envarXtermCompressionThresholdBytes))
 	RootCmd.Flags().Int(optionXtermConnectionErrorLimit, defaultXtermConnectionErrorLimit, fmt.Sprintf("Connection re-attempts before terminating [%s]", envarXtermConnectionErrorLimit))
+	RootCmd.Flags().Int(optionXtermFlowControlHighWatermarkBytes, defaultXtermFlowControlHighWatermarkBytes, fmt.Sprintf("Bytes of output a browser may leave unacknowledged before the terminal is paused [%s]", envarXtermFlowControlHighWatermarkBytes))
+	RootCmd.Flags().Int(optionXtermFlowControlLowWatermarkBytes, defaultXtermFlowControlLowWatermarkBytes, fmt.Sprintf("Bytes of unacknowledged output below which a paused terminal resumes [%s]", envarXtermFlowControlLowWatermarkBytes))
 	RootCmd.Flags().Int(optionXtermKeepalivePingTimeout, defaultXtermKeepalivePingTimeout, fmt.Sprintf("Maximum allowable seconds between a ping message and its response [%s]", envarXtermKeepalivePingTimeout))
+	RootCmd.Flags().Int(optionXtermLimitAddressSpaceBytes, defaultXtermLimitAddressSpaceBytes, fmt.Sprintf("Virtual memory each terminal process may use (RLIMIT_AS), which is not reported when reached; 0 for no limit [%s]", envarXtermLimitAddressSpaceBytes))
+	RootCmd.Flags().Int(optionXtermLimitCpuTime, defaultXtermLimitCpuTime, fmt.Sprintf("Seconds of CPU time each terminal process may use (RLIMIT_CPU); 0 for no limit [%s]", envarXtermLimitCpuTime))
+	RootCmd.Flags().Int(optionXtermLimitCpuWeight, defaultXtermLimitCpuWeight, fmt.Sprintf("Share of CPU time of each terminal session, from 1 to 10000 where 100 is the default (cgroup cpu.weight); 0 for no limit [%s]", envarXtermLimitCpuWeight))
+	RootCmd.Flags().Int(optionXtermLimitMemoryBytes, defaultXtermLimitMemoryBytes, fmt.Sprintf("Memory each terminal session may use before its processes are killed (cgroup memory.max); 0 for no limit [%s]", envarXtermLimitMemoryBytes))
+	RootCmd.Flags().Int(optionXtermLimitOpenFiles, defaultXtermLimitOpenFiles, fmt.Sprintf("Files each terminal process may have open (RLIMIT_NOFILE), which is not reported when reached; 0 for no limit [%s]", envarXtermLimitOpenFiles))
+	RootCmd.Flags().Int(optionXtermLimitPids, defaultXtermLimitPids, fmt.Sprintf("Processes and threads each terminal session may run (cgroup pids.max); 0 for no limit [%s]", envarXtermLimitPids))
+	RootCmd.Flags().Int(optionXtermLimitProcesses, defaultXtermLimitProcesses, fmt.Sprintf("Processes the user terminal sessions run as may run (RLIMIT_NPROC), which root is exempt from and is not reported when reached; 0 for no limit [%s]", envarXtermLimitProcesses))
 	RootCmd.Flags().Int(optionXtermMaxBufferSizeBytes, defaultXtermMaxBufferSizeBytes, fmt.Sprintf("Maximum length of terminal input [%s]", envarXtermMaxBufferSizeBytes))
+	RootCmd.Flags().Int(optionXtermMaxOutputFrameSizeBytes, defaultXtermMaxOutputFrameSizeBytes, fmt.Sprintf("Maximum bytes of terminal output coalesced into one websocket message [%s]", envarXtermMaxOutputFrameSizeBytes))
+	RootCmd.Flags().Int(optionXtermOutputLatencyWindow, defaultXtermOutputLatencyWindow, fmt.Sprintf("Milliseconds terminal output is held back to be coalesced with later output, negative to disable [%s]", envarXtermOutputLatencyWindow))
+	RootCmd.Flags().Int(optionXtermSessionDetachTimeout, defaultXtermSessionDetachTimeout, fmt.Sprintf("Seconds a disconnected terminal session is kept alive for reattachment; 0 to stop it as soon as it is disconnected [%s]", envarXtermSessionDetachTimeout))
+	RootCmd.Flags().Int(optionXtermSessionReplayBufferSizeBytes, defaultXtermSessionReplayBufferSizeBytes, fmt.Sprintf("Maximum bytes of output replayed when reattaching to a session [%s]", envarXtermSessionReplayBufferSizeBytes))
+	RootCmd.Flags().Int(optionXtermTerminationGracePeriod, defaultXtermTerminationGracePeriod, fmt.Sprintf("Seconds the processes of a closed session are given to exit after each of SIGHUP and SIGTERM before they are killed [%s]", envarXtermTerminationGracePeriod))
 	RootCmd.Flags().Int(optionServerPort, defaultServerPort, fmt.Sprintf("Port the server listens on [%s]", envarServerPort))
+	RootCmd.Flags().Int(optionServerShutdownDrainPeriod, defaultServerShutdownDrainPeriod, fmt.Sprintf("Seconds live sessions are given to finish after a shutdown signal before they are terminated; this plus three times the termination grace period must fit within the time the server is given to stop, such as the terminationGracePeriodSeconds of its Kubernetes pod. A second signal stops the server immediately [%s]", envarServerShutdownDrainPeriod))
+	RootCmd.Flags().String(optionXtermAuthHtpasswdFile, defaultXtermAuthHtpasswdFile, fmt.Sprintf("Path of htpasswd file used for HTTP Basic authentication [%s]", envarXtermAuthHtpasswdFile))
 	RootCmd.Flags().String(optionXtermCommand, defaultXtermCommand, fmt.Sprintf("Path of shell command [%s]", envarXtermCommand))
 	RootCmd.Flags().String(optionXtermHtmlTitle, defaultXtermHtmlTitle, fmt.Sprintf("XTerm HTML page title [%s]", envarXtermHtmlTitle))
+	RootCmd.Flags().String(optionXtermLimitCgroupParent, defaultXtermLimitCgroupParent, fmt.Sprintf("Cgroup v2 directory under which a cgroup is created for each terminal session; defaults to /sys/fs/cgroup/cloudshell [%s]", envarXtermLimitCgroupParent))
+	RootCmd.Flags().String(optionXtermOidcClientId, defaultXtermOidcClientId, fmt.Sprintf("OpenID Connect client ID [%s]", envarXtermOidcClientId))
+	RootCmd.Flags().String(optionXtermOidcClientSecret, defaultXtermOidcClientSecret, fmt.Sprintf("OpenID Connect client secret [%s]", envarXtermOidcClientSecret))
+	RootCmd.Flags().String(optionXtermOidcIssuerUrl, defaultXtermOidcIssuerUrl, fmt.Sprintf("OpenID Connect issuer URL; enables OpenID Connect login [%s]", envarXtermOidcIssuerUrl))
+	RootCmd.Flags().String(optionXtermOidcPostLogoutRedirectUrl, defaultXtermOidcPostLogoutRedirectUrl, fmt.Sprintf("Absolute URL the OpenID Connect provider returns users to after logging out, which must be registered with it; defaults to the root route next to the redirect URL [%s]", envarXtermOidcPostLogoutRedirectUrl))
+	RootCmd.Flags().String(optionXtermOidcRedirectUrl, defaultXtermOidcRedirectUrl, fmt.Sprintf("Absolute URL of the /oidc/callback route [%s]", envarXtermOidcRedirectUrl))
+	RootCmd.Flags().String(optionXtermOidcSessionSecret, defaultXtermOidcSessionSecret, fmt.Sprintf("Secret used to sign session cookies; random when not set [%s]", envarXtermOidcSessionSecret))
+	RootCmd.Flags().String(optionXtermOidcUsernameClaim, defaultXtermOidcUsernameClaim, fmt.Sprintf("ID token claim identifying the user [%s]", envarXtermOidcUsernameClaim))
 	RootCmd.Flags().String(optionServerAddress, defaultServerAddress, fmt.Sprintf("IP interface server listens on [%s]", envarServerAddress))
+	RootCmd.Flags().String(optionServerTlsCertFile, defaultServerTlsCertFile, fmt.Sprintf("Path of PEM certificate file; enables HTTPS and is reloaded when changed [%s]", envarServerTlsCertFile))
+	RootCmd.Flags().String(optionServerTlsClientCaFile, defaultServerTlsClientCaFile, fmt.Sprintf("Path of PEM CA certificates; requires clients to present a certificate they signed, identifying the user [%s]", envarServerTlsClientCaFile))
+	RootCmd.Flags().String(optionServerTlsKeyFile, defaultServerTlsKeyFile, fmt.Sprintf("Path of PEM private key file matching the certificate [%s]", envarServerTlsKeyFile))
+	RootCmd.Flags().String(optionXtermProfiles, defaultXtermProfiles, fmt.Sprintf("JSON object of named profiles, each with a command and optional arguments, env, workingDirectory and description, that browsers can start with the profile query parameter [%s]", envarXtermProfiles))
+	RootCmd.Flags().String(optionXtermRecordingDir, defaultXtermRecordingDir, fmt.Sprintf("Directory in which sessions are recorded as asciicast files, which authenticated users may replay when they made them or are administrators [%s]", envarXtermRecordingDir))
+	RootCmd.Flags().String(optionXtermRunAsUser, defaultXtermRunAsUser, fmt.Sprintf("Unix user, by name or uid, that terminal sessions are started as when running as root [%s]", envarXtermRunAsUser))
+	RootCmd.Flags().String(optionXtermSandboxHomeDirectory, defaultXtermSandboxHomeDirectory, fmt.Sprintf("Home directory of sandboxed terminal sessions, where the working directory is mounted if set; defaults to the home of the user sessions run as or /home/cloudshell [%s]", envarXtermSandboxHomeDirectory))
 	RootCmd.Flags().String(optionXtermUrlRoutePrefix, defaultXtermUrlRoutePrefix, fmt.Sprintf("Route prefix [%s]", envarXtermUrlRoutePrefix))
+	RootCmd.Flags().String(optionXtermWorkingDirectory, defaultXtermWorkingDirectory, fmt.Sprintf("Directory terminal sessions start in, created when missing; may use {{.Account}}, {{.Profile}}, {{.RemoteAddr}}, {{.SessionID}} and {{.User}}, as in /home/{{.User}}; sessions for which a path element is empty are refused [%s]", envarXtermWorkingDirectory))
+	RootCmd.Flags().String(optionXtermWorkingDirectorySkeleton, defaultXtermWorkingDirectorySkeleton, fmt.Sprintf("Directory whose contents are copied into working directories when they are created [%s]", envarXtermWorkingDirectorySkeleton))
+	RootCmd.Flags().StringSlice(optionXtermAdministrators, defaultAdministrators, fmt.Sprintf("Comma-delimited list of authenticated users allowed to see and terminate the terminal sessions, and replay the recordings, of every user, who otherwise only see their own [%s]", envarXtermAdministrators))
 	RootCmd.Flags().StringSlice(optionXtermAllowedHostnames, defaultAllowedHostnames, fmt.Sprintf("Comma-delimited list of hostnames permitted to connect to the websocket [%s]", envarXtermAllowedHostnames))
+	RootCmd.Flags().StringSlice(optionXtermAllowedOrigins, defaultAllowedOrigins, fmt.Sprintf("Comma-delimited list of origins permitted to open the websocket; entries are \"*\", \"regex:<expression>\" or \"[scheme://]host[:port]\" with an optional \"*.\" subdomain wildcard. Defaults to same-origin only [%s]", envarXtermAllowedOrigins))
 	RootCmd.Flags().StringSlice(optionXtermArguments, defaultArguments, fmt.Sprintf("Comma-delimited list of arguments passed to the terminal command prompt [%s]", envarXtermArguments))
+	RootCmd.Flags().StringSlice(optionXtermUserAccounts, defaultUserAccounts, fmt.Sprintf("Comma-delimited list of \"user=account\" entries selecting the Unix user a user's terminal sessions are started as [%s]", envarXtermUserAccounts))
+	RootCmd.Flags().StringSlice(optionXtermUserCommands, defaultUserCommands, fmt.Sprintf("Comma-delimited list of \"user=command arguments...\" entries selecting the command started for a user [%s]", envarXtermUserCommands))
+	RootCmd.Flags().StringSlice(optionXtermEnv, defaultEnv, fmt.Sprintf("Comma-delimited list of \"NAME=value\" environment variables set in terminal sessions; values may use {{.Account}}, {{.Profile}}, {{.RemoteAddr}}, {{.SessionID}} and {{.User}} [%s]", envarXtermEnv))
+	RootCmd.Flags().StringSlice(optionXtermEnvAllowlist, defaultEnvAllowlist, fmt.Sprintf("Comma-delimited list of glob patterns of the server's environment variables that terminal sessions inherit. Defaults to all, or none for sessions run as another user [%s]", envarXtermEnvAllowlist))
+	RootCmd.Flags().StringSlice(optionXtermEnvDenylist, defaultEnvDenylist, fmt.Sprintf("Comma-delimited list of glob patterns of the server's environment variables that terminal sessions never inherit [%s]", envarXtermEnvDenylist))
+	RootCmd.Flags().StringSlice(optionXtermSandboxReadOnlyPaths, defaultSandboxReadOnlyPaths, fmt.Sprintf("Comma-delimited list of absolute paths of the server's files and directories mounted read-only into sandboxed terminal sessions [%s]", envarXtermSandboxReadOnlyPaths))
+	RootCmd.Flags().StringSlice(optionXtermOidcScopes, defaultOidcScopes, fmt.Sprintf("Comma-delimited list of OpenID Connect scopes to request [%s]", envarXtermOidcScopes))
+	RootCmd.Flags().StringSlice(optionXtermAuthBearerTokens, defaultAuthBearerTokens, fmt.Sprintf("Comma-delimited list of accepted bearer tokens, each as user:token or token [%s]", envarXtermAuthBearerTokens))
+}
+
+// Create an authenticator from the authentication options, if any are set.
+func getAuthenticator() (xtermservice.Authenticator, error) {
+	authenticators := xtermservice.Authenticators{}
+	realm := viper.GetString(optionXtermHtmlTitle)
+	if issuerUrl := viper.GetString(optionXtermOidcIssuerUrl); len(issuerUrl) > 0 {
+		sessionSecret := []byte(viper.GetString(optionXtermOidcSessionSecret))
+		if len(sessionSecret) == 0 {
+			var err error
+			sessionSecret, err = xtermservice.NewOidcSessionSecret()
+			if err != nil {
+				return nil, err
+			}
+			fmt.Fprintf(os.Stderr, "No --%s given; logins will not survive a restart\n", optionXtermOidcSessionSecret)
+		}
+		authenticators = append(authenticators, &xtermservice.OidcAuthenticator{
+			ClientId:              viper.GetString(optionXtermOidcClientId),
+			ClientSecret:          viper.GetString(optionXtermOidcClientSecret),
+			IssuerUrl:             issuerUrl,
+			PostLogoutRedirectUrl: viper.GetString(optionXtermOidcPostLogoutRedirectUrl),
+			RedirectUrl:           viper.GetString(optionXtermOidcRedirectUrl),
+			Scopes:                viper.GetStringSlice(optionXtermOidcScopes),
+			SessionSecret:         sessionSecret,
+			UsernameClaim:         viper.GetString(optionXtermOidcUsernameClaim),
+		})
+	}
+	if htpasswdFile := viper.GetString(optionXtermAuthHtpasswdFile); len(htpasswdFile) > 0 {
+		htpasswdAuthenticator, err := xtermservice.NewHtpasswdAuthenticator(htpasswdFile, realm)
+		if err != nil {
+			return nil, err
+		}
+		authenticators = append(authenticators, htpasswdAuthenticator)
+	}
+	if bearerTokens := viper.GetStringSlice(optionXtermAuthBearerTokens); len(bearerTokens) > 0 {
+		authenticators = append(authenticators, xtermservice.NewBearerTokenAuthenticator(bearerTokens, realm))
+	}
+	if len(authenticators) == 0 {
+		return nil, nil
+	}
+	return authenticators, nil
+}
+
+// getResourceLimits returns the resource limits of terminal sessions.
+func getResourceLimits() (xtermjs.ResourceLimits, error) {
+	limits := map[string]uint64{}
+	for _, optionKey := range []string{
+		optionXtermLimitAddressSpaceBytes,
+		optionXtermLimitCpuTime,
+		optionXtermLimitCpuWeight,
+		optionXtermLimitMemoryBytes,
+		optionXtermLimitOpenFiles,
+		optionXtermLimitPids,
+		optionXtermLimitProcesses,
+	} {
+		value := viper.GetInt(optionKey)
+		if value < 0 {
+			return xtermjs.ResourceLimits{}, fmt.Errorf("invalid --%s %d; expected 0 for no limit or a positive number", optionKey, value)
+		}
+		limits[optionKey] = uint64(value)
+	}
+	if weight := limits[optionXtermLimitCpuWeight]; weight > 10000 {
+		return xtermjs.ResourceLimits{}, fmt.Errorf("invalid --%s %d; expected 1 to 10000", optionXtermLimitCpuWeight, weight)
+	}
+	return xtermjs.ResourceLimits{
+		AddressSpaceBytes: limits[optionXtermLimitAddressSpaceBytes],
+		CgroupParent:      viper.GetString(optionXtermLimitCgroupParent),
+		CpuTime:           time.Duration(limits[optionXtermLimitCpuTime]) * time.Second,
+		CpuWeight:         limits[optionXtermLimitCpuWeight],
+		MemoryBytes:       limits[optionXtermLimitMemoryBytes],
+		OpenFiles:         limits[optionXtermLimitOpenFiles],
+		Pids:              limits[optionXtermLimitPids],
+		Processes:         limits[optionXtermLimitProcesses],
+	}, nil
+}
+
+// getSandbox returns the sandbox of terminal sessions.
+func getSandbox() xtermjs.Sandbox {
+	return xtermjs.Sandbox{
+		Enabled:       viper.GetBool(optionXtermSandbox),
+		HomeDirectory: viper.GetString(optionXtermSandboxHomeDirectory),
+		Network:       viper.GetBool(optionXtermSandboxNetwork),
+		ReadOnlyPaths: viper.GetStringSlice(optionXtermSandboxReadOnlyPaths),
+	}
+}
+
+// getUserAccounts parses the "user=account" entries of the user accounts
+// option.
+func getUserAccounts() (map[string]string, error) {
+	userAccounts := map[string]string{}
+	for _, entry := range viper.GetStringSlice(optionXtermUserAccounts) {
+		user, account, found := strings.Cut(entry, "=")
+		if !found || len(strings.TrimSpace(user)) == 0 || len(strings.TrimSpace(account)) == 0 {
+			return nil, fmt.Errorf("invalid --%s entry '%s'; expected \"user=account\"", optionXtermUserAccounts, entry)
+		}
+		userAccounts[strings.TrimSpace(user)] = strings.TrimSpace(account)
+	}
+	return userAccounts, nil
+}
+
+// getUserCommands parses the "user=command arguments..." entries of the
+// user commands option.
+func getUserCommands() (map[string][]string, error) {
+	userCommands := map[string][]string{}
+	for _, entry := range viper.GetStringSlice(optionXtermUserCommands) {
+		user, command, found := strings.Cut(entry, "=")
+		fields := strings.Fields(command)
+		if !found || len(strings.TrimSpace(user)) == 0 || len(fields) == 0 {
+			return nil, fmt.Errorf("invalid --%s entry '%s'; expected \"user=command arguments...\"", optionXtermUserCommands, entry)
+		}
+		userCommands[strings.TrimSpace(user)] = fields
+	}
+	return userCommands, nil
+}
+
+// getProfiles parses the JSON object of named profiles.
+func getProfiles() (map[string]xtermjs.Profile, error) {
+	profiles := map[string]xtermjs.Profile{}
+	value := viper.GetString(optionXtermProfiles)
+	if len(strings.TrimSpace(value)) == 0 {
+		return profiles, nil
+	}
+	if err := json.Unmarshal([]byte(value), &profiles); err != nil {
+		return nil, fmt.Errorf("invalid --%s; expected a JSON object of profiles by name: %w", optionXtermProfiles, err)
+	}
+	for name, profile := range profiles {
+		if len(strings.TrimSpace(name)) == 0 || len(profile.Command) == 0 {
+			return nil, fmt.Errorf("invalid --%s entry '%s'; expected a name and a command", optionXtermProfiles, name)
+		}
+	}
+	return profiles, nil
 }
 
 // If a configuration file is present, load it.
@@ -119,13 +468,50 @@ func loadOptions(cobraCommand *cobra.Command) {
 	viper.SetEnvKeyReplacer(replacer)
 	viper.SetEnvPrefix(constant.SetEnvPrefix)
 
+	// Bools
+
+	boolOptions := map[string]bool{
+		optionServerTlsSelfSigned:    defaultServerTlsSelfSigned,
+		optionXtermEnableCompression: defaultXtermEnableCompression,
+		optionXtermOutputTextFrames:  defaultXtermOutputTextFrames,
+		optionXtermRecordingInput:    defaultXtermRecordingInput,
+		optionXtermSandbox:           defaultXtermSandbox,
+		optionXtermSandboxNetwork:    defaultXtermSandboxNetwork,
+		optionXtermSubreaper:         defaultXtermSubreaper,
+		optionXtermTtydProtocol:      defaultXtermTtydProtocol,
+	}
+	for optionKey, optionValue := range boolOptions {
+		viper.SetDefault(optionKey, optionValue)
+		err = viper.BindPFlag(optionKey, cobraCommand.Flags().Lookup(optionKey))
+		if err != nil {
+			panic(err)
+		}
+	}
+
 	// Ints
 
 	intOptions := map[string]int{
-		optionXtermConnectionErrorLimit: defaultXtermConnectionErrorLimit,
-		optionXtermKeepalivePingTimeout: defaultXtermKeepalivePingTimeout,
-		optionXtermMaxBufferSizeBytes:   defaultXtermMaxBufferSizeBytes,
-		optionServerPort:                defaultServerPort,
+		optionXtermCompressionLevel:              defaultXtermCompressionLevel,
+		optionXtermCompressionThresholdBytes:     defaultXtermCompressionThresholdBytes,
+		optionXtermConnectionErrorLimit:          defaultXtermConnectionErrorLimit,
+		optionXtermFlowControlHighWatermarkBytes: defaultXtermFlowControlHighWatermarkBytes,
+		optionXtermFlowControlLowWatermarkBytes:  defaultXtermFlowControlLowWatermarkBytes,
+		optionXtermKeepalivePingTimeout:          defaultXtermKeepalivePingTimeout,
+		optionXtermLimitAddressSpaceBytes:        defaultXtermLimitAddressSpaceBytes,
+		optionXtermLimitCpuTime:                  defaultXtermLimitCpuTime,
+		optionXtermLimitCpuWeight:                defaultXtermLimitCpuWeight,
+		optionXtermLimitMemoryBytes:              defaultXtermLimitMemoryBytes,
+		optionXtermLimitOpenFiles:                defaultXtermLimitOpenFiles,
+		optionXtermLimitPids:                     defaultXtermLimitPids,
+		optionXtermLimitProcesses:                defaultXtermLimitProcesses,
+		optionXtermMaxBufferSizeBytes:            defaultXtermMaxBufferSizeBytes,
+		optionXtermMaxOutputFrameSizeBytes:       defaultXtermMaxOutputFrameSizeBytes,
+		optionXtermOutputLatencyWindow:           defaultXtermOutputLatencyWindow,
+		optionXtermSessionDetachTimeout:          defaultXtermSessionDetachTimeout,
+		optionXtermSessionReplayBufferSizeBytes:  defaultXtermSessionReplayBufferSizeBytes,
+		optionXtermTerminationGracePeriod:        defaultXtermTerminationGracePeriod,
+		optionServerPort:                         defaultServerPort,
+		optionServerShutdownDrainPeriod:          defaultServerShutdownDrainPeriod,
 	}
 	for optionKey, optionValue := range intOptions {
 		viper.SetDefault(optionKey, optionValue)
@@ -138,10 +524,28 @@ func loadOptions(cobraCommand *cobra.Command) {
 	// Strings
 
 	stringOptions := map[string]string{
-		optionXtermCommand:        defaultXtermCommand,
-		optionXtermHtmlTitle:      defaultXtermHtmlTitle,
-		optionServerAddress:       defaultServerAddress,
-		optionXtermUrlRoutePrefix: defaultXtermUrlRoutePrefix,
+		optionXtermAuthHtpasswdFile:          defaultXtermAuthHtpasswdFile,
+		optionXtermCommand:                   defaultXtermCommand,
+		optionXtermHtmlTitle:                 defaultXtermHtmlTitle,
+		optionXtermLimitCgroupParent:         defaultXtermLimitCgroupParent,
+		optionXtermOidcClientId:              defaultXtermOidcClientId,
+		optionXtermOidcClientSecret:          defaultXtermOidcClientSecret,
+		optionXtermOidcIssuerUrl:             defaultXtermOidcIssuerUrl,
+		optionXtermOidcPostLogoutRedirectUrl: defaultXtermOidcPostLogoutRedirectUrl,
+		optionXtermOidcRedirectUrl:           defaultXtermOidcRedirectUrl,
+		optionXtermOidcSessionSecret:         defaultXtermOidcSessionSecret,
+		optionXtermOidcUsernameClaim:         defaultXtermOidcUsernameClaim,
+		optionXtermProfiles:                  defaultXtermProfiles,
+		optionServerAddress:                  defaultServerAddress,
+		optionServerTlsCertFile:              defaultServerTlsCertFile,
+		optionServerTlsClientCaFile:          defaultServerTlsClientCaFile,
+		optionServerTlsKeyFile:               defaultServerTlsKeyFile,
+		optionXtermRecordingDir:              defaultXtermRecordingDir,
+		optionXtermRunAsUser:                 defaultXtermRunAsUser,
+		optionXtermSandboxHomeDirectory:      defaultXtermSandboxHomeDirectory,
+		optionXtermUrlRoutePrefix:            defaultXtermUrlRoutePrefix,
+		optionXtermWorkingDirectory:          defaultXtermWorkingDirectory,
+		optionXtermWorkingDirectorySkeleton:  defaultXtermWorkingDirectorySkeleton,
 	}
 	for optionKey, optionValue := range stringOptions {
 		viper.SetDefault(optionKey, optionValue)
@@ -154,8 +558,18 @@ func loadOptions(cobraCommand *cobra.Command) {
 	// StringSlice
 
 	stringSliceOptions := map[string][]string{
-		optionXtermAllowedHostnames: defaultAllowedHostnames,
-		optionXtermArguments:        defaultArguments,
+		optionXtermAdministrators:       defaultAdministrators,
+		optionXtermAllowedHostnames:     defaultAllowedHostnames,
+		optionXtermAllowedOrigins:       defaultAllowedOrigins,
+		optionXtermArguments:            defaultArguments,
+		optionXtermAuthBearerTokens:     defaultAuthBearerTokens,
+		optionXtermEnv:                  defaultEnv,
+		optionXtermEnvAllowlist:         defaultEnvAllowlist,
+		optionXtermEnvDenylist:          defaultEnvDenylist,
+		optionXtermOidcScopes:           defaultOidcScopes,
+		optionXtermSandboxReadOnlyPaths: defaultSandboxReadOnlyPaths,
+		optionXtermUserAccounts:         defaultUserAccounts,
+		optionXtermUserCommands:         defaultUserCommands,
 	}
 	for optionKey, optionValue := range stringSliceOptions {
 		viper.SetDefault(optionKey, optionValue)
@@ -189,21 +603,97 @@ func PreRun(cobraCommand *cobra.Command, args []string) {
 // Used in construction of cobra.Command
 func RunE(_ *cobra.Command, _ []string) error {
 	var err error = nil
-	ctx := context.TODO()
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	// Once shutting down, a second signal is left to stop the server at once.
+
+	go func() {
+		<-ctx.Done()
+		stop()
+	}()
+
+	authenticator, err := getAuthenticator()
+	if err != nil {
+		return err
+	}
+	userAccounts, err := getUserAccounts()
+	if err != nil {
+		return err
+	}
+	userCommands, err := getUserCommands()
+	if err != nil {
+		return err
+	}
+	profiles, err := getProfiles()
+	if err != nil {
+		return err
+	}
+	resourceLimits, err := getResourceLimits()
+	if err != nil {
+		return err
+	}
+	err = xtermjs.ValidateAllowedOrigins(viper.GetStringSlice(optionXtermAllowedOrigins))
+	if err != nil {
+		return err
+	}
+	err = xtermjs.ValidateEnvironment(viper.GetStringSlice(optionXtermEnv), viper.GetStringSlice(optionXtermEnvAllowlist), viper.GetStringSlice(optionXtermEnvDenylist))
+	if err != nil {
+		return err
+	}
+	sandbox := getSandbox()
+	err = xtermjs.ValidateSandbox(sandbox)
+	if err != nil {
+		return err
+	}
 
 	// Create object and Serve.
 
 	xtermServer := &xtermserver.XtermServerImpl{
-		AllowedHostnames:     viper.GetStringSlice(optionXtermAllowedHostnames),
-		Arguments:            viper.GetStringSlice(optionXtermArguments),
-		Command:              viper.GetString(optionXtermCommand),
-		ConnectionErrorLimit: viper.GetInt(optionXtermConnectionErrorLimit),
-		HtmlTitle:            viper.GetString(optionXtermHtmlTitle),
-		KeepalivePingTimeout: viper.GetInt(optionXtermKeepalivePingTimeout),
-		MaxBufferSizeBytes:   viper.GetInt(optionXtermMaxBufferSizeBytes),
-		ServerPort:           viper.GetInt(optionServerPort),
-		ServerAddress:        viper.GetString(optionServerAddress),
-		UrlRoutePrefix:       viper.GetString(optionXtermUrlRoutePrefix),
+		Administrators:                viper.GetStringSlice(optionXtermAdministrators),
+		AllowedHostnames:              viper.GetStringSlice(optionXtermAllowedHostnames),
+		AllowedOrigins:                viper.GetStringSlice(optionXtermAllowedOrigins),
+		Authenticator:                 authenticator,
+		Arguments:                     viper.GetStringSlice(optionXtermArguments),
+		Command:                       viper.GetString(optionXtermCommand),
+		CompressionLevel:              viper.GetInt(optionXtermCompressionLevel),
+		CompressionThresholdBytes:     viper.GetInt(optionXtermCompressionThresholdBytes),
+		ConnectionErrorLimit:          viper.GetInt(optionXtermConnectionErrorLimit),
+		EnableCompression:             viper.GetBool(optionXtermEnableCompression),
+		Env:                           viper.GetStringSlice(optionXtermEnv),
+		EnvAllowlist:                  viper.GetStringSlice(optionXtermEnvAllowlist),
+		EnvDenylist:                   viper.GetStringSlice(optionXtermEnvDenylist),
+		FlowControlHighWatermarkBytes: viper.GetInt(optionXtermFlowControlHighWatermarkBytes),
+		FlowControlLowWatermarkBytes:  viper.GetInt(optionXtermFlowControlLowWatermarkBytes),
+		HtmlTitle:                     viper.GetString(optionXtermHtmlTitle),
+		KeepalivePingTimeout:          viper.GetInt(optionXtermKeepalivePingTimeout),
+		MaxBufferSizeBytes:            viper.GetInt(optionXtermMaxBufferSizeBytes),
+		MaxOutputFrameSizeBytes:       viper.GetInt(optionXtermMaxOutputFrameSizeBytes),
+		OutputLatencyWindow:           viper.GetInt(optionXtermOutputLatencyWindow),
+		OutputTextFrames:              viper.GetBool(optionXtermOutputTextFrames),
+		Profiles:                      profiles,
+		RecordInput:                   viper.GetBool(optionXtermRecordingInput),
+		RecordingDirectory:            viper.GetString(optionXtermRecordingDir),
+		ResourceLimits:                resourceLimits,
+		RunAsUser:                     viper.GetString(optionXtermRunAsUser),
+		Sandbox:                       sandbox,
+		ServerPort:                    viper.GetInt(optionServerPort),
+		ServerShutdownDrainPeriod:     viper.GetInt(optionServerShutdownDrainPeriod),
+		ServerAddress:                 viper.GetString(optionServerAddress),
+		ServerTlsCertFile:             viper.GetString(optionServerTlsCertFile),
+		ServerTlsClientCaFile:         viper.GetString(optionServerTlsClientCaFile),
+		ServerTlsKeyFile:              viper.GetString(optionServerTlsKeyFile),
+		ServerTlsSelfSigned:           viper.GetBool(optionServerTlsSelfSigned),
+		SessionDetachTimeout:          viper.GetInt(optionXtermSessionDetachTimeout),
+		SessionReplayBufferSizeBytes:  viper.GetInt(optionXtermSessionReplayBufferSizeBytes),
+		Subreaper:                     viper.GetBool(optionXtermSubreaper),
+		TerminationGracePeriod:        viper.GetInt(optionXtermTerminationGracePeriod),
+		TtydProtocol:                  viper.GetBool(optionXtermTtydProtocol),
+		UrlRoutePrefix:                viper.GetString(optionXtermUrlRoutePrefix),
+		UserAccounts:                  userAccounts,
+		UserCommands:                  userCommands,
+		WorkingDirectory:              viper.GetString(optionXtermWorkingDirectory),
+		WorkingDirectorySkeleton:      viper.GetString(optionXtermWorkingDirectorySkeleton),
 	}
 	err = xtermServer.Serve(ctx)
 	return err
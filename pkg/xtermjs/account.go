@@ -0,0 +1,64 @@
+package xtermjs
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// accountPath is the PATH of sessions started as another account, as the
+// handler's own PATH may not suit it
+const accountPath = "/usr/local/sbin:/usr/local/bin:/usr/sbin:/usr/bin:/sbin:/bin"
+
+// accountTerm is the TERM of sessions started as another account, matching
+// the terminal emulated by xterm.js
+const accountTerm = "xterm-256color"
+
+// accountLocaleVariables are passed on from the handler's environment to
+// sessions started as another account
+var accountLocaleVariables = []string{"LANG", "LANGUAGE", "LC_ALL", "TZ"}
+
+// account is the Unix account a session is started as
+type account struct {
+	gid    uint32
+	groups []uint32
+	home   string
+	name   string
+	shell  string
+	uid    uint32
+}
+
+// environment returns the environment a login to the account starts with
+func (a *account) environment() []string {
+	env := []string{
+		"HOME=" + a.home,
+		"LOGNAME=" + a.name,
+		"PATH=" + accountPath,
+		"SHELL=" + a.shell,
+		"TERM=" + accountTerm,
+		"USER=" + a.name,
+	}
+	for _, name := range accountLocaleVariables {
+		if value, ok := os.LookupEnv(name); ok {
+			env = append(env, name+"="+value)
+		}
+	}
+	return env
+}
+
+// prepare makes cmd start like a login to the account: with the account's
+// environment overriding the variables cmd already has, in its home
+// directory unless cmd has a directory of its own, and with the account's shell started as a
+// login shell. Like login, "/" is used when the home directory is missing
+func (a *account) prepare(cmd *exec.Cmd) {
+	cmd.Env = append(cmd.Env, a.environment()...)
+	if cmd.Dir == "" {
+		cmd.Dir = "/"
+		if info, err := os.Stat(a.home); err == nil && info.IsDir() {
+			cmd.Dir = a.home
+		}
+	}
+	if len(cmd.Args) == 1 && cmd.Path == a.shell {
+		cmd.Args[0] = "-" + filepath.Base(a.shell)
+	}
+}
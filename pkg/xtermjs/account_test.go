@@ -0,0 +1,67 @@
+package xtermjs
+
+import (
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestAccount_prepare(test *testing.T) {
+	for _, name := range accountLocaleVariables {
+		test.Setenv(name, "")
+	}
+	test.Setenv("LANG", "C.UTF-8")
+	home := test.TempDir()
+	testCases := []struct {
+		name         string
+		home         string
+		path         string
+		args         []string
+		dir          string
+		expectedArgs []string
+		expectedDir  string
+	}{
+		{name: "login shell", path: "/bin/bash", args: []string{"bash"}, expectedArgs: []string{"-bash"}, expectedDir: home},
+		{name: "shell with arguments", path: "/bin/bash", args: []string{"bash", "-c", "id"}, expectedArgs: []string{"bash", "-c", "id"}, expectedDir: home},
+		{name: "other command", path: "/usr/bin/top", args: []string{"top"}, expectedArgs: []string{"top"}, expectedDir: home},
+		{name: "missing home directory", home: filepath.Join(home, "missing"), path: "/bin/bash", args: []string{"bash"}, expectedArgs: []string{"-bash"}, expectedDir: "/"},
+		{name: "working directory", path: "/bin/bash", args: []string{"bash"}, dir: "/tmp", expectedArgs: []string{"-bash"}, expectedDir: "/tmp"},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			runAs := &account{home: home, name: "alice", shell: "/bin/bash"}
+			if testCase.home != "" {
+				runAs.home = testCase.home
+			}
+			cmd := &exec.Cmd{Path: testCase.path, Args: testCase.args, Dir: testCase.dir, Env: []string{"HOME=/root"}}
+			runAs.prepare(cmd)
+			if !reflect.DeepEqual(cmd.Args, testCase.expectedArgs) {
+				test.Errorf("expected arguments %q, got %q", testCase.expectedArgs, cmd.Args)
+			}
+			if cmd.Dir != testCase.expectedDir {
+				test.Errorf("expected directory %q, got %q", testCase.expectedDir, cmd.Dir)
+			}
+			expectedEnv := []string{
+				"HOME=/root",
+				"HOME=" + runAs.home,
+				"LOGNAME=alice",
+				"PATH=" + accountPath,
+				"SHELL=/bin/bash",
+				"TERM=" + accountTerm,
+				"USER=alice",
+				"LANG=C.UTF-8",
+				"LANGUAGE=",
+				"LC_ALL=",
+				"TZ=",
+			}
+			if !reflect.DeepEqual(cmd.Env, expectedEnv) {
+				test.Errorf("expected environment %q, got %q", expectedEnv, cmd.Env)
+			}
+		})
+	}
+}
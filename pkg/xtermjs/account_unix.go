@@ -0,0 +1,120 @@
+//go:build !windows
+
+package xtermjs
+
+import (
+	"bufio"
+	"fmt"
+	"os"
+	"os/exec"
+	"os/user"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"github.com/creack/pty"
+)
+
+// defaultAccountShell is the shell of accounts whose shell cannot be found
+const defaultAccountShell = "/bin/sh"
+
+// lookupAccount returns the account of name, a user name or numeric uid
+func lookupAccount(name string) (*account, error) {
+	found, err := user.Lookup(name)
+	if _, isUid := strconv.ParseUint(name, 10, 32); err != nil && isUid == nil {
+		found, err = user.LookupId(name)
+	}
+	if err != nil {
+		return nil, err
+	}
+	uid, err := strconv.ParseUint(found.Uid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid uid '%s' of user '%s'", found.Uid, found.Username)
+	}
+	gid, err := strconv.ParseUint(found.Gid, 10, 32)
+	if err != nil {
+		return nil, fmt.Errorf("invalid gid '%s' of user '%s'", found.Gid, found.Username)
+	}
+	groupIds, err := found.GroupIds()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list the groups of user '%s': %w", found.Username, err)
+	}
+	groups := []uint32{}
+	for _, groupId := range groupIds {
+		group, err := strconv.ParseUint(groupId, 10, 32)
+		if err != nil {
+			return nil, fmt.Errorf("invalid group '%s' of user '%s'", groupId, found.Username)
+		}
+		groups = append(groups, uint32(group))
+	}
+	return &account{
+		gid:    uint32(gid),
+		groups: groups,
+		home:   found.HomeDir,
+		name:   found.Username,
+		shell:  lookupShell(found.Username),
+		uid:    uint32(uid),
+	}, nil
+}
+
+// lookupShell returns the login shell of username from /etc/passwd, which
+// os/user does not report
+func lookupShell(username string) string {
+	file, err := os.Open("/etc/passwd")
+	if err != nil {
+		return defaultAccountShell
+	}
+	defer file.Close()
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Split(scanner.Text(), ":")
+		if len(fields) == 7 && fields[0] == username && fields[6] != "" {
+			return fields[6]
+		}
+	}
+	return defaultAccountShell
+}
+
+// startTTY starts cmd in a new tty like pty.Start, as runAs when specified.
+// The tty is handed over to runAs so that its processes can open /dev/tty.
+// When sandboxed, cmd is the init process of a sandbox, which cannot set
+// supplementary groups and allocates the tty in the sandbox, see
+// sandbox.start, so that no tty is returned
+func startTTY(cmd *exec.Cmd, runAs *account, sandboxed bool) (*os.File, error) {
+	if runAs == nil && !sandboxed {
+		return pty.Start(cmd)
+	}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	if runAs != nil {
+		cmd.SysProcAttr.Credential = &syscall.Credential{
+			Gid:         runAs.gid,
+			Groups:      runAs.groups,
+			NoSetGroups: sandboxed,
+			Uid:         runAs.uid,
+		}
+	}
+	cmd.SysProcAttr.Setsid = true
+	if sandboxed {
+		return nil, cmd.Start()
+	}
+	ptmx, tty, err := pty.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer tty.Close()
+	if err := tty.Chown(int(runAs.uid), int(runAs.gid)); err != nil {
+		ptmx.Close()
+		return nil, fmt.Errorf("failed to hand the tty over to user '%s': %w", runAs.name, err)
+	}
+	cmd.Stdin = tty
+	cmd.Stdout = tty
+	cmd.Stderr = tty
+	cmd.SysProcAttr.Setctty = true
+	if err := cmd.Start(); err != nil {
+		ptmx.Close()
+		return nil, err
+	}
+	return ptmx, nil
+}
@@ -0,0 +1,63 @@
+//go:build !windows
+
+package xtermjs
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestLookupAccount(test *testing.T) {
+	for _, name := range []string{"root", "0"} {
+		test.Run(name, func(test *testing.T) {
+			runAs, err := lookupAccount(name)
+			if err != nil {
+				test.Skipf("failed to find root: %s", err)
+			}
+			if runAs.name != "root" || runAs.uid != 0 || runAs.gid != 0 || runAs.shell == "" {
+				test.Errorf("expected root, got %+v", runAs)
+			}
+		})
+	}
+	if _, err := lookupAccount("no-such-user-of-cloudshell"); err == nil {
+		test.Errorf("expected an error for an unknown user")
+	}
+}
+
+func TestStartTTY_RunAs(test *testing.T) {
+	if os.Geteuid() != 0 {
+		test.Skip("switching users requires root")
+	}
+	runAs, err := lookupAccount("nobody")
+	if err != nil {
+		test.Skipf("failed to find nobody: %s", err)
+	}
+	cmd := exec.Command("/bin/sh", "-c", "id -u; id -g; stat -c %u /proc/self/fd/0")
+	runAs.prepare(cmd)
+	cmd.Dir = "/"
+	tty, err := startTTY(cmd, runAs, false)
+	if err != nil {
+		test.Fatalf("failed to start tty: %s", err)
+	}
+	defer tty.Close()
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(tty)
+		output <- string(data)
+	}()
+	cmd.Wait()
+	uid := strconv.FormatUint(uint64(runAs.uid), 10)
+	expected := []string{uid, strconv.FormatUint(uint64(runAs.gid), 10), uid}
+	actual := strings.Fields(<-output)
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		test.Errorf("expected %q, got %q", expected, actual)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build windows
+
+package xtermjs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+
+	"github.com/creack/pty"
+)
+
+// lookupAccount fails as sessions cannot be started as another account on
+// Windows
+func lookupAccount(name string) (*account, error) {
+	return nil, errors.New("starting sessions as another user is not supported on windows")
+}
+
+// startTTY starts cmd in a new tty like pty.Start
+func startTTY(cmd *exec.Cmd, runAs *account, sandboxed bool) (*os.File, error) {
+	return pty.Start(cmd)
+}
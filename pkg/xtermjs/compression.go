@@ -0,0 +1,135 @@
+package xtermjs
+
+import (
+	"bufio"
+	"compress/flate"
+	"errors"
+	"net"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+// DefaultCompressionLevel is the flate level used to compress messages when
+// HandlerOpts.EnableCompression is set, favouring speed as terminal output is
+// latency sensitive
+const DefaultCompressionLevel = flate.BestSpeed
+
+// DefaultCompressionThresholdBytes is the size below which messages are sent
+// uncompressed, as compressing them saves too little to be worth it
+const DefaultCompressionThresholdBytes = 128
+
+const (
+	compressionLabelEnabled  = "enabled"
+	compressionLabelDisabled = "disabled"
+)
+
+// Metrics of the traffic sent to frontends. Dividing
+// cloudshell_websocket_sent_wire_bytes_total{compression="enabled"} by
+// cloudshell_websocket_sent_message_bytes_total{compression="enabled"} gives
+// the ratio of the bytes written to the bytes sent by connections that
+// negotiated compression. It overstates their compression ratio, as the bytes
+// written include the framing of every message, control frames such as pings
+// and messages sent uncompressed for being below the threshold
+var (
+	sentMessageBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudshell_websocket_sent_message_bytes_total",
+		Help: "Bytes of the websocket messages sent to frontends, before compression.",
+	}, []string{"compression"})
+	sentWireBytes = promauto.NewCounterVec(prometheus.CounterOpts{
+		Name: "cloudshell_websocket_sent_wire_bytes_total",
+		Help: "Bytes written to the websocket connections of frontends, including framing and control frames.",
+	}, []string{"compression"})
+)
+
+// compressionPolicy decides which messages sent on a connection are
+// compressed and counts the bytes of the messages sent
+type compressionPolicy struct {
+	// negotiated tells whether the frontend accepted permessage-deflate
+	negotiated     bool
+	thresholdBytes int
+	messageBytes   prometheus.Counter
+}
+
+func newCompressionPolicy(negotiated bool, thresholdBytes int) compressionPolicy {
+	return compressionPolicy{
+		negotiated:     negotiated,
+		thresholdBytes: thresholdBytes,
+		messageBytes:   sentMessageBytes.WithLabelValues(compressionLabel(negotiated)),
+	}
+}
+
+// writeMessage sends data on connection, compressed when compression was
+// negotiated and data is at least thresholdBytes long
+func (policy compressionPolicy) writeMessage(connection *websocket.Conn, messageType int, data []byte) error {
+	connection.EnableWriteCompression(policy.negotiated && len(data) >= policy.thresholdBytes)
+	policy.messageBytes.Add(float64(len(data)))
+	return connection.WriteMessage(messageType, data)
+}
+
+// compressionLabel returns the value of the compression label of metrics
+func compressionLabel(negotiated bool) string {
+	if negotiated {
+		return compressionLabelEnabled
+	}
+	return compressionLabelDisabled
+}
+
+// compressionRequested reports whether the frontend offered permessage-deflate
+// in its handshake, in which case an upgrader with EnableCompression set
+// negotiates it
+func compressionRequested(header http.Header) bool {
+	for _, value := range header.Values("Sec-WebSocket-Extensions") {
+		for _, extension := range strings.Split(value, ",") {
+			name, _, _ := strings.Cut(extension, ";")
+			if strings.TrimSpace(name) == "permessage-deflate" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// wireCountingResponseWriter counts the bytes written to the connection it
+// hijacks, once countBytes has been called
+type wireCountingResponseWriter struct {
+	http.ResponseWriter
+	connection *wireCountingConn
+}
+
+func (w *wireCountingResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	hijacker, ok := w.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response does not implement http.Hijacker")
+	}
+	connection, readWriter, err := hijacker.Hijack()
+	if err != nil {
+		return nil, nil, err
+	}
+	w.connection = &wireCountingConn{Conn: connection}
+	return w.connection, readWriter, nil
+}
+
+// countBytes starts counting the bytes written to the hijacked connection. It
+// must be called before the connection is written to concurrently
+func (w *wireCountingResponseWriter) countBytes(negotiated bool) {
+	if w.connection != nil {
+		w.connection.wireBytes = sentWireBytes.WithLabelValues(compressionLabel(negotiated))
+	}
+}
+
+type wireCountingConn struct {
+	net.Conn
+	wireBytes prometheus.Counter
+}
+
+func (connection *wireCountingConn) Write(p []byte) (int, error) {
+	n, err := connection.Conn.Write(p)
+	if connection.wireBytes != nil {
+		connection.wireBytes.Add(float64(n))
+	}
+	return n, err
+}
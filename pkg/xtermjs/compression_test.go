@@ -0,0 +1,102 @@
+package xtermjs
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/websocket"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestCompressionRequested(test *testing.T) {
+	testCases := []struct {
+		name       string
+		extensions []string
+		expected   bool
+	}{
+		{name: "none", expected: false},
+		{name: "browser", extensions: []string{"permessage-deflate; client_max_window_bits"}, expected: true},
+		{name: "among others", extensions: []string{"x-webkit-deflate-frame, permessage-deflate"}, expected: true},
+		{name: "in a later header", extensions: []string{"x-webkit-deflate-frame", "permessage-deflate"}, expected: true},
+		{name: "other extension", extensions: []string{"x-webkit-deflate-frame; permessage-deflate"}, expected: false},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			header := http.Header{}
+			for _, extension := range testCase.extensions {
+				header.Add("Sec-WebSocket-Extensions", extension)
+			}
+			if actual := compressionRequested(header); actual != testCase.expected {
+				test.Errorf("expected %v, got %v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCompressionPolicy_WriteMessage(test *testing.T) {
+	testCases := []struct {
+		name               string
+		enableCompression  bool
+		data               string
+		expectedCompressed bool
+	}{
+		{name: "compressed", enableCompression: true, data: strings.Repeat("\x1b[0m\x1b[1;1H ", 1000), expectedCompressed: true},
+		{name: "below the threshold", enableCompression: true, data: "ls\r\n", expectedCompressed: false},
+		{name: "not negotiated", enableCompression: false, data: strings.Repeat("\x1b[0m\x1b[1;1H ", 1000), expectedCompressed: false},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			label := compressionLabel(testCase.enableCompression)
+			messageBytes := testutil.ToFloat64(sentMessageBytes.WithLabelValues(label))
+			wireBytes := testutil.ToFloat64(sentWireBytes.WithLabelValues(label))
+			upgrader := websocket.Upgrader{EnableCompression: true}
+			written := make(chan struct{})
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				wireCounter := &wireCountingResponseWriter{ResponseWriter: w}
+				connection, err := upgrader.Upgrade(wireCounter, r, nil)
+				if err != nil {
+					test.Error(err)
+					return
+				}
+				defer connection.Close()
+				defer close(written)
+				negotiated := compressionRequested(r.Header)
+				wireCounter.countBytes(negotiated)
+				policy := newCompressionPolicy(negotiated, DefaultCompressionThresholdBytes)
+				if err := policy.writeMessage(connection, websocket.BinaryMessage, []byte(testCase.data)); err != nil {
+					test.Error(err)
+				}
+			}))
+			defer server.Close()
+			dialer := websocket.Dialer{EnableCompression: testCase.enableCompression}
+			client, _, err := dialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+			if err != nil {
+				test.Fatal(err)
+			}
+			defer client.Close()
+			_, data, err := client.ReadMessage()
+			if err != nil {
+				test.Fatal(err)
+			}
+			if !bytes.Equal(data, []byte(testCase.data)) {
+				test.Errorf("expected %v bytes of data, got %v", len(testCase.data), len(data))
+			}
+			<-written
+			messageBytes = testutil.ToFloat64(sentMessageBytes.WithLabelValues(label)) - messageBytes
+			wireBytes = testutil.ToFloat64(sentWireBytes.WithLabelValues(label)) - wireBytes
+			if messageBytes != float64(len(testCase.data)) {
+				test.Errorf("expected %v message bytes to be counted, got %v", len(testCase.data), messageBytes)
+			}
+			if compressed := wireBytes < messageBytes; compressed != testCase.expectedCompressed {
+				test.Errorf("expected compressed %v, got %v wire bytes for %v message bytes", testCase.expectedCompressed, wireBytes, messageBytes)
+			}
+		})
+	}
+}
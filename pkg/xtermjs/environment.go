@@ -0,0 +1,115 @@
+package xtermjs
+
+import (
+	"bytes"
+	"fmt"
+	"path"
+	"strings"
+	"text/template"
+)
+
+// sessionTemplateData is what the values of HandlerOpts.Env entries, as in
+// "CLOUDSHELL_USER={{.User}}", and working directories are executed with.
+type sessionTemplateData struct {
+	// Account is the Unix account the session runs as, if not the handler's
+	Account string
+	// Profile is the name of the profile the session is started from, if any
+	Profile string
+	// RemoteAddr is the address of the client starting the session
+	RemoteAddr string
+	// SessionID uniquely identifies the session
+	SessionID string
+	// User is the identity of the user starting the session, if known
+	User string
+}
+
+// environmentTemplate is a compiled entry of HandlerOpts.Env.
+type environmentTemplate struct {
+	name  string
+	value *template.Template
+}
+
+// compileEnvironmentTemplates compiles the "NAME=value" entries of
+// HandlerOpts.Env, whose values are text/template templates.
+func compileEnvironmentTemplates(entries []string) ([]environmentTemplate, error) {
+	templates := []environmentTemplate{}
+	for _, entry := range entries {
+		name, value, found := strings.Cut(entry, "=")
+		if !found || name == "" || strings.ContainsAny(name, " \t") {
+			return nil, fmt.Errorf("invalid environment variable '%s'; expected \"NAME=value\"", entry)
+		}
+		parsed, err := template.New(name).Option("missingkey=error").Parse(value)
+		if err != nil {
+			return nil, fmt.Errorf("invalid value of environment variable '%s': %w", name, err)
+		}
+		templates = append(templates, environmentTemplate{name: name, value: parsed})
+	}
+	return templates, nil
+}
+
+// validateEnvironmentPatterns checks the glob patterns of
+// HandlerOpts.EnvAllowlist and HandlerOpts.EnvDenylist.
+func validateEnvironmentPatterns(patterns []string) error {
+	for _, pattern := range patterns {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("invalid environment variable pattern '%s': %w", pattern, err)
+		}
+	}
+	return nil
+}
+
+// ValidateEnvironment returns an error describing the first entry of
+// HandlerOpts.Env, HandlerOpts.EnvAllowlist or HandlerOpts.EnvDenylist that
+// cannot be used.
+func ValidateEnvironment(env []string, allowlist []string, denylist []string) error {
+	if _, err := compileEnvironmentTemplates(env); err != nil {
+		return err
+	}
+	if err := validateEnvironmentPatterns(allowlist); err != nil {
+		return err
+	}
+	return validateEnvironmentPatterns(denylist)
+}
+
+// matchesEnvironmentPattern reports whether the variable name matches one of
+// the glob patterns.
+func matchesEnvironmentPattern(patterns []string, name string) bool {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, name); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// filterEnvironment returns the "NAME=value" entries of env whose name
+// matches allowlist, or any name when allowlist is empty, and does not match
+// denylist.
+func filterEnvironment(env []string, allowlist []string, denylist []string) []string {
+	filtered := []string{}
+	for _, entry := range env {
+		name, _, _ := strings.Cut(entry, "=")
+		if len(allowlist) > 0 && !matchesEnvironmentPattern(allowlist, name) {
+			continue
+		}
+		if matchesEnvironmentPattern(denylist, name) {
+			continue
+		}
+		filtered = append(filtered, entry)
+	}
+	return filtered
+}
+
+// expandEnvironment returns the "NAME=value" entries of templates executed
+// with data.
+func expandEnvironment(templates []environmentTemplate, data sessionTemplateData) ([]string, error) {
+	env := []string{}
+	for _, entry := range templates {
+		var value bytes.Buffer
+		if err := entry.value.Execute(&value, data); err != nil {
+			return nil, fmt.Errorf("failed to expand environment variable '%s': %w", entry.name, err)
+		}
+		env = append(env, entry.name+"="+value.String())
+	}
+	return env, nil
+}
@@ -0,0 +1,85 @@
+package xtermjs
+
+import (
+	"reflect"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestFilterEnvironment(test *testing.T) {
+	env := []string{"HOME=/root", "LANG=C.UTF-8", "LC_ALL=C", "SENZING_TOOLS_XTERM_AUTH_BEARER_TOKENS=secret", "TOKEN=a=b"}
+	testCases := []struct {
+		name      string
+		allowlist []string
+		denylist  []string
+		expected  []string
+	}{
+		{name: "everything", expected: env},
+		{name: "denied", denylist: []string{"SENZING_TOOLS_*", "TOKEN"}, expected: []string{"HOME=/root", "LANG=C.UTF-8", "LC_ALL=C"}},
+		{name: "allowed", allowlist: []string{"LANG", "LC_*"}, expected: []string{"LANG=C.UTF-8", "LC_ALL=C"}},
+		{name: "allowed and denied", allowlist: []string{"LANG", "LC_*"}, denylist: []string{"LC_ALL"}, expected: []string{"LANG=C.UTF-8"}},
+		{name: "nothing allowed", allowlist: []string{"NONE"}, expected: []string{}},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			actual := filterEnvironment(env, testCase.allowlist, testCase.denylist)
+			if !reflect.DeepEqual(actual, testCase.expected) {
+				test.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestExpandEnvironment(test *testing.T) {
+	data := sessionTemplateData{Account: "alice", RemoteAddr: "10.0.0.1:1234", SessionID: "42", User: "alice@example.com"}
+	testCases := []struct {
+		name     string
+		env      []string
+		expected []string
+		valid    bool
+	}{
+		{name: "static", env: []string{"EDITOR=vim", "EMPTY="}, expected: []string{"EDITOR=vim", "EMPTY="}, valid: true},
+		{name: "templated", env: []string{"CLOUDSHELL_SESSION={{.SessionID}}", "CLOUDSHELL_CLIENT={{.User}} from {{.RemoteAddr}}"}, expected: []string{"CLOUDSHELL_SESSION=42", "CLOUDSHELL_CLIENT=alice@example.com from 10.0.0.1:1234"}, valid: true},
+		{name: "unknown field", env: []string{"HOST={{.Host}}"}, valid: false},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			templates, err := compileEnvironmentTemplates(testCase.env)
+			if err != nil {
+				test.Fatalf("failed to compile %q: %s", testCase.env, err)
+			}
+			actual, err := expandEnvironment(templates, data)
+			if (err == nil) != testCase.valid {
+				test.Fatalf("expected valid=%v for %q, got error %v", testCase.valid, testCase.env, err)
+			}
+			if testCase.valid && !reflect.DeepEqual(actual, testCase.expected) {
+				test.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestValidateEnvironment(test *testing.T) {
+	testCases := []struct {
+		env       []string
+		allowlist []string
+		denylist  []string
+		valid     bool
+	}{
+		{env: []string{"EDITOR=vim", "CLOUDSHELL_USER={{.User}}"}, allowlist: []string{"LC_*"}, denylist: []string{"SENZING_TOOLS_*"}, valid: true},
+		{env: []string{"EDITOR"}, valid: false},
+		{env: []string{"=vim"}, valid: false},
+		{env: []string{"USER={{.User"}, valid: false},
+		{allowlist: []string{"LC_["}, valid: false},
+		{denylist: []string{"["}, valid: false},
+	}
+	for _, testCase := range testCases {
+		err := ValidateEnvironment(testCase.env, testCase.allowlist, testCase.denylist)
+		if (err == nil) != testCase.valid {
+			test.Errorf("expected valid=%v for %q %q %q, got error %v", testCase.valid, testCase.env, testCase.allowlist, testCase.denylist, err)
+		}
+	}
+}
@@ -0,0 +1,114 @@
+package xtermjs
+
+import "sync"
+
+// DefaultFlowControlHighWatermarkBytes is the amount of output sent but not
+// yet acknowledged by a frontend at which reading from the tty stops
+const DefaultFlowControlHighWatermarkBytes = 512 * 1024
+
+// DefaultFlowControlLowWatermarkBytes is the amount of unacknowledged output
+// below which reading from the tty resumes
+const DefaultFlowControlLowWatermarkBytes = 128 * 1024
+
+// flowControl decides when output may be read from a session's tty. Frontends
+// speaking ProtocolV1 acknowledge the output they have processed: reading
+// stops when the output they have not acknowledged reaches the high watermark
+// and resumes when it falls to the low watermark. ttyd frontends pause and
+// resume output explicitly. Legacy frontends are sent output as fast as it is
+// produced
+type flowControl struct {
+	highWatermark int
+	lowWatermark  int
+
+	mutex          sync.Mutex
+	acknowledging  bool
+	changed        *sync.Cond
+	closed         bool
+	paused         bool
+	throttled      bool
+	unacknowledged int
+}
+
+func newFlowControl(highWatermark int, lowWatermark int) *flowControl {
+	if highWatermark <= 0 {
+		highWatermark = DefaultFlowControlHighWatermarkBytes
+	}
+	if lowWatermark < 0 || lowWatermark >= highWatermark {
+		lowWatermark = highWatermark / 4
+	}
+	f := &flowControl{
+		highWatermark: highWatermark,
+		lowWatermark:  lowWatermark,
+	}
+	f.changed = sync.NewCond(&f.mutex)
+	return f
+}
+
+// reset forgets the state of the previous connection, for when a connection
+// is attached or detached. acknowledging tells whether the new connection
+// acknowledges output
+func (f *flowControl) reset(acknowledging bool) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.acknowledging = acknowledging
+	f.paused = false
+	f.throttled = false
+	f.unacknowledged = 0
+	f.changed.Broadcast()
+}
+
+// pause stops output until resume is called
+func (f *flowControl) pause() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.paused = true
+}
+
+// resume undoes pause
+func (f *flowControl) resume() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.paused = false
+	f.changed.Broadcast()
+}
+
+// sent records that length bytes of output were sent to the frontend
+func (f *flowControl) sent(length int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.unacknowledged += length
+	if f.acknowledging && f.unacknowledged >= f.highWatermark {
+		f.throttled = true
+	}
+}
+
+// acknowledge records that the frontend has processed length bytes of output
+func (f *flowControl) acknowledge(length int) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.unacknowledged -= length
+	if f.unacknowledged < 0 {
+		f.unacknowledged = 0
+	}
+	if f.throttled && f.unacknowledged <= f.lowWatermark {
+		f.throttled = false
+		f.changed.Broadcast()
+	}
+}
+
+// wait blocks while output is paused or throttled, until close is called
+func (f *flowControl) wait() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	for (f.paused || f.throttled) && !f.closed {
+		f.changed.Wait()
+	}
+}
+
+// close releases wait for good
+func (f *flowControl) close() {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+	f.closed = true
+	f.changed.Broadcast()
+}
@@ -0,0 +1,82 @@
+package xtermjs
+
+import (
+	"testing"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// waits reports whether flowControl.wait blocks for longer than a moment
+func waits(f *flowControl) bool {
+	done := make(chan struct{})
+	go func() {
+		f.wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return false
+	case <-time.After(50 * time.Millisecond):
+		f.close()
+		<-done
+		return true
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestFlowControl(test *testing.T) {
+	testCases := []struct {
+		name     string
+		steps    func(f *flowControl)
+		expected bool
+	}{
+		{name: "not acknowledging", steps: func(f *flowControl) { f.reset(false); f.sent(1000) }, expected: false},
+		{name: "below high watermark", steps: func(f *flowControl) { f.sent(99) }, expected: false},
+		{name: "at high watermark", steps: func(f *flowControl) { f.sent(100) }, expected: true},
+		{name: "above low watermark", steps: func(f *flowControl) { f.sent(100); f.acknowledge(70) }, expected: true},
+		{name: "at low watermark", steps: func(f *flowControl) { f.sent(100); f.acknowledge(75) }, expected: false},
+		{name: "rising again", steps: func(f *flowControl) { f.sent(100); f.acknowledge(75); f.sent(50) }, expected: false},
+		{name: "reset", steps: func(f *flowControl) { f.sent(100); f.reset(false); f.sent(100) }, expected: false},
+		{name: "paused", steps: func(f *flowControl) { f.pause() }, expected: true},
+		{name: "resumed", steps: func(f *flowControl) { f.pause(); f.resume() }, expected: false},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			f := newFlowControl(100, 25)
+			f.reset(true)
+			testCase.steps(f)
+			if actual := waits(f); actual != testCase.expected {
+				test.Errorf("expected waiting %v, got %v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestFlowControl_Acknowledge(test *testing.T) {
+	f := newFlowControl(100, 25)
+	f.reset(true)
+	f.sent(200)
+	done := make(chan struct{})
+	go func() {
+		f.wait()
+		close(done)
+	}()
+	f.acknowledge(150)
+	select {
+	case <-done:
+		test.Fatal("expected waiting above the low watermark")
+	case <-time.After(50 * time.Millisecond):
+	}
+	f.acknowledge(50)
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		test.Fatal("expected acknowledgements to release waiting")
+	}
+}
@@ -1,17 +1,19 @@
 package xtermjs
 
 import (
-	"bytes"
-	"encoding/json"
+	"compress/flate"
+	"crypto/subtle"
+	"errors"
 	"fmt"
 	"net/http"
 	"os"
 	"os/exec"
+	"strconv"
 	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
 
-	"github.com/creack/pty"
 	"github.com/docktermj/cloudshell/internal/log"
 	"github.com/google/uuid"
 	"github.com/gorilla/websocket"
@@ -19,14 +21,40 @@ import (
 
 const DefaultConnectionErrorLimit = 10
 
+// becomeSubreaperOnce makes the first handler created with
+// HandlerOpts.Subreaper adopt the orphaned processes of its sessions
+var becomeSubreaperOnce sync.Once
+
+const DefaultSessionReplayBufferSizeBytes = 64 * 1024
+
+const DefaultTerminationGracePeriod = 5 * time.Second
+
+// terminationPollInterval is how often processes are checked for having
+// exited while a session is being terminated
+const terminationPollInterval = 50 * time.Millisecond
+
 type HandlerOpts struct {
 	// AllowedHostnames is a list of strings which will be matched to the client
 	// requesting for a connection upgrade to a websocket connection
 	AllowedHostnames []string
+	// AllowedOrigins is a list of origins from which browsers may open a websocket
+	// connection. Entries are "*", "regex:<expression>" or "[scheme://]host[:port]"
+	// where host may start with "*." to allow any subdomain. When not specified,
+	// only connections from the origin serving the handler are allowed
+	AllowedOrigins []string
 	// Arguments is a list of strings to pass as arguments to the specified COmmand
 	Arguments []string
 	// Command is the path to the binary we should create a TTY for
 	Command string
+	// CompressionLevel defines the flate level, from -2 (Huffman only) to 9
+	// (best compression), used when EnableCompression is set. When not
+	// specified, DefaultCompressionLevel is used, so that 0 (no compression)
+	// cannot be chosen; leave EnableCompression unset instead
+	CompressionLevel int
+	// CompressionThresholdBytes defines the size below which messages are sent
+	// uncompressed when EnableCompression is set. When not specified,
+	// DefaultCompressionThresholdBytes is used
+	CompressionThresholdBytes int
 	// ConnectionErrorLimit defines the number of consecutive errors that can happen
 	// before a connection is considered unusable
 	ConnectionErrorLimit int
@@ -34,14 +62,190 @@ type HandlerOpts struct {
 	// The string argument being passed in will be a unique identifier for the
 	// current connection. When not specified, logs will be sent to stdout
 	CreateLogger func(string, *http.Request) Logger
+	// EnableCompression when true offers per-message compression (RFC 7692) to
+	// frontends, which browsers accept, trading CPU for bandwidth. The bytes
+	// of the messages sent and the bytes written to connections, framing and
+	// control frames included, are counted by the
+	// cloudshell_websocket_sent_message_bytes_total and
+	// cloudshell_websocket_sent_wire_bytes_total metrics
+	EnableCompression bool
+	// Env is a list of "NAME=value" environment variables set in every session,
+	// after the inherited ones. Values are text/template templates executed
+	// with the session's .Account, .Profile, .RemoteAddr, .SessionID and .User,
+	// as in "CLOUDSHELL_USER={{.User}}". The Env of a profile is set after them
+	Env []string
+	// EnvAllowlist is a list of glob patterns, as in "LC_*", of the handler's
+	// environment variables that sessions inherit. When not specified, every
+	// variable not matching EnvDenylist is inherited, except by sessions
+	// started as another user, see RunAsUser, which inherit none
+	EnvAllowlist []string
+	// EnvDenylist is a list of glob patterns of the handler's environment
+	// variables that sessions never inherit, even when matching EnvAllowlist
+	EnvDenylist []string
+	// FlowControlHighWatermarkBytes defines how much output may be sent to a
+	// frontend acknowledging output, as ProtocolV1 frontends do, before reading
+	// from the tty stops. Output already read, up to a frame and a few reads, is
+	// still sent. When not specified, DefaultFlowControlHighWatermarkBytes is
+	// used
+	FlowControlHighWatermarkBytes int
+	// FlowControlLowWatermarkBytes defines how little output must remain
+	// unacknowledged for reading from the tty to resume. When not specified, or
+	// not below FlowControlHighWatermarkBytes, a quarter of the high watermark
+	// is used
+	FlowControlLowWatermarkBytes int
+	// GetUser when specified should return the identity of the authenticated user
+	// making the request. Sessions are attributed to that user and can only be
+	// reattached by the same user
+	GetUser func(*http.Request) string
 	// KeepalivePingTimeout defines the maximum duration between which a ping and pong
 	// cycle should be tolerated, beyond this the connection should be deemed dead
 	KeepalivePingTimeout time.Duration
 	MaxBufferSizeBytes   int
+	// MaxOutputFrameSizeBytes defines the size at which output coalesced into a
+	// websocket frame is sent without waiting for OutputLatencyWindow to
+	// elapse. When not specified, DefaultMaxOutputFrameSizeBytes is used
+	MaxOutputFrameSizeBytes int
+	// OutputLatencyWindow defines how long output read from the tty may be held
+	// back so that output read after it is sent in the same websocket frame.
+	// When not specified, DefaultOutputLatencyWindow is used; when negative,
+	// every read from the tty is sent in a frame of its own
+	OutputLatencyWindow time.Duration
+	// OutputTextFrames when true sends output to legacy and ProtocolV1 frontends
+	// in text frames that always hold valid UTF-8, replacing invalid bytes with
+	// U+FFFD, for clients that decode every frame as text. ttyd frontends are
+	// always sent binary frames
+	OutputTextFrames bool
+	// Profiles are the commands, by name, that frontends can start instead of
+	// Command using the "profile" query parameter. A user with an entry in
+	// UserCommands always starts that command
+	Profiles map[string]Profile
+	// RecordInput when true includes the input sent by xterm.js in session
+	// recordings
+	RecordInput bool
+	// RecordingDirectory when specified is the directory in which every session
+	// is recorded as an asciicast v2 file
+	RecordingDirectory string
+	// ResourceLimits are the limits applied to the processes of every session
+	ResourceLimits ResourceLimits
+	// RunAsUser when specified is the Unix user, by name or uid, that sessions
+	// are started as, in a login environment of that user's with HOME, USER,
+	// LOGNAME and SHELL set and in the user's home directory unless a
+	// WorkingDirectory is specified. The handler must run as root to switch
+	// users. An entry in UserAccounts takes precedence
+	RunAsUser string
+	// Sandbox isolates every session in namespaces of its own when enabled
+	Sandbox Sandbox
+	// SessionDetachTimeout defines how long the spawned process is kept alive after
+	// its connection is lost so that the frontend can reattach to it using the
+	// "session" query parameter, see ProtocolV1 for resuming its output. When
+	// zero, the process is stopped as soon as the connection is lost
+	SessionDetachTimeout time.Duration
+	// SessionManager keeps track of the sessions spawned by the handler. When not
+	// specified, the handler uses a SessionManager of its own
+	SessionManager *SessionManager
+	// SessionReplayBufferSizeBytes defines how much of the most recent output of
+	// a session is kept to be replayed when the frontend reattaches, either
+	// from the sequence number it asks for or from the first byte that was not
+	// sent to the previous connection. When not specified,
+	// DefaultSessionReplayBufferSizeBytes is used
+	SessionReplayBufferSizeBytes int
+	// Subreaper when true makes the process of the handler adopt the orphaned
+	// processes of sessions, such as the daemons started in them, so that the
+	// processes remaining in the session of a closed one are terminated and
+	// those exiting are reaped. As every child exiting without having been
+	// started by the handler is then reaped, it must not be set when the
+	// embedding program waits for children of its own. Only Linux supports it
+	Subreaper bool
+	// TerminationGracePeriod defines how long the processes of a closed session
+	// are given to exit after each of SIGHUP and SIGTERM before they are killed.
+	// When not specified, DefaultTerminationGracePeriod is used
+	TerminationGracePeriod time.Duration
+	// TtydAuthToken when specified must be sent as the AuthToken of the init
+	// message of ttyd frontends
+	TtydAuthToken string
+	// TtydProtocol when true offers ProtocolTtyd to frontends so that ttyd
+	// clients and the ttyd web UI can connect
+	TtydProtocol bool
+	// UserAccounts maps the identity of a user, as returned by GetUser, to the
+	// Unix user, by name or uid, that the sessions of that user are started as
+	// in place of RunAsUser
+	UserAccounts map[string]string
+	// UserCommands maps the identity of a user, as returned by GetUser, to the
+	// command and arguments started for that user in place of Command and
+	// Arguments
+	UserCommands map[string][]string
+	// WorkingDirectory when specified is the directory sessions are started in,
+	// unless their profile has a WorkingDirectory of its own. It is a
+	// text/template template executed like the values of Env, as in
+	// "/home/{{.User}}", with path separators in the values replaced by "_".
+	// Sessions for which a path element expands to nothing, such as those of
+	// anonymous users in "/home/{{.User}}", are refused. A missing directory
+	// is created, see WorkingDirectorySkeleton. When not
+	// specified, sessions start in the handler's working directory, or the
+	// home directory of the user they run as
+	WorkingDirectory string
+	// WorkingDirectorySkeleton when specified is a directory whose contents are
+	// copied into working directories when they are created, like /etc/skel
+	WorkingDirectorySkeleton string
 }
 
 func GetHandler(opts HandlerOpts) func(http.ResponseWriter, *http.Request) {
+	sessions := opts.SessionManager
+	if sessions == nil {
+		sessions = NewSessionManager()
+	}
+	if opts.Subreaper {
+		becomeSubreaperOnce.Do(func() {
+			if err := becomeSubreaper(); err != nil {
+				log.Warnf("failed to become a subreaper, orphaned processes of sessions will not be reaped: %s", err)
+				return
+			}
+			orphans.watch()
+		})
+	}
+	hostname, _ := os.Hostname()
+	outputLatencyWindow := opts.OutputLatencyWindow
+	if outputLatencyWindow == 0 {
+		outputLatencyWindow = DefaultOutputLatencyWindow
+	}
+	maxOutputFrameSizeBytes := opts.MaxOutputFrameSizeBytes
+	if maxOutputFrameSizeBytes <= 0 {
+		maxOutputFrameSizeBytes = DefaultMaxOutputFrameSizeBytes
+	}
+	output := newOutputPump(opts.MaxBufferSizeBytes, maxOutputFrameSizeBytes, outputLatencyWindow)
+	writeBufferPool := &sync.Pool{}
+	compressionLevel := opts.CompressionLevel
+	if compressionLevel == 0 {
+		compressionLevel = DefaultCompressionLevel
+	}
+	if compressionLevel < flate.HuffmanOnly || compressionLevel > flate.BestCompression {
+		log.Warnf("invalid compression level %v, using %v", compressionLevel, DefaultCompressionLevel)
+		compressionLevel = DefaultCompressionLevel
+	}
+	compressionThresholdBytes := opts.CompressionThresholdBytes
+	if compressionThresholdBytes <= 0 {
+		compressionThresholdBytes = DefaultCompressionThresholdBytes
+	}
+	allowedOrigins, allowedOriginsErr := compileOriginPatterns(opts.AllowedOrigins)
+	if allowedOriginsErr != nil {
+		log.Errorf("refusing all websocket connections: %s", allowedOriginsErr)
+	}
+	environmentTemplates, environmentErr := compileEnvironmentTemplates(opts.Env)
+	if environmentErr == nil {
+		environmentErr = ValidateEnvironment(nil, opts.EnvAllowlist, opts.EnvDenylist)
+	}
+	if environmentErr != nil {
+		log.Errorf("refusing all websocket connections: %s", environmentErr)
+	}
+	sandboxErr := ValidateSandbox(opts.Sandbox)
+	if sandboxErr != nil {
+		log.Errorf("refusing all websocket connections: %s", sandboxErr)
+	}
 	return func(w http.ResponseWriter, r *http.Request) {
+		if allowedOriginsErr != nil || environmentErr != nil || sandboxErr != nil {
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
 		connectionErrorLimit := opts.ConnectionErrorLimit
 		if connectionErrorLimit < 0 {
 			connectionErrorLimit = DefaultConnectionErrorLimit
@@ -51,6 +255,14 @@ func GetHandler(opts HandlerOpts) func(http.ResponseWriter, *http.Request) {
 		if keepalivePingTimeout <= time.Second {
 			keepalivePingTimeout = 20 * time.Second
 		}
+		sessionReplayBufferSizeBytes := opts.SessionReplayBufferSizeBytes
+		if sessionReplayBufferSizeBytes <= 0 {
+			sessionReplayBufferSizeBytes = DefaultSessionReplayBufferSizeBytes
+		}
+		terminationGracePeriod := opts.TerminationGracePeriod
+		if terminationGracePeriod <= 0 {
+			terminationGracePeriod = DefaultTerminationGracePeriod
+		}
 
 		connectionUUID, err := uuid.NewUUID()
 		if err != nil {
@@ -64,47 +276,292 @@ func GetHandler(opts HandlerOpts) func(http.ResponseWriter, *http.Request) {
 		if opts.CreateLogger != nil {
 			clog = opts.CreateLogger(connectionUUID.String(), r)
 		}
-		clog.Info("established connection identity")
+		user := ""
+		if opts.GetUser != nil {
+			user = opts.GetUser(r)
+		}
+		if user != "" {
+			clog.Infof("established connection identity for user '%s'", user)
+		} else {
+			clog.Info("established connection identity")
+		}
 
 		allowedHostnames := opts.AllowedHostnames
-		upgrader := getConnectionUpgrader(allowedHostnames, maxBufferSizeBytes, clog)
-		connection, err := upgrader.Upgrade(w, r, nil)
+		upgrader := getConnectionUpgrader(allowedHostnames, allowedOrigins, getSubprotocols(opts.TtydProtocol), opts.EnableCompression, maxBufferSizeBytes, output.maxFrameSizeBytes, writeBufferPool, clog)
+		wireCounter := &wireCountingResponseWriter{ResponseWriter: w}
+		connection, err := upgrader.Upgrade(wireCounter, r, nil)
 		if err != nil {
 			clog.Warnf("failed to upgrade connection: %s", err)
 			return
 		}
+		compressed := opts.EnableCompression && compressionRequested(r.Header)
+		if compressed {
+			clog.Infof("compressing messages of at least %v bytes at level %v", compressionThresholdBytes, compressionLevel)
+			connection.SetCompressionLevel(compressionLevel)
+		}
+		compression := newCompressionPolicy(compressed, compressionThresholdBytes)
+		wireCounter.countBytes(compressed)
+		proto := negotiatedProtocol(connection.Subprotocol(), opts.OutputTextFrames)
+		if subprotocol := connection.Subprotocol(); subprotocol != "" {
+			clog.Infof("speaking protocol '%s'", subprotocol)
+		} else {
+			clog.Info("speaking legacy protocol")
+		}
 
-		terminal := opts.Command
-		args := opts.Arguments
-		clog.Debugf("starting new tty using command '%s' with arguments ['%s']...", terminal, strings.Join(args, "', '"))
-		cmd := exec.Command(terminal, args...)
-		cmd.Env = os.Environ()
-		tty, err := pty.Start(cmd)
-		if err != nil {
-			message := fmt.Sprintf("failed to start tty: %s", err)
+		// some protocols start with an init message authenticating the
+		// frontend and reporting its size
+		var initialSize TTYSize
+		if !proto.initialized() {
+			connection.SetReadDeadline(time.Now().Add(keepalivePingTimeout))
+			messageType, data, err := connection.ReadMessage()
+			if err != nil {
+				clog.Warnf("failed to read init message: %s", err)
+				connection.Close()
+				return
+			}
+			connection.SetReadDeadline(time.Time{})
+			msg, err := proto.decode(messageType, data)
+			if err == nil && msg.Type != messageInit {
+				err = errors.New("expected an init message")
+			}
+			if err != nil {
+				message := fmt.Sprintf("failed to initialize connection: %s", err)
+				clog.Warn(message)
+				refuseConnection(connection, proto, compression, websocket.CloseProtocolError, message)
+				return
+			}
+			if opts.TtydAuthToken != "" && subtle.ConstantTimeCompare(msg.Data, []byte(opts.TtydAuthToken)) != 1 {
+				message := "invalid auth token"
+				clog.Warn(message)
+				refuseConnection(connection, proto, compression, websocket.ClosePolicyViolation, message)
+				return
+			}
+			initialSize = msg.Size
+		}
+
+		// reattach to an existing session when the frontend asks for one,
+		// resuming its output from the sequence number it asks for
+		var s *session
+		sequence := int64(-1)
+		if sessionID := r.URL.Query().Get("session"); sessionID != "" && opts.SessionDetachTimeout > 0 {
+			s = sessions.get(sessionID)
+			if s != nil && s.user != user {
+				clog.Warnf("refusing to attach user '%s' to session '%s' of user '%s'", user, sessionID, s.user)
+				s = nil
+			}
+			if s == nil {
+				clog.Warnf("failed to find session '%s', starting a new one", sessionID)
+			} else {
+				clog.Infof("reattaching to session '%s'...", sessionID)
+				if value := r.URL.Query().Get("sequence"); value != "" {
+					if parsed, err := strconv.ParseInt(value, 10, 64); err == nil && parsed >= 0 {
+						sequence = parsed
+					} else {
+						clog.Warnf("ignoring invalid sequence number '%s'", value)
+					}
+				}
+			}
+		}
+
+		if s == nil && sessions.Draining() {
+			message := "server is shutting down, not starting a new session"
 			clog.Warn(message)
-			connection.WriteMessage(websocket.TextMessage, []byte(message))
+			refuseConnection(connection, proto, compression, websocket.CloseTryAgainLater, message)
 			return
 		}
-		defer func() {
-			clog.Info("gracefully stopping spawned tty...")
-			if err := cmd.Process.Kill(); err != nil {
-				clog.Warnf("failed to kill process: %s", err)
+		if s == nil {
+			sessionUUID, err := uuid.NewRandom()
+			if err != nil {
+				message := fmt.Sprintf("failed to get a session uuid: %s", err)
+				clog.Warn(message)
+				refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, message)
+				return
+			}
+			profileName := r.URL.Query().Get("profile")
+			profile := Profile{Command: opts.Command, Arguments: opts.Arguments}
+			if profileName != "" {
+				selected, ok := opts.Profiles[profileName]
+				if !ok {
+					message := fmt.Sprintf("unknown profile '%s'", profileName)
+					clog.Warn(message)
+					refuseConnection(connection, proto, compression, websocket.ClosePolicyViolation, message)
+					return
+				}
+				profile = selected
 			}
-			if _, err := cmd.Process.Wait(); err != nil {
-				clog.Warnf("failed to wait for process to exit: %s", err)
+			if userCommand, ok := opts.UserCommands[user]; ok && user != "" && len(userCommand) > 0 {
+				if profileName != "" {
+					clog.Warnf("ignoring profile '%s' as user '%s' has a command of their own", profileName, user)
+					profileName = ""
+				}
+				profile = Profile{Command: userCommand[0], Arguments: userCommand[1:]}
 			}
-			if err := tty.Close(); err != nil {
-				clog.Warnf("failed to close spawned tty gracefully: %s", err)
+			terminal := profile.Command
+			args := profile.Arguments
+			clog.Debugf("starting new tty using command '%s' with arguments ['%s']...", terminal, strings.Join(args, "', '"))
+			cmd := exec.Command(terminal, args...)
+			cmd.Env = filterEnvironment(os.Environ(), opts.EnvAllowlist, opts.EnvDenylist)
+			accountName := opts.RunAsUser
+			if userAccount, ok := opts.UserAccounts[user]; ok && user != "" && userAccount != "" {
+				accountName = userAccount
 			}
-			if err := connection.Close(); err != nil {
-				clog.Warnf("failed to close webscoket connection: %s", err)
+			var runAs *account
+			if accountName != "" {
+				runAs, err = lookupAccount(accountName)
+				if err != nil {
+					message := fmt.Sprintf("failed to find user '%s' to start the session as: %s", accountName, err)
+					clog.Warn(message)
+					refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, message)
+					return
+				}
+				clog.Debugf("starting tty as user '%s' (uid %v, gid %v)...", runAs.name, runAs.uid, runAs.gid)
 			}
-		}()
+			data := sessionTemplateData{Profile: profileName, RemoteAddr: r.RemoteAddr, SessionID: sessionUUID.String(), User: user}
+			if runAs != nil {
+				data.Account = runAs.name
+			}
+			workingDirectory := opts.WorkingDirectory
+			if profile.WorkingDirectory != "" {
+				workingDirectory = profile.WorkingDirectory
+			}
+			if workingDirectory != "" {
+				cmd.Dir, err = expandWorkingDirectory(workingDirectory, data)
+				if err == nil {
+					err = createWorkingDirectory(cmd.Dir, opts.WorkingDirectorySkeleton, runAs)
+				}
+				if err != nil {
+					message := fmt.Sprintf("failed to prepare working directory: %s", err)
+					clog.Warn(message)
+					refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, message)
+					return
+				}
+			}
+			if runAs != nil {
+				if len(opts.EnvAllowlist) == 0 {
+					cmd.Env = []string{}
+				}
+				runAs.prepare(cmd)
+			}
+			env, err := expandEnvironment(environmentTemplates, data)
+			if err != nil {
+				clog.Warn(err.Error())
+				refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, err.Error())
+				return
+			}
+			cmd.Env = append(cmd.Env, env...)
+			cmd.Env = append(cmd.Env, profile.Env...)
+			limits, err := newResourceLimits(opts.ResourceLimits, sessionUUID.String(), clog)
+			if err != nil {
+				message := fmt.Sprintf("failed to prepare resource limits: %s", err)
+				clog.Warn(message)
+				refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, message)
+				return
+			}
+			// what the session runs, before helpers are put in front of it
+			command, arguments := cmd.Path, cmd.Args[1:]
+			limits.prepare(cmd)
+			sandbox, err := newSandbox(opts.Sandbox, runAs)
+			if err == nil {
+				homeSource := ""
+				if workingDirectory != "" {
+					homeSource = cmd.Dir
+				}
+				err = sandbox.prepare(cmd, homeSource)
+			}
+			if err != nil {
+				limits.release()
+				message := fmt.Sprintf("failed to prepare sandbox: %s", err)
+				clog.Warn(message)
+				refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, message)
+				return
+			}
+			var tty *os.File
+			err = orphans.start(cmd, func() (err error) {
+				tty, err = startTTY(cmd, runAs, sandbox != nil)
+				return err
+			})
+			if err != nil {
+				err = sandbox.explain(err)
+			} else {
+				limits.started()
+				if sandbox != nil {
+					tty, err = sandbox.start()
+				}
+				if err != nil {
+					cmd.Process.Kill()
+					cmd.Wait()
+				}
+			}
+			if err != nil {
+				limits.release()
+				sandbox.close()
+				message := fmt.Sprintf("failed to start tty: %s", err)
+				clog.Warn(message)
+				refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, message)
+				return
+			}
+			s = newSession(sessionUUID.String(), cmd, tty, opts.SessionDetachTimeout, terminationGracePeriod, sessionReplayBufferSizeBytes, clog)
+			s.command = command
+			s.arguments = arguments
+			if runAs != nil {
+				s.account = runAs.name
+			}
+			s.flow = newFlowControl(opts.FlowControlHighWatermarkBytes, opts.FlowControlLowWatermarkBytes)
+			s.limits = limits
+			s.profile = profileName
+			s.user = user
+			if opts.RecordingDirectory != "" {
+				s.recorder, err = newRecorder(opts.RecordingDirectory, s.id, terminal, user, opts.RecordInput)
+				if err != nil {
+					message := fmt.Sprintf("failed to start session recording: %s", err)
+					clog.Warn(message)
+					s.close()
+					refuseConnection(connection, proto, compression, websocket.CloseInternalServerErr, message)
+					return
+				}
+				clog.Infof("recording session to '%s'", s.recorder.name())
+			}
+			sessions.add(s)
+			go pumpOutput(s, output, connectionErrorLimit)
+			if limits != nil {
+				go watchResourceLimits(s)
+			}
+		}
+
+		if err := s.attach(connection, proto, compression, r.RemoteAddr, sequence); err != nil {
+			clog.Warnf("failed to attach connection to session '%s': %s", s.id, err)
+			s.detach(connection)
+			connection.Close()
+			return
+		}
+		if opts.SessionDetachTimeout > 0 {
+			if err := s.send(message{Type: MessageSession, Data: []byte(s.id)}); err != nil {
+				clog.Warnf("failed to announce session '%s' to xterm.js: %s", s.id, err)
+			}
+		}
+		title := hostname
+		if user != "" {
+			title = user + "@" + hostname
+		}
+		if err := s.send(message{Type: MessageTitle, Data: []byte(title)}); err != nil {
+			clog.Warnf("failed to send title to xterm.js: %s", err)
+		}
+		if err := s.send(message{Type: messagePreferences, Data: []byte("{}")}); err != nil {
+			clog.Warnf("failed to send preferences to xterm.js: %s", err)
+		}
+		if initialSize.Cols > 0 && initialSize.Rows > 0 {
+			clog.Infof("resizing tty to use %v rows and %v columns...", initialSize.Rows, initialSize.Cols)
+			if err := s.resize(initialSize); err != nil {
+				clog.Warnf("failed to resize tty, error: %s", err)
+			}
+		}
 
-		var connectionClosed bool
-		var waiter sync.WaitGroup
-		waiter.Add(1)
+		var connectionClosed atomic.Bool
+		var closeOnce sync.Once
+		disconnected := make(chan struct{})
+		disconnect := func() {
+			closeOnce.Do(func() { close(disconnected) })
+		}
 
 		// this is a keep-alive loop that ensures connection does not hang-up itself
 		lastPongTime := time.Now()
@@ -114,108 +571,124 @@ func GetHandler(opts HandlerOpts) func(http.ResponseWriter, *http.Request) {
 		})
 		go func() {
 			for {
-				if err := connection.WriteMessage(websocket.PingMessage, []byte("keepalive")); err != nil {
+				if err := connection.WriteControl(websocket.PingMessage, []byte("keepalive"), time.Now().Add(keepalivePingTimeout)); err != nil {
 					clog.Warn("failed to write ping message")
+					disconnect()
 					return
 				}
-				time.Sleep(keepalivePingTimeout / 2)
+				select {
+				case <-disconnected:
+					return
+				case <-time.After(keepalivePingTimeout / 2):
+				}
 				if time.Since(lastPongTime) > keepalivePingTimeout {
 					clog.Warn("failed to get response from ping, triggering disconnect now...")
-					waiter.Done()
+					disconnect()
 					return
 				}
 				clog.Debug("received response from ping successfully")
 			}
 		}()
 
-		// tty >> xterm.js
-		go func() {
-			errorCounter := 0
-			for {
-				// consider the connection closed/errored out so that the socket handler
-				// can be terminated - this frees up memory so the service doesn't get
-				// overloaded
-				if errorCounter > connectionErrorLimit {
-					waiter.Done()
-					break
-				}
-				buffer := make([]byte, maxBufferSizeBytes)
-				readLength, err := tty.Read(buffer)
-				if err != nil {
-					clog.Warnf("failed to read from tty: %s", err)
-					if err := connection.WriteMessage(websocket.TextMessage, []byte("bye!")); err != nil {
-						clog.Warnf("failed to send termination message from tty to xterm.js: %s", err)
-					}
-					waiter.Done()
-					return
-				}
-				if err := connection.WriteMessage(websocket.BinaryMessage, buffer[:readLength]); err != nil {
-					clog.Warnf("failed to send %v bytes from tty to xterm.js", readLength)
-					errorCounter++
-					continue
-				}
-				clog.Tracef("sent message of size %v bytes from tty to xterm.js", readLength)
-				errorCounter = 0
-			}
-		}()
-
 		// tty << xterm.js
 		go func() {
 			for {
 				// data processing
 				messageType, data, err := connection.ReadMessage()
 				if err != nil {
-					if !connectionClosed {
+					if !connectionClosed.Load() {
 						clog.Warnf("failed to get next reader: %s", err)
 					}
+					disconnect()
 					return
 				}
-				dataLength := len(data)
-				dataBuffer := bytes.Trim(data, "\x00")
 				dataType, ok := WebsocketMessageType[messageType]
 				if !ok {
 					dataType = "uunknown"
 				}
-				clog.Infof("received %s (type: %v) message of size %v byte(s) from xterm.js with key sequence: %v", dataType, messageType, dataLength, dataBuffer)
+				clog.Infof("received %s (type: %v) message of size %v byte(s) from xterm.js", dataType, messageType, len(data))
 
-				// process
-				if dataLength == -1 { // invalid
-					clog.Warn("failed to get the correct number of bytes read, ignoring message")
+				msg, err := proto.decode(messageType, data)
+				if err != nil {
+					clog.Warnf("ignoring message from xterm.js: %s", err)
+					if err := s.send(message{Type: MessageError, Data: []byte(err.Error())}); err != nil {
+						clog.Warnf("failed to send error to xterm.js: %s", err)
+					}
 					continue
 				}
 
-				// handle resizing
-				if messageType == websocket.BinaryMessage {
-					if dataBuffer[0] == 1 {
-						ttySize := &TTYSize{}
-						resizeMessage := bytes.Trim(dataBuffer[1:], " \n\r\t\x00\x01")
-						if err := json.Unmarshal(resizeMessage, ttySize); err != nil {
-							clog.Warnf("failed to unmarshal received resize message '%s': %s", string(resizeMessage), err)
-							continue
-						}
-						clog.Infof("resizing tty to use %v rows and %v columns...", ttySize.Rows, ttySize.Cols)
-						if err := pty.Setsize(tty, &pty.Winsize{
-							Rows: ttySize.Rows,
-							Cols: ttySize.Cols,
-						}); err != nil {
-							clog.Warnf("failed to resize tty, error: %s", err)
-						}
+				switch msg.Type {
+				case MessageResize:
+					clog.Infof("resizing tty to use %v rows and %v columns...", msg.Size.Rows, msg.Size.Cols)
+					if err := s.resize(msg.Size); err != nil {
+						clog.Warnf("failed to resize tty, error: %s", err)
+					}
+				case MessageAck:
+					s.flow.acknowledge(msg.Length)
+				case messagePause:
+					clog.Debug("pausing output...")
+					s.flow.pause()
+				case messageResume:
+					clog.Debug("resuming output...")
+					s.flow.resume()
+				case MessagePing:
+					if err := s.send(message{Type: MessagePong, Data: msg.Data}); err != nil {
+						clog.Warnf("failed to send pong to xterm.js: %s", err)
+					}
+				case MessageInput:
+					clog.Tracef("received key sequence: %v", msg.Data)
+					s.recorder.input(msg.Data)
+					bytesWritten, err := s.tty.Write(msg.Data)
+					s.bytesIn.Add(uint64(bytesWritten))
+					if err != nil {
+						clog.Warn(fmt.Sprintf("failed to write %v bytes to tty: %s", len(msg.Data), err))
 						continue
 					}
+					clog.Tracef("%v bytes written to tty...", bytesWritten)
 				}
-
-				// write to tty
-				bytesWritten, err := tty.Write(dataBuffer)
-				if err != nil {
-					clog.Warn(fmt.Sprintf("failed to write %v bytes to tty: %s", len(dataBuffer), err))
-					continue
-				}
-				clog.Tracef("%v bytes written to tty...", bytesWritten)
 			}
 		}()
 
-		waiter.Wait()
+		select {
+		case <-disconnected:
+			s.detach(connection)
+		case <-s.done:
+		}
 		log.Info("closing connection...")
-		connectionClosed = true
+		connectionClosed.Store(true)
+		disconnect()
+		connection.Close()
 	}
 }
+
+// pumpOutput copies output from the session's tty to whichever connection is
+// attached to the session until the spawned process exits
+func pumpOutput(s *session, pump *outputPump, connectionErrorLimit int) {
+	errorCounter := 0
+	err := pump.run(s.tty, s.flow.wait, func(data []byte) {
+		s.bytesOut.Add(uint64(len(data)))
+		s.recorder.output(data)
+	}, func(frame []byte) {
+		// consider the connection closed/errored out so that the socket handler
+		// can be terminated - this frees up memory so the service doesn't get
+		// overloaded
+		if errorCounter > connectionErrorLimit {
+			s.mutex.Lock()
+			connection := s.connection
+			s.mutex.Unlock()
+			if connection != nil {
+				connection.Close()
+			}
+			errorCounter = 0
+		}
+		if err := s.write(frame); err != nil {
+			s.logger.Warnf("failed to send %v bytes from tty to xterm.js", len(frame))
+			errorCounter++
+			return
+		}
+		s.logger.Tracef("sent message of size %v bytes from tty to xterm.js", len(frame))
+		errorCounter = 0
+	})
+	s.logger.Warnf("failed to read from tty: %s", err)
+	s.close()
+}
@@ -0,0 +1,153 @@
+package xtermjs
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// originPattern is a compiled entry of HandlerOpts.AllowedOrigins.
+type originPattern struct {
+	// any matches every origin
+	any bool
+	// regex, when set, is matched against the complete origin
+	regex *regexp.Regexp
+	// scheme, when set, must equal the scheme of the origin
+	scheme string
+	// host is matched against the hostname of the origin; a leading "*."
+	// matches any subdomain
+	host string
+	// port, when set, must equal the port of the origin
+	port string
+}
+
+const originRegexPrefix = "regex:"
+
+var defaultPorts = map[string]string{
+	"http":  "80",
+	"https": "443",
+}
+
+// parseOrigin splits an origin into its lower-cased scheme, hostname and
+// port, filling in the default port of the scheme.
+func parseOrigin(origin string) (string, string, string, error) {
+	u, err := url.Parse(origin)
+	if err != nil {
+		return "", "", "", err
+	}
+	if u.Scheme == "" || u.Host == "" {
+		return "", "", "", fmt.Errorf("'%s' is not an origin", origin)
+	}
+	scheme := strings.ToLower(u.Scheme)
+	port := u.Port()
+	if port == "" {
+		port = defaultPorts[scheme]
+	}
+	return scheme, strings.ToLower(u.Hostname()), port, nil
+}
+
+// compileOriginPatterns compiles the entries of HandlerOpts.AllowedOrigins.
+// Entries take one of the forms:
+//
+//   - "*" allows every origin
+//   - "regex:<expression>" matches the complete origin against expression
+//   - "[scheme://]host[:port]" where host may start with "*." to allow any
+//     subdomain of the remaining name. Omitting the scheme or port allows
+//     any scheme or port.
+func compileOriginPatterns(entries []string) ([]originPattern, error) {
+	patterns := []originPattern{}
+	for _, entry := range entries {
+		entry = strings.TrimSpace(entry)
+		switch {
+		case entry == "":
+			continue
+		case entry == "*":
+			patterns = append(patterns, originPattern{any: true})
+		case strings.HasPrefix(entry, originRegexPrefix):
+			// the expression is anchored so that it cannot match a prefix of
+			// an origin under another domain
+			regex, err := regexp.Compile("^(?:" + strings.TrimPrefix(entry, originRegexPrefix) + ")$")
+			if err != nil {
+				return nil, fmt.Errorf("invalid allowed origin '%s': %w", entry, err)
+			}
+			patterns = append(patterns, originPattern{regex: regex})
+		default:
+			pattern := originPattern{}
+			hostPort := entry
+			if scheme, rest, found := strings.Cut(entry, "://"); found {
+				pattern.scheme = strings.ToLower(scheme)
+				hostPort = rest
+			}
+			hostPort = strings.TrimSuffix(hostPort, "/")
+			if host, port, err := net.SplitHostPort(hostPort); err == nil {
+				pattern.host, pattern.port = host, port
+			} else {
+				pattern.host = strings.Trim(hostPort, "[]")
+			}
+			pattern.host = strings.ToLower(pattern.host)
+			if pattern.host == "" || strings.Contains(pattern.host, "/") || strings.Contains(strings.TrimPrefix(pattern.host, "*."), "*") {
+				return nil, fmt.Errorf("invalid allowed origin '%s'", entry)
+			}
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns, nil
+}
+
+// matches reports whether the origin, given whole and in its parsed parts,
+// is allowed by the pattern.
+func (pattern originPattern) matches(origin string, scheme string, host string, port string) bool {
+	switch {
+	case pattern.any:
+		return true
+	case pattern.regex != nil:
+		return pattern.regex.MatchString(origin)
+	case pattern.scheme != "" && pattern.scheme != scheme:
+		return false
+	case pattern.port != "" && pattern.port != port:
+		return false
+	case strings.HasPrefix(pattern.host, "*."):
+		return strings.HasSuffix(host, pattern.host[1:])
+	}
+	return pattern.host == host
+}
+
+// ValidateAllowedOrigins returns an error describing the first entry of
+// HandlerOpts.AllowedOrigins that cannot be used.
+func ValidateAllowedOrigins(entries []string) error {
+	_, err := compileOriginPatterns(entries)
+	return err
+}
+
+// checkOrigin reports whether a websocket may be opened from origin. When no
+// patterns are given, only same-origin requests to requestHost are allowed.
+// Requests without an Origin header do not come from a browser and cannot be
+// used for cross-site websocket hijacking, so they are allowed.
+func checkOrigin(patterns []originPattern, origin string, requestHost string) (bool, error) {
+	if origin == "" {
+		return true, nil
+	}
+	scheme, host, port, err := parseOrigin(origin)
+	if err != nil {
+		return false, err
+	}
+	if len(patterns) == 0 {
+		return strings.EqualFold(net.JoinHostPort(host, port), withDefaultPort(requestHost, port)), nil
+	}
+	for _, pattern := range patterns {
+		if pattern.matches(origin, scheme, host, port) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+// withDefaultPort returns hostPort with port appended when it has none.
+func withDefaultPort(hostPort string, port string) string {
+	if host, hostPortPort, err := net.SplitHostPort(hostPort); err == nil {
+		return net.JoinHostPort(host, hostPortPort)
+	}
+	return net.JoinHostPort(strings.Trim(hostPort, "[]"), port)
+}
@@ -0,0 +1,79 @@
+package xtermjs
+
+import (
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestCheckOrigin(test *testing.T) {
+	testCases := []struct {
+		allowedOrigins []string
+		origin         string
+		requestHost    string
+		expected       bool
+	}{
+		// Same-origin only when nothing is configured.
+		{origin: "", requestHost: "shell.example.com", expected: true},
+		{origin: "https://shell.example.com", requestHost: "shell.example.com", expected: true},
+		{origin: "https://shell.example.com", requestHost: "shell.example.com:443", expected: true},
+		{origin: "http://localhost:8261", requestHost: "localhost:8261", expected: true},
+		{origin: "http://localhost:8262", requestHost: "localhost:8261", expected: false},
+		{origin: "https://evil.example", requestHost: "shell.example.com", expected: false},
+		{origin: "null", requestHost: "shell.example.com", expected: false},
+
+		// Exact entries, with and without scheme and port.
+		{allowedOrigins: []string{"https://app.example.com"}, origin: "https://app.example.com", expected: true},
+		{allowedOrigins: []string{"https://app.example.com"}, origin: "https://APP.example.com", expected: true},
+		{allowedOrigins: []string{"https://app.example.com"}, origin: "http://app.example.com", expected: false},
+		{allowedOrigins: []string{"https://app.example.com:443"}, origin: "https://app.example.com", expected: true},
+		{allowedOrigins: []string{"https://app.example.com:8443"}, origin: "https://app.example.com", expected: false},
+		{allowedOrigins: []string{"app.example.com"}, origin: "http://app.example.com:3000", expected: true},
+		{allowedOrigins: []string{"app.example.com"}, origin: "https://app.example.com.evil.example", expected: false},
+
+		// Wildcard subdomains.
+		{allowedOrigins: []string{"https://*.example.com"}, origin: "https://a.b.example.com", expected: true},
+		{allowedOrigins: []string{"https://*.example.com"}, origin: "https://example.com", expected: false},
+		{allowedOrigins: []string{"https://*.example.com"}, origin: "https://evilexample.com", expected: false},
+		{allowedOrigins: []string{"https://*.example.com"}, origin: "http://a.example.com", expected: false},
+
+		// Regular expressions and the catch-all.
+		{allowedOrigins: []string{`regex:^https://[a-z]+\.example\.com$`}, origin: "https://app.example.com", expected: true},
+		{allowedOrigins: []string{`regex:^https://[a-z]+\.example\.com$`}, origin: "https://app.example.com.evil.example", expected: false},
+		{allowedOrigins: []string{`regex:https://.*\.example\.com`}, origin: "https://x.example.com", expected: true},
+		{allowedOrigins: []string{`regex:https://.*\.example\.com`}, origin: "https://x.example.com.attacker.net", expected: false},
+		{allowedOrigins: []string{`regex:https://.*\.example\.com`}, origin: "evil://https://x.example.com", expected: false},
+		{allowedOrigins: []string{`regex:https://a\.example\.com|https://b\.example\.com`}, origin: "https://b.example.com.attacker.net", expected: false},
+		{allowedOrigins: []string{"*"}, origin: "https://anything.example", expected: true},
+	}
+	for _, testCase := range testCases {
+		patterns, err := compileOriginPatterns(testCase.allowedOrigins)
+		if err != nil {
+			test.Fatal(err)
+		}
+		actual, _ := checkOrigin(patterns, testCase.origin, testCase.requestHost)
+		if actual != testCase.expected {
+			test.Errorf("checkOrigin(%v, %q, %q): expected %v, got %v", testCase.allowedOrigins, testCase.origin, testCase.requestHost, testCase.expected, actual)
+		}
+	}
+}
+
+func TestValidateAllowedOrigins(test *testing.T) {
+	testCases := []struct {
+		allowedOrigins []string
+		valid          bool
+	}{
+		{allowedOrigins: []string{"https://app.example.com", "*.example.com", "localhost:8261"}, valid: true},
+		{allowedOrigins: []string{"regex:("}, valid: false},
+		{allowedOrigins: []string{"https://app.*.com"}, valid: false},
+		{allowedOrigins: []string{"https://"}, valid: false},
+	}
+	for _, testCase := range testCases {
+		err := ValidateAllowedOrigins(testCase.allowedOrigins)
+		if (err == nil) != testCase.valid {
+			test.Errorf("ValidateAllowedOrigins(%v): expected valid %v, got error %v", testCase.allowedOrigins, testCase.valid, err)
+		}
+	}
+}
@@ -0,0 +1,70 @@
+package xtermjs
+
+// outputBuffer is a ring buffer keeping the most recent bytes of a session's
+// output up to a maximum size. Every byte of output has a sequence number,
+// its offset in the output, so that a frontend that reconnects can be sent
+// the output it missed
+type outputBuffer struct {
+	// data holds the byte of sequence number n at n % maxSize
+	data    []byte
+	maxSize int
+	// sequence is the sequence number of the next byte written
+	sequence uint64
+}
+
+func newOutputBuffer(maxSize int) *outputBuffer {
+	return &outputBuffer{
+		maxSize: maxSize,
+	}
+}
+
+// Write appends p to the buffer, discarding the oldest bytes when it
+// overflows. It never fails
+func (buffer *outputBuffer) Write(p []byte) (int, error) {
+	length := len(p)
+	buffer.sequence += uint64(length)
+	if buffer.maxSize <= 0 || length == 0 {
+		return length, nil
+	}
+	if buffer.data == nil {
+		buffer.data = make([]byte, buffer.maxSize)
+	}
+	if len(p) > buffer.maxSize {
+		p = p[len(p)-buffer.maxSize:]
+	}
+	start := int((buffer.sequence - uint64(len(p))) % uint64(buffer.maxSize))
+	copied := copy(buffer.data[start:], p)
+	copy(buffer.data, p[copied:])
+	return length, nil
+}
+
+// next returns the sequence number of the next byte written
+func (buffer *outputBuffer) next() uint64 {
+	return buffer.sequence
+}
+
+// since returns the buffered bytes from sequence onwards and the sequence
+// number of the first of them. When bytes from sequence onwards were
+// discarded, the bytes returned start later than sequence
+func (buffer *outputBuffer) since(sequence uint64) ([]byte, uint64) {
+	if sequence > buffer.sequence {
+		sequence = buffer.sequence
+	}
+	size := uint64(len(buffer.data))
+	if buffer.sequence > size && sequence < buffer.sequence-size {
+		sequence = buffer.sequence - size
+	}
+	length := int(buffer.sequence - sequence)
+	if length == 0 {
+		return nil, sequence
+	}
+	start := int(sequence % size)
+	end := start + length
+	if end > len(buffer.data) {
+		end = len(buffer.data)
+	}
+	missed := make([]byte, 0, length)
+	missed = append(missed, buffer.data[start:end]...)
+	missed = append(missed, buffer.data[:length-len(missed)]...)
+	return missed, sequence
+}
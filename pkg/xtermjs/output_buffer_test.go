@@ -0,0 +1,42 @@
+package xtermjs
+
+import (
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestOutputBuffer_Since(test *testing.T) {
+	testCases := []struct {
+		name             string
+		maxSize          int
+		writes           []string
+		sequence         uint64
+		expected         string
+		expectedSequence uint64
+	}{
+		{name: "empty", maxSize: 8, sequence: 0, expected: "", expectedSequence: 0},
+		{name: "everything", maxSize: 8, writes: []string{"abc", "de"}, sequence: 0, expected: "abcde", expectedSequence: 0},
+		{name: "missed", maxSize: 8, writes: []string{"abc", "de"}, sequence: 3, expected: "de", expectedSequence: 3},
+		{name: "nothing missed", maxSize: 8, writes: []string{"abc", "de"}, sequence: 5, expected: "", expectedSequence: 5},
+		{name: "wrapped", maxSize: 4, writes: []string{"abc", "def"}, sequence: 2, expected: "cdef", expectedSequence: 2},
+		{name: "discarded", maxSize: 4, writes: []string{"abc", "def"}, sequence: 0, expected: "cdef", expectedSequence: 2},
+		{name: "larger than the buffer", maxSize: 4, writes: []string{"a", "bcdefgh"}, sequence: 5, expected: "fgh", expectedSequence: 5},
+		{name: "ahead of the output", maxSize: 8, writes: []string{"abc"}, sequence: 10, expected: "", expectedSequence: 3},
+		{name: "not buffered", maxSize: 0, writes: []string{"abc"}, sequence: 0, expected: "", expectedSequence: 3},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			buffer := newOutputBuffer(testCase.maxSize)
+			for _, data := range testCase.writes {
+				buffer.Write([]byte(data))
+			}
+			actual, actualSequence := buffer.since(testCase.sequence)
+			if string(actual) != testCase.expected || actualSequence != testCase.expectedSequence {
+				test.Errorf("expected %q from %v, got %q from %v", testCase.expected, testCase.expectedSequence, actual, actualSequence)
+			}
+		})
+	}
+}
@@ -0,0 +1,147 @@
+package xtermjs
+
+import (
+	"io"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+// DefaultOutputLatencyWindow is how long output read from a tty is held back
+// so that later reads can be sent in the same websocket frame
+const DefaultOutputLatencyWindow = 5 * time.Millisecond
+
+// DefaultMaxOutputFrameSizeBytes is the size at which coalesced output is
+// sent without waiting for the latency window to elapse
+const DefaultMaxOutputFrameSizeBytes = 32 * 1024
+
+// outputChunkQueueLength is how many reads may be waiting to be coalesced
+// before reading from the tty blocks
+const outputChunkQueueLength = 16
+
+// outputPump copies output from a tty to a frontend, coalescing the reads made
+// within a latency window into frames of up to a maximum size. A UTF-8
+// sequence split across reads is held back until it is complete so that
+// frames never end in the middle of a character. Read buffers are taken from
+// a pool shared by the sessions of a handler
+type outputPump struct {
+	// buffers holds *[]byte of readSizeBytes
+	buffers *sync.Pool
+	// latencyWindow is how long the first read of a frame may wait for more;
+	// when not positive, every read is sent in a frame of its own
+	latencyWindow     time.Duration
+	maxFrameSizeBytes int
+	readSizeBytes     int
+}
+
+// outputChunk is the result of a read into a pooled buffer
+type outputChunk struct {
+	buffer *[]byte
+	length int
+}
+
+func newOutputPump(readSizeBytes int, maxFrameSizeBytes int, latencyWindow time.Duration) *outputPump {
+	if maxFrameSizeBytes < readSizeBytes {
+		maxFrameSizeBytes = readSizeBytes
+	}
+	// a frame must hold more than an incomplete UTF-8 sequence to make progress
+	if maxFrameSizeBytes < utf8.UTFMax {
+		maxFrameSizeBytes = utf8.UTFMax
+	}
+	return &outputPump{
+		buffers: &sync.Pool{
+			New: func() interface{} {
+				buffer := make([]byte, readSizeBytes)
+				return &buffer
+			},
+		},
+		latencyWindow:     latencyWindow,
+		maxFrameSizeBytes: maxFrameSizeBytes,
+		readSizeBytes:     readSizeBytes,
+	}
+}
+
+// run reads from tty until it fails, returning the error once all output read,
+// including any incomplete UTF-8 sequence it ended with, has been passed to
+// write. beforeRead is called before every read and may block to apply back
+// pressure, afterRead is called with the output of every read and write with
+// every frame. Frames are only valid during the call to write
+func (pump *outputPump) run(tty io.Reader, beforeRead func(), afterRead func([]byte), write func([]byte)) error {
+	chunks := make(chan outputChunk, outputChunkQueueLength)
+	readErr := make(chan error, 1)
+	go func() {
+		defer close(chunks)
+		for {
+			beforeRead()
+			buffer := pump.buffers.Get().(*[]byte)
+			length, err := tty.Read(*buffer)
+			if length > 0 {
+				afterRead((*buffer)[:length])
+				chunks <- outputChunk{buffer: buffer, length: length}
+			} else {
+				pump.buffers.Put(buffer)
+			}
+			if err != nil {
+				readErr <- err
+				return
+			}
+		}
+	}()
+
+	frame := make([]byte, 0, pump.maxFrameSizeBytes)
+	timer := time.NewTimer(time.Hour)
+	timer.Stop()
+	var deadline <-chan time.Time
+	// flush writes the frame, keeping an incomplete UTF-8 sequence at its end
+	// for the next frame unless final
+	flush := func(final bool) {
+		if deadline != nil && !timer.Stop() {
+			select {
+			case <-timer.C:
+			default:
+			}
+		}
+		deadline = nil
+		complete, incomplete := frame, []byte(nil)
+		if !final {
+			complete, incomplete = splitIncompleteUTF8(frame)
+		}
+		if len(complete) > 0 {
+			write(complete)
+		}
+		frame = frame[:copy(frame, incomplete)]
+	}
+	for {
+		select {
+		case chunk, ok := <-chunks:
+			if !ok {
+				flush(true)
+				return <-readErr
+			}
+			data := (*chunk.buffer)[:chunk.length]
+			for len(data) > 0 {
+				length := pump.maxFrameSizeBytes - len(frame)
+				if length > len(data) {
+					length = len(data)
+				}
+				frame = append(frame, data[:length]...)
+				data = data[length:]
+				if len(frame) >= pump.maxFrameSizeBytes {
+					flush(false)
+				}
+			}
+			pump.buffers.Put(chunk.buffer)
+			if len(frame) > 0 && deadline == nil {
+				if pump.latencyWindow <= 0 {
+					flush(false)
+					continue
+				}
+				timer.Reset(pump.latencyWindow)
+				deadline = timer.C
+			}
+		case <-deadline:
+			deadline = nil
+			flush(false)
+		}
+	}
+}
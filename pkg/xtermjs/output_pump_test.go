@@ -0,0 +1,180 @@
+package xtermjs
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/websocket"
+)
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// bulkReader produces size bytes of output, filling every read like a tty
+// does while a command floods it
+type bulkReader struct {
+	remaining int
+}
+
+func (reader *bulkReader) Read(p []byte) (int, error) {
+	if reader.remaining <= 0 {
+		return 0, io.EOF
+	}
+	length := len(p)
+	if length > reader.remaining {
+		length = reader.remaining
+	}
+	for i := range p[:length] {
+		p[i] = 'y'
+	}
+	reader.remaining -= length
+	return length, nil
+}
+
+// dialBenchmarkConnection returns the server side of a websocket connection
+// whose client discards everything it receives
+func dialBenchmarkConnection(b *testing.B) *websocket.Conn {
+	connections := make(chan *websocket.Conn, 1)
+	upgrader := websocket.Upgrader{WriteBufferSize: DefaultMaxOutputFrameSizeBytes + maxFrameTypeBytes}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		connection, err := upgrader.Upgrade(w, r, nil)
+		if err != nil {
+			b.Error(err)
+			return
+		}
+		connections <- connection
+	}))
+	b.Cleanup(server.Close)
+	client, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http"), nil)
+	if err != nil {
+		b.Fatal(err)
+	}
+	b.Cleanup(func() { client.Close() })
+	go func() {
+		for {
+			if _, _, err := client.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+	return <-connections
+}
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestOutputPump_Run(test *testing.T) {
+	testCases := []struct {
+		name              string
+		latencyWindow     time.Duration
+		maxFrameSizeBytes int
+		writes            []string
+		expectedFrames    []string
+	}{
+		{name: "coalesced", latencyWindow: time.Second, maxFrameSizeBytes: 1024, writes: []string{"a", "b", "c"}, expectedFrames: []string{"abc"}},
+		{name: "not coalesced", latencyWindow: -1, maxFrameSizeBytes: 1024, writes: []string{"a", "b", "c"}, expectedFrames: []string{"a", "b", "c"}},
+		{name: "split at the maximum frame size", latencyWindow: time.Second, maxFrameSizeBytes: 4, writes: []string{"abc", "def", "ghij"}, expectedFrames: []string{"abcd", "efgh", "ij"}},
+		{name: "UTF-8 sequence held back", latencyWindow: time.Second, maxFrameSizeBytes: 4, writes: []string{"abc\xc3", "\xa9"}, expectedFrames: []string{"abc", "\u00e9"}},
+		{name: "UTF-8 sequence held back until the next read", latencyWindow: -1, maxFrameSizeBytes: 1024, writes: []string{"\xe2\x82", "\xac!"}, expectedFrames: []string{"\u20ac!"}},
+		{name: "incomplete UTF-8 sequence sent at the end", latencyWindow: -1, maxFrameSizeBytes: 1024, writes: []string{"a\xe2\x82"}, expectedFrames: []string{"a", "\xe2\x82"}},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			reader, writer := io.Pipe()
+			pump := newOutputPump(4, testCase.maxFrameSizeBytes, testCase.latencyWindow)
+			frames := []string{}
+			read := ""
+			done := make(chan error)
+			go func() {
+				done <- pump.run(reader, func() {}, func(data []byte) { read += string(data) }, func(frame []byte) {
+					frames = append(frames, string(frame))
+				})
+			}()
+			for _, data := range testCase.writes {
+				writer.Write([]byte(data))
+			}
+			writer.Close()
+			if err := <-done; err != io.EOF {
+				test.Errorf("expected %v, got %v", io.EOF, err)
+			}
+			if read != strings.Join(testCase.writes, "") {
+				test.Errorf("expected reads of %q, got %q", strings.Join(testCase.writes, ""), read)
+			}
+			if strings.Join(frames, "|") != strings.Join(testCase.expectedFrames, "|") {
+				test.Errorf("expected frames %q, got %q", testCase.expectedFrames, frames)
+			}
+		})
+	}
+}
+
+func TestOutputPump_Run_LatencyWindow(test *testing.T) {
+	reader, writer := io.Pipe()
+	defer writer.Close()
+	pump := newOutputPump(512, 1024, 20*time.Millisecond)
+	frames := make(chan []byte, 1)
+	go pump.run(reader, func() {}, func([]byte) {}, func(frame []byte) {
+		frames <- bytes.Clone(frame)
+	})
+	start := time.Now()
+	writer.Write([]byte("prompt$ "))
+	select {
+	case frame := <-frames:
+		if string(frame) != "prompt$ " {
+			test.Errorf("expected %q, got %q", "prompt$ ", frame)
+		}
+		if elapsed := time.Since(start); elapsed < 20*time.Millisecond || elapsed > time.Second {
+			test.Errorf("expected output to be held back for the latency window, sent after %v", elapsed)
+		}
+	case <-time.After(time.Second):
+		test.Fatal("expected output to be sent once the latency window elapsed")
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Benchmarks
+// ----------------------------------------------------------------------------
+
+// BenchmarkOutputPump sends 1 MiB of bulk output, read 512 bytes at a time as
+// with the default MaxBufferSizeBytes, over a websocket connection
+func BenchmarkOutputPump(b *testing.B) {
+	const outputBytes = 1024 * 1024
+	benchmarks := []struct {
+		name          string
+		latencyWindow time.Duration
+	}{
+		{name: "uncoalesced", latencyWindow: -1},
+		{name: "coalesced", latencyWindow: DefaultOutputLatencyWindow},
+	}
+	for _, benchmark := range benchmarks {
+		b.Run(benchmark.name, func(b *testing.B) {
+			connection := dialBenchmarkConnection(b)
+			pump := newOutputPump(512, DefaultMaxOutputFrameSizeBytes, benchmark.latencyWindow)
+			proto := protocolV1{}
+			var encoded []byte
+			frames := 0
+			b.SetBytes(outputBytes)
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				pump.run(&bulkReader{remaining: outputBytes}, func() {}, func([]byte) {}, func(frame []byte) {
+					messageType, data, _ := proto.encode(encoded[:0], message{Type: MessageOutput, Data: frame})
+					encoded = data
+					if err := connection.WriteMessage(messageType, data); err != nil {
+						b.Fatal(err)
+					}
+					frames++
+				})
+			}
+			b.StopTimer()
+			b.ReportMetric(float64(frames)/b.Elapsed().Seconds(), "frames/s")
+			b.ReportMetric(float64(frames)/float64(b.N), "frames/op")
+		})
+	}
+}
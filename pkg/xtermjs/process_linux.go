@@ -0,0 +1,154 @@
+package xtermjs
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"sync"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// processStat holds the fields of /proc/<pid>/stat used to find the
+// processes of a session
+type processStat struct {
+	pid     int
+	ppid    int
+	session int
+	state   byte
+}
+
+// readProcessStat parses /proc/<pid>/stat. The command name is skipped by
+// looking for its closing parenthesis, as it may itself contain spaces and
+// parentheses
+func readProcessStat(pid int) (processStat, bool) {
+	data, err := os.ReadFile("/proc/" + strconv.Itoa(pid) + "/stat")
+	if err != nil {
+		return processStat{}, false
+	}
+	end := bytes.LastIndexByte(data, ')')
+	if end < 0 {
+		return processStat{}, false
+	}
+	// state ppid pgrp session ...
+	fields := bytes.Fields(data[end+1:])
+	if len(fields) < 4 || len(fields[0]) == 0 {
+		return processStat{}, false
+	}
+	ppid, err := strconv.Atoi(string(fields[1]))
+	if err != nil {
+		return processStat{}, false
+	}
+	session, err := strconv.Atoi(string(fields[3]))
+	if err != nil {
+		return processStat{}, false
+	}
+	return processStat{pid: pid, ppid: ppid, session: session, state: fields[0][0]}, true
+}
+
+// sessionMembers returns the processes whose session ID is sid
+func sessionMembers(sid int) []processStat {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	members := []processStat{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if stat, ok := readProcessStat(pid); ok && stat.session == sid {
+			members = append(members, stat)
+		}
+	}
+	return members
+}
+
+// orphanReaper reaps the processes adopted by the handler's process as a
+// subreaper once they exit, leaving the children it starts to the exec.Cmd
+// that started them
+type orphanReaper struct {
+	mutex sync.Mutex
+	// children are the pids of the children started, while they are children
+	children map[int]bool
+	running  bool
+}
+
+// orphans reaps the processes adopted by the handler's process
+var orphans = &orphanReaper{children: map[int]bool{}}
+
+// becomeSubreaper makes this process adopt the orphaned descendants of the
+// processes it spawns, rather than init, so that they remain part of their
+// session's process tree and can be reaped when the session is closed
+func becomeSubreaper() error {
+	return unix.Prctl(unix.PR_SET_CHILD_SUBREAPER, 1, 0, 0, 0)
+}
+
+// start calls startFunc to start cmd, which is then left to be waited for by
+// cmd rather than reaped as an orphan
+func (r *orphanReaper) start(cmd *exec.Cmd, startFunc func() error) error {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	err := startFunc()
+	if r.running && cmd.Process != nil {
+		r.children[cmd.Process.Pid] = true
+	}
+	return err
+}
+
+// watch reaps adopted processes from now on, whenever a child exits
+func (r *orphanReaper) watch() {
+	r.mutex.Lock()
+	r.running = true
+	r.mutex.Unlock()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGCHLD)
+	go func() {
+		for range signals {
+			r.reap()
+		}
+	}()
+}
+
+// reap waits for the exited children of this process that were not started
+// through start, and forgets the children started that were waited for
+func (r *orphanReaper) reap() {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	children := childProcesses()
+	for pid := range r.children {
+		if _, ok := children[pid]; !ok {
+			delete(r.children, pid)
+		}
+	}
+	for pid, stat := range children {
+		if stat.state == 'Z' && !r.children[pid] {
+			var status syscall.WaitStatus
+			syscall.Wait4(pid, &status, syscall.WNOHANG, nil)
+		}
+	}
+}
+
+// childProcesses returns the children of this process by pid
+func childProcesses() map[int]processStat {
+	entries, err := os.ReadDir("/proc")
+	if err != nil {
+		return nil
+	}
+	ppid := os.Getpid()
+	children := map[int]processStat{}
+	for _, entry := range entries {
+		pid, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue
+		}
+		if stat, ok := readProcessStat(pid); ok && stat.ppid == ppid {
+			children[pid] = stat
+		}
+	}
+	return children
+}
@@ -0,0 +1,121 @@
+package xtermjs
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestSession_close_Descendants(test *testing.T) {
+	if err := becomeSubreaper(); err != nil {
+		test.Skipf("failed to become a subreaper: %s", err)
+	}
+	pidFile := filepath.Join(test.TempDir(), "pids")
+
+	// A job in a process group of its own and an orphaned grandchild, both
+	// ignoring SIGHUP.
+	script := "set -m; trap '' HUP; sleep 30 & echo $! > " + pidFile + "; (sleep 30 & echo $! >> " + pidFile + "); wait"
+	cmd := exec.Command("/bin/sh", "-c", script)
+	tty, err := pty.Start(cmd)
+	if err != nil {
+		test.Skipf("failed to start tty: %s", err)
+	}
+	go io.Copy(io.Discard, tty)
+	s := newSession("test", cmd, tty, 0, 100*time.Millisecond, 1024, defaultLogger)
+
+	pids := []int{}
+	deadline := time.Now().Add(5 * time.Second)
+	for len(pids) < 2 && time.Now().Before(deadline) {
+		time.Sleep(50 * time.Millisecond)
+		data, _ := os.ReadFile(pidFile)
+		pids = pids[:0]
+		for _, field := range strings.Fields(string(data)) {
+			if pid, err := strconv.Atoi(field); err == nil {
+				pids = append(pids, pid)
+			}
+		}
+	}
+	if len(pids) < 2 {
+		test.Fatalf("expected two background processes, got %v", pids)
+	}
+	for _, pid := range pids {
+		if stat, ok := readProcessStat(pid); !ok || stat.session != cmd.Process.Pid {
+			test.Fatalf("expected process %d in session %d, got %+v", pid, cmd.Process.Pid, stat)
+		}
+	}
+
+	s.close()
+	if status := s.exitStatus(); status.Code != -1 || status.Signal != "terminated" {
+		test.Errorf("expected process to be terminated by SIGTERM, got %+v", status)
+	}
+	for _, pid := range pids {
+		if stat, ok := readProcessStat(pid); ok {
+			test.Errorf("expected process %d to be gone, got %+v", pid, stat)
+		}
+	}
+}
+
+func TestOrphanReaper_reap(test *testing.T) {
+	if err := becomeSubreaper(); err != nil {
+		test.Skipf("failed to become a subreaper: %s", err)
+	}
+	pidFile := filepath.Join(test.TempDir(), "pid")
+	reaper := &orphanReaper{children: map[int]bool{}, running: true}
+
+	// A grandchild in a session of its own, outliving the shell.
+	script := "setsid /bin/sh -c 'echo $$ > " + pidFile + "; exec sleep 0.5' </dev/null >/dev/null 2>&1 &"
+	cmd := exec.Command("/bin/sh", "-c", script)
+	if err := reaper.start(cmd, cmd.Start); err != nil {
+		test.Skipf("failed to start shell: %s", err)
+	}
+
+	pid := 0
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		data, _ := os.ReadFile(pidFile)
+		if pid, _ = strconv.Atoi(strings.TrimSpace(string(data))); pid > 0 {
+			if stat, ok := readProcessStat(pid); ok && stat.ppid == os.Getpid() && stat.state == 'Z' {
+				break
+			}
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	stat, ok := readProcessStat(pid)
+	if !ok || stat.state != 'Z' || stat.session == cmd.Process.Pid {
+		test.Fatalf("expected process %d to be an exited orphan in a session of its own, got %+v", pid, stat)
+	}
+
+	// The shell has exited as well, and is left to its exec.Cmd.
+	reaper.reap()
+	if stat, ok := readProcessStat(pid); ok {
+		test.Errorf("expected process %d to be reaped, got %+v", pid, stat)
+	}
+	if err := cmd.Wait(); err != nil {
+		test.Errorf("expected the shell to be waited for by its exec.Cmd, got %s", err)
+	}
+	reaper.reap()
+	if len(reaper.children) != 0 {
+		test.Errorf("expected the shell to be forgotten, got %v", reaper.children)
+	}
+}
+
+func TestReadProcessStat(test *testing.T) {
+	stat, ok := readProcessStat(os.Getpid())
+	if !ok {
+		test.Fatal("failed to read own stat")
+	}
+	if stat.pid != os.Getpid() || stat.ppid != os.Getppid() || stat.state == 0 {
+		test.Errorf("unexpected stat %+v", stat)
+	}
+}
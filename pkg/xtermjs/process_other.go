@@ -0,0 +1,35 @@
+//go:build !linux
+
+package xtermjs
+
+import "os/exec"
+
+// processStat describes a process of a session
+type processStat struct {
+	pid     int
+	ppid    int
+	session int
+	state   byte
+}
+
+// sessionMembers returns no processes as listing the processes of a session
+// is only supported on Linux. Only the process group of the session leader
+// is signalled elsewhere
+func sessionMembers(sid int) []processStat {
+	return nil
+}
+
+// orphanReaper starts children as is, as processes are only adopted on Linux
+type orphanReaper struct{}
+
+var orphans = &orphanReaper{}
+
+func becomeSubreaper() error {
+	return nil
+}
+
+func (r *orphanReaper) start(cmd *exec.Cmd, startFunc func() error) error {
+	return startFunc()
+}
+
+func (r *orphanReaper) watch() {}
@@ -0,0 +1,57 @@
+//go:build !windows
+
+package xtermjs
+
+import (
+	"errors"
+	"os"
+	"syscall"
+)
+
+// terminationSignals are sent in turn to a session's processes when it is
+// terminated gracefully, before they are killed
+var terminationSignals = []os.Signal{syscall.SIGHUP, syscall.SIGTERM}
+
+// signalProcesses sends sig to the process group led by process, which the
+// tty made the leader of a new session, and to every other process of that
+// session, such as the shell's jobs and their orphaned children
+func signalProcesses(process *os.Process, sig os.Signal) error {
+	unixSignal, ok := sig.(syscall.Signal)
+	if !ok {
+		return process.Signal(sig)
+	}
+	err := syscall.Kill(-process.Pid, unixSignal)
+	if errors.Is(err, syscall.ESRCH) {
+		err = nil
+	}
+	for _, member := range sessionMembers(process.Pid) {
+		if member.pid != process.Pid && member.state != 'Z' {
+			syscall.Kill(member.pid, unixSignal)
+		}
+	}
+	return err
+}
+
+// processesRemain reports whether any process other than a zombie is left
+// in the session led by process
+func processesRemain(process *os.Process) bool {
+	for _, member := range sessionMembers(process.Pid) {
+		if member.state != 'Z' {
+			return true
+		}
+	}
+	return false
+}
+
+// reapProcesses waits for the exited processes of the session led by process
+// that were re-parented to this process, so that they do not linger as
+// zombies. The session leader itself is waited for by its exec.Cmd
+func reapProcesses(process *os.Process) {
+	ppid := os.Getpid()
+	for _, member := range sessionMembers(process.Pid) {
+		if member.pid != process.Pid && member.ppid == ppid && member.state == 'Z' {
+			var status syscall.WaitStatus
+			syscall.Wait4(member.pid, &status, syscall.WNOHANG, nil)
+		}
+	}
+}
@@ -0,0 +1,21 @@
+//go:build windows
+
+package xtermjs
+
+import (
+	"os"
+)
+
+// terminationSignals is empty as Windows processes cannot be signalled, so
+// they are killed straight away
+var terminationSignals = []os.Signal{}
+
+func signalProcesses(process *os.Process, sig os.Signal) error {
+	return process.Signal(sig)
+}
+
+func processesRemain(process *os.Process) bool {
+	return false
+}
+
+func reapProcesses(process *os.Process) {}
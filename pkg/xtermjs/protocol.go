@@ -0,0 +1,370 @@
+package xtermjs
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"unicode/utf8"
+
+	"github.com/gorilla/websocket"
+)
+
+// ProtocolV1 is the websocket subprotocol of version 1 of the cloudshell
+// framing protocol. Frontends that do not request it are spoken to using the
+// legacy framing.
+//
+// Every message of the protocol is a single websocket frame whose first byte
+// is the message type and whose remaining bytes are the payload. The server
+// sends binary frames, or text frames holding valid UTF-8 when
+// HandlerOpts.OutputTextFrames is set; the frontend may send binary or text
+// frames.
+//
+//	type  name     direction          payload
+//	'i'   input    client to server   bytes to write to the tty
+//	'o'   output   server to client   bytes read from the tty
+//	'r'   resize   client to server   JSON TTYSize, e.g. {"cols":80,"rows":24}
+//	'a'   ack      client to server   decimal number of output bytes processed
+//	                                  since the previous ack
+//	'p'   ping     client to server   any bytes, echoed back in a pong
+//	'P'   pong     server to client   the payload of the ping
+//	't'   title    server to client   UTF-8 title suggested for the terminal
+//	's'   session  server to client   UTF-8 identifier of the attached session
+//	'n'   sequence server to client   decimal sequence number of the next
+//	                                  output byte, sent on attach
+//	'l'   length   server to client   decimal number of output bytes carried
+//	                                  by the next output message, sent when
+//	                                  its payload has a different length
+//	'x'   exit     server to client   JSON ExitStatus, sent before the
+//	                                  connection is closed with CloseProcessExited
+//	'e'   error    server to client   UTF-8 description of a failed request,
+//	                                  sent before the connection is closed or
+//	                                  when a message of the frontend is rejected
+//
+// Frontends must ack the output they receive, as output is withheld while too
+// much of it remains unacknowledged, see
+// HandlerOpts.FlowControlHighWatermarkBytes. Output should be acked once it
+// has been processed by the terminal rather than when it is received, and at
+// the latest when no output remains to be processed.
+//
+// The sequence number of an output byte is its offset in the session's
+// output. A frontend that keeps count of the output it receives can reattach
+// with the "sequence" query parameter set to the sequence number following the
+// last byte it received, to be sent only the output it missed. When some of
+// that output is no longer buffered, see
+// HandlerOpts.SessionReplayBufferSizeBytes, the sequence message tells where
+// the output sent resumes.
+//
+// Acks and sequence numbers count the bytes read from the tty. In text frames,
+// invalid UTF-8 in output is replaced with U+FFFD, so an output message whose
+// payload differs in length from the output it carries is preceded by a
+// length message giving that length, which frontends must count instead.
+//
+// Messages of unknown types are answered with an error message and otherwise
+// ignored, so that new types can be added without breaking either side.
+//
+// In the legacy framing, text frames and binary frames not starting with 0x01
+// are input, binary frames starting with 0x01 are followed by a JSON TTYSize,
+// output is sent in binary frames, or in text frames when
+// HandlerOpts.OutputTextFrames is set, and SessionMessage and ExitMessage are
+// sent in text frames. Ping, pong, title and error messages cannot be expressed.
+const ProtocolV1 = "cloudshell.v1"
+
+// ProtocolTtyd is the websocket subprotocol of ttyd, spoken when
+// HandlerOpts.TtydProtocol is set so that ttyd clients and its web UI can
+// connect unmodified.
+//
+// The frontend's first message is a JSON object of the form
+// {"AuthToken":"...","columns":80,"rows":24}, which is required before the
+// session is started. Every later message starts with a one character
+// opcode: '0' input, '1' resize with {"columns":80,"rows":24}, '2' pause and
+// '3' resume output. The server sends '0' output, '1' window title and '2'
+// preferences. Connections are closed normally when the process exits.
+const ProtocolTtyd = "tty"
+
+// Message types of ProtocolV1
+const (
+	MessageInput    byte = 'i'
+	MessageOutput   byte = 'o'
+	MessageResize   byte = 'r'
+	MessageAck      byte = 'a'
+	MessagePing     byte = 'p'
+	MessagePong     byte = 'P'
+	MessageTitle    byte = 't'
+	MessageSession  byte = 's'
+	MessageSequence byte = 'n'
+	MessageLength   byte = 'l'
+	MessageExit     byte = 'x'
+	MessageError    byte = 'e'
+)
+
+// Message types used internally for requests of protocols other than
+// ProtocolV1
+const (
+	messageInit   byte = 0x80
+	messagePause  byte = 0x81
+	messageResume byte = 0x82
+	// messagePreferences carries JSON client options for ttyd frontends
+	messagePreferences byte = 0x83
+)
+
+// legacyResizePrefix starts the binary frames of the legacy framing that
+// resize the tty
+const legacyResizePrefix = 0x01
+
+// Opcodes of ProtocolTtyd
+const (
+	ttydInput          = '0'
+	ttydResize         = '1'
+	ttydPause          = '2'
+	ttydResume         = '3'
+	ttydJsonData       = '{'
+	ttydOutput         = '0'
+	ttydSetWindowTitle = '1'
+	ttydSetPreferences = '2'
+)
+
+// errEmptyMessage is returned when decoding a frame without a message type
+var errEmptyMessage = errors.New("empty message")
+
+// message is a message exchanged with the frontend, independent of the
+// protocol it is framed in. Which fields are used depends on Type
+type message struct {
+	Type byte
+	// Data is the payload of input, output, ping, pong, title, session,
+	// sequence, length and error messages
+	Data []byte
+	// Exit is the payload of exit messages
+	Exit ExitStatus
+	// Length is the payload of ack messages
+	Length int
+	// Size is the payload of resize messages, and of init messages when the
+	// frontend reports its size
+	Size TTYSize
+}
+
+// ttydSize is the terminal size sent by ttyd frontends
+type ttydSize struct {
+	Columns uint16 `json:"columns"`
+	Rows    uint16 `json:"rows"`
+}
+
+// ttydInit is the first message sent by ttyd frontends
+type ttydInit struct {
+	AuthToken string `json:"AuthToken"`
+	ttydSize
+}
+
+// protocol frames the messages exchanged with the frontend
+type protocol interface {
+	// decode returns the message carried by a frame received from the
+	// frontend
+	decode(messageType int, data []byte) (message, error)
+	// encode returns the frame type and payload carrying msg, appending the
+	// payload to dst, or false when msg cannot be expressed by the protocol
+	encode(dst []byte, msg message) (int, []byte, bool)
+	// closeCode returns the close code sent in place of code
+	closeCode(code int) int
+	// initialized reports whether the frontend can be attached to a session
+	// without first sending an init message
+	initialized() bool
+	// acknowledged reports whether the frontend acks the output it receives
+	acknowledged() bool
+}
+
+// getSubprotocols returns the websocket subprotocols offered by the handler,
+// in order of preference
+func getSubprotocols(ttyd bool) []string {
+	if ttyd {
+		return []string{ProtocolV1, ProtocolTtyd}
+	}
+	return []string{ProtocolV1}
+}
+
+// negotiatedProtocol returns the protocol of the websocket subprotocol
+// selected for a connection. textFrames selects text frames for output where
+// the protocol allows it
+func negotiatedProtocol(subprotocol string, textFrames bool) protocol {
+	switch subprotocol {
+	case ProtocolV1:
+		return protocolV1{textFrames: textFrames}
+	case ProtocolTtyd:
+		return ttydProtocol{}
+	}
+	return legacyProtocol{textFrames: textFrames}
+}
+
+// protocolV1 implements ProtocolV1
+type protocolV1 struct {
+	// textFrames sends every message in a text frame, replacing invalid UTF-8
+	// in output
+	textFrames bool
+}
+
+func (protocolV1) decode(messageType int, data []byte) (message, error) {
+	if len(data) == 0 {
+		return message{}, errEmptyMessage
+	}
+	msg := message{Type: data[0], Data: data[1:]}
+	switch msg.Type {
+	case MessageInput, MessagePing:
+	case MessageResize:
+		if err := json.Unmarshal(msg.Data, &msg.Size); err != nil {
+			return msg, fmt.Errorf("invalid resize message '%s': %w", string(msg.Data), err)
+		}
+	case MessageAck:
+		length, err := strconv.Atoi(string(msg.Data))
+		if err != nil || length < 0 {
+			return msg, fmt.Errorf("invalid ack message '%s'", string(msg.Data))
+		}
+		msg.Length = length
+	default:
+		return msg, fmt.Errorf("unknown message type '%c'", msg.Type)
+	}
+	return msg, nil
+}
+
+func (proto protocolV1) encode(dst []byte, msg message) (int, []byte, bool) {
+	payload := msg.Data
+	switch msg.Type {
+	case MessageOutput, MessagePong, MessageTitle, MessageSession, MessageSequence, MessageLength, MessageError:
+	case MessageExit:
+		payload, _ = json.Marshal(msg.Exit)
+	default:
+		return 0, nil, false
+	}
+	dst = append(dst, msg.Type)
+	if proto.textFrames {
+		return websocket.TextMessage, appendValidUTF8(dst, payload), true
+	}
+	return websocket.BinaryMessage, append(dst, payload...), true
+}
+
+func (protocolV1) closeCode(code int) int { return code }
+
+// lengthMessage returns the length message to send before an output message
+// carrying data, or false when the payload of the output message has the
+// length of data
+func lengthMessage(proto protocol, data []byte) (message, bool) {
+	if v1, ok := proto.(protocolV1); !ok || !v1.textFrames || utf8.Valid(data) {
+		return message{}, false
+	}
+	return message{Type: MessageLength, Data: strconv.AppendInt(nil, int64(len(data)), 10)}, true
+}
+
+func (protocolV1) initialized() bool { return true }
+
+func (protocolV1) acknowledged() bool { return true }
+
+// legacyProtocol implements the framing used before ProtocolV1, which is kept
+// for frontends that do not request a subprotocol
+type legacyProtocol struct {
+	// textFrames sends output in text frames, replacing invalid UTF-8
+	textFrames bool
+}
+
+func (legacyProtocol) decode(messageType int, data []byte) (message, error) {
+	data = bytes.Trim(data, "\x00")
+	if messageType == websocket.BinaryMessage && len(data) > 0 && data[0] == legacyResizePrefix {
+		msg := message{Type: MessageResize}
+		resizeMessage := bytes.Trim(data[1:], " \n\r\t\x00\x01")
+		if err := json.Unmarshal(resizeMessage, &msg.Size); err != nil {
+			return msg, fmt.Errorf("invalid resize message '%s': %w", string(resizeMessage), err)
+		}
+		return msg, nil
+	}
+	return message{Type: MessageInput, Data: data}, nil
+}
+
+func (proto legacyProtocol) encode(dst []byte, msg message) (int, []byte, bool) {
+	switch msg.Type {
+	case MessageOutput:
+		if proto.textFrames {
+			return websocket.TextMessage, appendValidUTF8(dst, msg.Data), true
+		}
+		return websocket.BinaryMessage, append(dst, msg.Data...), true
+	case MessageSession:
+		data, _ := json.Marshal(SessionMessage{Session: string(msg.Data)})
+		return websocket.TextMessage, append(dst, data...), true
+	case MessageExit:
+		data, _ := json.Marshal(ExitMessage{Exit: msg.Exit})
+		return websocket.TextMessage, append(dst, data...), true
+	}
+	return 0, nil, false
+}
+
+func (legacyProtocol) closeCode(code int) int { return code }
+
+func (legacyProtocol) initialized() bool { return true }
+
+func (legacyProtocol) acknowledged() bool { return false }
+
+// ttydProtocol implements ProtocolTtyd
+type ttydProtocol struct{}
+
+func (ttydProtocol) decode(messageType int, data []byte) (message, error) {
+	if len(data) == 0 {
+		return message{}, errEmptyMessage
+	}
+	switch data[0] {
+	case ttydInput:
+		return message{Type: MessageInput, Data: data[1:]}, nil
+	case ttydResize:
+		size := ttydSize{}
+		if err := json.Unmarshal(data[1:], &size); err != nil {
+			return message{}, fmt.Errorf("invalid resize message '%s': %w", string(data[1:]), err)
+		}
+		return message{Type: MessageResize, Size: TTYSize{Cols: size.Columns, Rows: size.Rows}}, nil
+	case ttydPause:
+		return message{Type: messagePause}, nil
+	case ttydResume:
+		return message{Type: messageResume}, nil
+	case ttydJsonData:
+		init := ttydInit{}
+		if err := json.Unmarshal(data, &init); err != nil {
+			return message{}, fmt.Errorf("invalid init message: %w", err)
+		}
+		return message{Type: messageInit, Data: []byte(init.AuthToken), Size: TTYSize{Cols: init.Columns, Rows: init.Rows}}, nil
+	}
+	return message{}, fmt.Errorf("unknown command '%c'", data[0])
+}
+
+func (ttydProtocol) encode(dst []byte, msg message) (int, []byte, bool) {
+	var opcode byte
+	switch msg.Type {
+	case MessageOutput:
+		opcode = ttydOutput
+	case MessageTitle:
+		opcode = ttydSetWindowTitle
+	case messagePreferences:
+		opcode = ttydSetPreferences
+	default:
+		return 0, nil, false
+	}
+	dst = append(dst, opcode)
+	return websocket.BinaryMessage, append(dst, msg.Data...), true
+}
+
+// closeCode closes connections normally, as ttyd clients reconnect after any
+// other close code
+func (ttydProtocol) closeCode(code int) int {
+	if code == CloseProcessExited {
+		return websocket.CloseNormalClosure
+	}
+	return code
+}
+
+func (ttydProtocol) initialized() bool { return false }
+
+func (ttydProtocol) acknowledged() bool { return false }
+
+// writeMessage sends msg to connection framed by proto and compressed
+// according to compression. Messages the protocol cannot express are dropped
+func writeMessage(connection *websocket.Conn, proto protocol, compression compressionPolicy, msg message) error {
+	messageType, data, ok := proto.encode(nil, msg)
+	if !ok {
+		return nil
+	}
+	return compression.writeMessage(connection, messageType, data)
+}
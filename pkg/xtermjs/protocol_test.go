@@ -0,0 +1,149 @@
+package xtermjs
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/gorilla/websocket"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestProtocol_Decode(test *testing.T) {
+	testCases := []struct {
+		name        string
+		protocol    protocol
+		messageType int
+		data        string
+		expected    message
+		expectedErr bool
+	}{
+		{name: "v1 input", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "ils\r", expected: message{Type: MessageInput, Data: []byte("ls\r")}},
+		{name: "v1 input starting with 0x01", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "i\x01{\"cols\":1}", expected: message{Type: MessageInput, Data: []byte("\x01{\"cols\":1}")}},
+		{name: "v1 input in a text frame", protocol: protocolV1{}, messageType: websocket.TextMessage, data: "ix", expected: message{Type: MessageInput, Data: []byte("x")}},
+		{name: "v1 resize", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: `r{"cols":80,"rows":24}`, expected: message{Type: MessageResize, Data: []byte(`{"cols":80,"rows":24}`), Size: TTYSize{Cols: 80, Rows: 24}}},
+		{name: "v1 invalid resize", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "r80x24", expectedErr: true},
+		{name: "v1 ack", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "a65536", expected: message{Type: MessageAck, Data: []byte("65536"), Length: 65536}},
+		{name: "v1 invalid ack", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "a-1", expectedErr: true},
+		{name: "v1 ping", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "p42", expected: message{Type: MessagePing, Data: []byte("42")}},
+		{name: "v1 unknown type", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "zzz", expectedErr: true},
+		{name: "v1 empty", protocol: protocolV1{}, messageType: websocket.BinaryMessage, data: "", expectedErr: true},
+		{name: "legacy input", protocol: legacyProtocol{}, messageType: websocket.TextMessage, data: "ls\r", expected: message{Type: MessageInput, Data: []byte("ls\r")}},
+		{name: "legacy binary input", protocol: legacyProtocol{}, messageType: websocket.BinaryMessage, data: "\x1b[A", expected: message{Type: MessageInput, Data: []byte("\x1b[A")}},
+		{name: "legacy resize", protocol: legacyProtocol{}, messageType: websocket.BinaryMessage, data: "\x01{\"cols\":80,\"rows\":24}", expected: message{Type: MessageResize, Size: TTYSize{Cols: 80, Rows: 24}}},
+		{name: "legacy text starting with 0x01", protocol: legacyProtocol{}, messageType: websocket.TextMessage, data: "\x01x", expected: message{Type: MessageInput, Data: []byte("\x01x")}},
+		{name: "ttyd init", protocol: ttydProtocol{}, messageType: websocket.TextMessage, data: `{"AuthToken":"secret","columns":80,"rows":24}`, expected: message{Type: messageInit, Data: []byte("secret"), Size: TTYSize{Cols: 80, Rows: 24}}},
+		{name: "ttyd input", protocol: ttydProtocol{}, messageType: websocket.BinaryMessage, data: "0ls\r", expected: message{Type: MessageInput, Data: []byte("ls\r")}},
+		{name: "ttyd resize", protocol: ttydProtocol{}, messageType: websocket.BinaryMessage, data: `1{"columns":100,"rows":30}`, expected: message{Type: MessageResize, Size: TTYSize{Cols: 100, Rows: 30}}},
+		{name: "ttyd pause", protocol: ttydProtocol{}, messageType: websocket.BinaryMessage, data: "2", expected: message{Type: messagePause}},
+		{name: "ttyd resume", protocol: ttydProtocol{}, messageType: websocket.BinaryMessage, data: "3", expected: message{Type: messageResume}},
+		{name: "ttyd unknown command", protocol: ttydProtocol{}, messageType: websocket.BinaryMessage, data: "9", expectedErr: true},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			actual, err := testCase.protocol.decode(testCase.messageType, []byte(testCase.data))
+			if testCase.expectedErr {
+				if err == nil {
+					test.Errorf("expected an error, got %+v", actual)
+				}
+				return
+			}
+			if err != nil {
+				test.Fatal(err)
+			}
+			if actual.Type != testCase.expected.Type || !bytes.Equal(actual.Data, testCase.expected.Data) || actual.Size != testCase.expected.Size || actual.Length != testCase.expected.Length {
+				test.Errorf("expected %+v, got %+v", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestProtocol_Encode(test *testing.T) {
+	testCases := []struct {
+		name                string
+		protocol            protocol
+		message             message
+		expectedMessageType int
+		expectedData        string
+		expectedOk          bool
+	}{
+		{name: "v1 output", protocol: protocolV1{}, message: message{Type: MessageOutput, Data: []byte("hello")}, expectedMessageType: websocket.BinaryMessage, expectedData: "ohello", expectedOk: true},
+		{name: "v1 title", protocol: protocolV1{}, message: message{Type: MessageTitle, Data: []byte("user@host")}, expectedMessageType: websocket.BinaryMessage, expectedData: "tuser@host", expectedOk: true},
+		{name: "v1 session", protocol: protocolV1{}, message: message{Type: MessageSession, Data: []byte("abc")}, expectedMessageType: websocket.BinaryMessage, expectedData: "sabc", expectedOk: true},
+		{name: "v1 sequence", protocol: protocolV1{}, message: message{Type: MessageSequence, Data: []byte("1024")}, expectedMessageType: websocket.BinaryMessage, expectedData: "n1024", expectedOk: true},
+		{name: "v1 exit", protocol: protocolV1{}, message: message{Type: MessageExit, Exit: ExitStatus{Code: 3, Description: "exit status 3"}}, expectedMessageType: websocket.BinaryMessage, expectedData: `x{"code":3,"description":"exit status 3"}`, expectedOk: true},
+		{name: "v1 pong", protocol: protocolV1{}, message: message{Type: MessagePong, Data: []byte("42")}, expectedMessageType: websocket.BinaryMessage, expectedData: "P42", expectedOk: true},
+		{name: "v1 error", protocol: protocolV1{}, message: message{Type: MessageError, Data: []byte("no")}, expectedMessageType: websocket.BinaryMessage, expectedData: "eno", expectedOk: true},
+		{name: "v1 ttyd preferences", protocol: protocolV1{}, message: message{Type: messagePreferences, Data: []byte("{}")}, expectedOk: false},
+		{name: "v1 text output", protocol: protocolV1{textFrames: true}, message: message{Type: MessageOutput, Data: []byte("caf\xc3\xa9 \xff")}, expectedMessageType: websocket.TextMessage, expectedData: "ocaf\u00e9 \ufffd", expectedOk: true},
+		{name: "v1 text length", protocol: protocolV1{textFrames: true}, message: message{Type: MessageLength, Data: []byte("7")}, expectedMessageType: websocket.TextMessage, expectedData: "l7", expectedOk: true},
+		{name: "v1 text exit", protocol: protocolV1{textFrames: true}, message: message{Type: MessageExit, Exit: ExitStatus{Code: 0, Description: "exit status 0"}}, expectedMessageType: websocket.TextMessage, expectedData: `x{"code":0,"description":"exit status 0"}`, expectedOk: true},
+		{name: "legacy output", protocol: legacyProtocol{}, message: message{Type: MessageOutput, Data: []byte("hello")}, expectedMessageType: websocket.BinaryMessage, expectedData: "hello", expectedOk: true},
+		{name: "legacy text output", protocol: legacyProtocol{textFrames: true}, message: message{Type: MessageOutput, Data: []byte("\xe2\x82")}, expectedMessageType: websocket.TextMessage, expectedData: "\ufffd\ufffd", expectedOk: true},
+		{name: "legacy session", protocol: legacyProtocol{}, message: message{Type: MessageSession, Data: []byte("abc")}, expectedMessageType: websocket.TextMessage, expectedData: `{"session":"abc"}`, expectedOk: true},
+		{name: "legacy exit", protocol: legacyProtocol{}, message: message{Type: MessageExit, Exit: ExitStatus{Code: -1, Description: "signal: hangup", Signal: "hangup"}}, expectedMessageType: websocket.TextMessage, expectedData: `{"exit":{"code":-1,"description":"signal: hangup","signal":"hangup"}}`, expectedOk: true},
+		{name: "legacy title", protocol: legacyProtocol{}, message: message{Type: MessageTitle, Data: []byte("user@host")}, expectedOk: false},
+		{name: "legacy sequence", protocol: legacyProtocol{}, message: message{Type: MessageSequence, Data: []byte("1024")}, expectedOk: false},
+		{name: "legacy length", protocol: legacyProtocol{textFrames: true}, message: message{Type: MessageLength, Data: []byte("7")}, expectedOk: false},
+		{name: "legacy error", protocol: legacyProtocol{}, message: message{Type: MessageError, Data: []byte("no")}, expectedOk: false},
+		{name: "ttyd output", protocol: ttydProtocol{}, message: message{Type: MessageOutput, Data: []byte("hello")}, expectedMessageType: websocket.BinaryMessage, expectedData: "0hello", expectedOk: true},
+		{name: "ttyd title", protocol: ttydProtocol{}, message: message{Type: MessageTitle, Data: []byte("user@host")}, expectedMessageType: websocket.BinaryMessage, expectedData: "1user@host", expectedOk: true},
+		{name: "ttyd preferences", protocol: ttydProtocol{}, message: message{Type: messagePreferences, Data: []byte("{}")}, expectedMessageType: websocket.BinaryMessage, expectedData: "2{}", expectedOk: true},
+		{name: "ttyd exit", protocol: ttydProtocol{}, message: message{Type: MessageExit}, expectedOk: false},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			messageType, data, ok := testCase.protocol.encode(nil, testCase.message)
+			if ok != testCase.expectedOk {
+				test.Fatalf("expected ok %v, got %v", testCase.expectedOk, ok)
+			}
+			if !ok {
+				return
+			}
+			if messageType != testCase.expectedMessageType || string(data) != testCase.expectedData {
+				test.Errorf("expected %v %q, got %v %q", testCase.expectedMessageType, testCase.expectedData, messageType, string(data))
+			}
+		})
+	}
+}
+
+func TestLengthMessage(test *testing.T) {
+	testCases := []struct {
+		name         string
+		protocol     protocol
+		data         string
+		expectedData string
+		expectedOk   bool
+	}{
+		{name: "binary frames", protocol: protocolV1{}, data: "caf\xc3\xa9 \xff", expectedOk: false},
+		{name: "valid UTF-8", protocol: protocolV1{textFrames: true}, data: "caf\xc3\xa9", expectedOk: false},
+		{name: "invalid UTF-8", protocol: protocolV1{textFrames: true}, data: "caf\xc3\xa9 \xff", expectedData: "7", expectedOk: true},
+		{name: "legacy", protocol: legacyProtocol{textFrames: true}, data: "\xff", expectedOk: false},
+		{name: "ttyd", protocol: ttydProtocol{}, data: "\xff", expectedOk: false},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			msg, ok := lengthMessage(testCase.protocol, []byte(testCase.data))
+			if ok != testCase.expectedOk {
+				test.Fatalf("expected ok %v, got %v", testCase.expectedOk, ok)
+			}
+			if ok && (msg.Type != MessageLength || string(msg.Data) != testCase.expectedData) {
+				test.Errorf("expected length message %q, got %c %q", testCase.expectedData, msg.Type, string(msg.Data))
+			}
+		})
+	}
+}
+
+func TestNegotiatedProtocol(test *testing.T) {
+	if _, ok := negotiatedProtocol(ProtocolV1, false).(protocolV1); !ok {
+		test.Errorf("expected %s to select protocolV1", ProtocolV1)
+	}
+	if _, ok := negotiatedProtocol(ProtocolTtyd, false).(ttydProtocol); !ok {
+		test.Errorf("expected %s to select ttydProtocol", ProtocolTtyd)
+	}
+	if _, ok := negotiatedProtocol("", false).(legacyProtocol); !ok {
+		test.Error("expected no subprotocol to select legacyProtocol")
+	}
+}
@@ -0,0 +1,192 @@
+package xtermjs
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+)
+
+const (
+	defaultRecordingHeight = 24
+	defaultRecordingWidth  = 80
+	// maxPendingRecordingBytes bounds the events kept in memory while waiting
+	// for the first resize message to determine the recording's dimensions
+	maxPendingRecordingBytes = 64 * 1024
+	// RecordingFileExtension is the extension of the asciicast files written
+	// to the recording directory
+	RecordingFileExtension = ".cast"
+)
+
+// asciicastHeader is the first line of an asciicast v2 file
+// See https://docs.asciinema.org/manual/asciicast/v2/
+type asciicastHeader struct {
+	Version   int               `json:"version"`
+	Width     uint16            `json:"width"`
+	Height    uint16            `json:"height"`
+	Timestamp int64             `json:"timestamp"`
+	Title     string            `json:"title,omitempty"`
+	Env       map[string]string `json:"env,omitempty"`
+}
+
+// recorder writes the output, and optionally the input, of a session to a
+// file in asciicast v2 format
+type recorder struct {
+	file        *os.File
+	recordInput bool
+	startTime   time.Time
+
+	mutex         sync.Mutex
+	header        asciicastHeader
+	headerWritten bool
+	inputCarry    []byte
+	outputCarry   []byte
+	pending       bytes.Buffer
+	writer        *bufio.Writer
+}
+
+// newRecorder creates a recording file for the session identified by
+// sessionID in directory
+func newRecorder(directory string, sessionID string, title string, user string, recordInput bool) (*recorder, error) {
+	if err := os.MkdirAll(directory, 0o750); err != nil {
+		return nil, fmt.Errorf("failed to create recording directory: %w", err)
+	}
+	startTime := time.Now()
+	filename := fmt.Sprintf("%s-%s%s", startTime.UTC().Format("20060102T150405Z"), sessionID, RecordingFileExtension)
+	file, err := os.OpenFile(filepath.Join(directory, filename), os.O_CREATE|os.O_EXCL|os.O_WRONLY, 0o640)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create recording file: %w", err)
+	}
+	env := map[string]string{
+		"SHELL": title,
+		"TERM":  "xterm-256color",
+	}
+	if user != "" {
+		env["USER"] = user
+	}
+	return &recorder{
+		file: file,
+		header: asciicastHeader{
+			Version:   2,
+			Width:     defaultRecordingWidth,
+			Height:    defaultRecordingHeight,
+			Timestamp: startTime.Unix(),
+			Title:     title,
+			Env:       env,
+		},
+		recordInput: recordInput,
+		startTime:   startTime,
+		writer:      bufio.NewWriter(file),
+	}, nil
+}
+
+// event records an event of the given type at the current time. The caller
+// must hold the mutex
+func (r *recorder) event(eventType string, data string) {
+	elapsed := time.Since(r.startTime).Seconds()
+	line, err := json.Marshal([]interface{}{elapsed, eventType, data})
+	if err != nil {
+		return
+	}
+	if !r.headerWritten {
+		r.pending.Write(line)
+		r.pending.WriteByte('\n')
+		if r.pending.Len() > maxPendingRecordingBytes {
+			r.writeHeader()
+		}
+		return
+	}
+	r.writer.Write(line)
+	r.writer.WriteByte('\n')
+	r.writer.Flush()
+}
+
+// writeHeader writes the header followed by the events recorded so far. The
+// caller must hold the mutex
+func (r *recorder) writeHeader() {
+	if r.headerWritten {
+		return
+	}
+	header, err := json.Marshal(r.header)
+	if err != nil {
+		return
+	}
+	r.writer.Write(header)
+	r.writer.WriteByte('\n')
+	r.writer.Write(r.pending.Bytes())
+	r.pending.Reset()
+	r.headerWritten = true
+	r.writer.Flush()
+}
+
+// name returns the file name of the recording
+func (r *recorder) name() string {
+	if r == nil {
+		return ""
+	}
+	return filepath.Base(r.file.Name())
+}
+
+// output records data read from the tty
+func (r *recorder) output(p []byte) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var complete []byte
+	complete, r.outputCarry = splitIncompleteUTF8(append(r.outputCarry, p...))
+	if len(complete) > 0 {
+		r.event("o", string(complete))
+	}
+}
+
+// input records data written to the tty, if input recording is enabled
+func (r *recorder) input(p []byte) {
+	if r == nil || !r.recordInput {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	var complete []byte
+	complete, r.inputCarry = splitIncompleteUTF8(append(r.inputCarry, p...))
+	if len(complete) > 0 {
+		r.event("i", string(complete))
+	}
+}
+
+// resize records a change of the terminal dimensions. The first resize
+// determines the dimensions written in the header
+func (r *recorder) resize(cols uint16, rows uint16) {
+	if r == nil {
+		return
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if !r.headerWritten {
+		r.header.Width = cols
+		r.header.Height = rows
+		r.writeHeader()
+		return
+	}
+	r.event("r", fmt.Sprintf("%dx%d", cols, rows))
+}
+
+// close flushes the recording and closes its file
+func (r *recorder) close() error {
+	if r == nil {
+		return nil
+	}
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	r.writeHeader()
+	if err := r.writer.Flush(); err != nil {
+		r.file.Close()
+		return err
+	}
+	return r.file.Close()
+}
@@ -0,0 +1,43 @@
+package xtermjs
+
+import (
+	"time"
+)
+
+// DefaultCgroupParent is the cgroup v2 directory under which the cgroups of
+// sessions are created
+const DefaultCgroupParent = "/sys/fs/cgroup/cloudshell"
+
+// resourceLimitsPollInterval is how often sessions are checked for their
+// processes reaching a resource limit
+const resourceLimitsPollInterval = time.Second
+
+// rlimits reports whether any of the limits is applied with setrlimit
+func (limits ResourceLimits) rlimits() bool {
+	return limits.AddressSpaceBytes > 0 || limits.CpuTime > 0 || limits.OpenFiles > 0 || limits.Processes > 0
+}
+
+// cgroupLimits reports whether any of the limits is applied with a cgroup
+func (limits ResourceLimits) cgroupLimits() bool {
+	return limits.CpuWeight > 0 || limits.MemoryBytes > 0 || limits.Pids > 0
+}
+
+// watchResourceLimits logs and reports in the terminal the resource limits
+// that the processes of the session reach, until the session is closed
+func watchResourceLimits(s *session) {
+	ticker := time.NewTicker(resourceLimitsPollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-s.done:
+			return
+		case <-ticker.C:
+		}
+		for _, violation := range s.limits.violations() {
+			s.logger.Warnf("session reached a resource limit: %s", violation)
+			if err := s.notify(violation); err != nil {
+				s.logger.Warnf("failed to report resource limit to xterm.js: %s", err)
+			}
+		}
+	}
+}
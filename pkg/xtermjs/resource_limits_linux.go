@@ -0,0 +1,330 @@
+package xtermjs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// cgroupRemovalTimeout is how long the processes left in the cgroup of a
+// closed session are given to die before the cgroup is removed
+const cgroupRemovalTimeout = time.Second
+
+// rlimitsHelperName is the name the handler's executable is started with to
+// set the rlimits of a session before executing its command, see
+// runRlimitsHelper
+const rlimitsHelperName = "cloudshell-rlimits"
+
+// rlimitsVariable is the environment variable passing the rlimits to the
+// helper, which removes it
+const rlimitsVariable = "CLOUDSHELL_RLIMITS"
+
+// resourceLimits applies ResourceLimits to the processes of a session and
+// tells which limits they reached
+type resourceLimits struct {
+	limits ResourceLimits
+	// cgroup is the directory of the session's cgroup, if any
+	cgroup string
+	// cgroupDirectory is open while the process is started into cgroup
+	cgroupDirectory *os.File
+	// oomKills and pidsMax are the counts of memory.events and pids.events
+	// already reported
+	oomKills uint64
+	pidsMax  uint64
+}
+
+// rlimit is a limit set with setrlimit
+type rlimit struct {
+	Name     string      `json:"name"`
+	Resource int         `json:"resource"`
+	Limit    unix.Rlimit `json:"limit"`
+}
+
+// The helper setting rlimits is the handler's executable, which is taken over
+// as soon as this package is initialized
+func init() {
+	if len(os.Args) > 1 && os.Args[0] == rlimitsHelperName {
+		runRlimitsHelper()
+	}
+}
+
+// runRlimitsHelper runs in place of the command of a session, which is its
+// first argument followed by the arguments of the command. It sets the rlimits
+// it was given, which the command inherits, and executes the command. What
+// fails is written to the tty, and the helper exits with 126
+func runRlimitsHelper() {
+	var rlimits []rlimit
+	err := json.Unmarshal([]byte(os.Getenv(rlimitsVariable)), &rlimits)
+	os.Unsetenv(rlimitsVariable)
+	// setting RLIMIT_NOFILE also keeps the Go runtime from restoring the
+	// limit it started with when executing the command
+	for i := 0; err == nil && i < len(rlimits); i++ {
+		if setErr := unix.Prlimit(0, rlimits[i].Resource, &rlimits[i].Limit, nil); setErr != nil {
+			err = fmt.Errorf("failed to set %s to %d: %w", rlimits[i].Name, rlimits[i].Limit.Cur, setErr)
+		}
+	}
+	if err == nil {
+		err = syscall.Exec(os.Args[1], os.Args[2:], os.Environ())
+	}
+	fmt.Fprintf(os.Stderr, "cloudshell: %s\n", err)
+	os.Exit(126)
+}
+
+// newResourceLimits prepares limits for the session id, creating its cgroup
+// when limits needs one. Cgroup limits are skipped, with a warning, when
+// cgroup v2 cannot be used
+func newResourceLimits(limits ResourceLimits, id string, logger Logger) (*resourceLimits, error) {
+	if !limits.rlimits() && !limits.cgroupLimits() {
+		return nil, nil
+	}
+	l := &resourceLimits{limits: limits}
+	if limits.cgroupLimits() {
+		parent := limits.CgroupParent
+		if parent == "" {
+			parent = DefaultCgroupParent
+		}
+		cgroup, err := createCgroup(parent, id, limits)
+		if err != nil {
+			logger.Warnf("not applying cgroup resource limits: %s", err)
+			return l, nil
+		}
+		l.cgroup = cgroup
+		l.cgroupDirectory, err = os.Open(cgroup)
+		if err != nil {
+			l.release()
+			return nil, err
+		}
+	}
+	return l, nil
+}
+
+// createCgroup creates the cgroup of the session id under parent with
+// limits applied, enabling the controllers it needs
+func createCgroup(parent string, id string, limits ResourceLimits) (string, error) {
+	if _, err := os.Stat(parent); errors.Is(err, os.ErrNotExist) {
+		if _, err := os.Stat(filepath.Join(filepath.Dir(parent), "cgroup.controllers")); err != nil {
+			return "", fmt.Errorf("'%s' is not in a cgroup v2 hierarchy", parent)
+		}
+		if err := os.Mkdir(parent, 0o755); err != nil && !errors.Is(err, os.ErrExist) {
+			return "", err
+		}
+	}
+	available, err := os.ReadFile(filepath.Join(parent, "cgroup.controllers"))
+	if err != nil {
+		return "", fmt.Errorf("'%s' is not a cgroup v2 directory", parent)
+	}
+	// memory.swap.max keeps memory.max from being evaded by swapping, and
+	// is missing when swap accounting is disabled
+	settings := []struct {
+		controller string
+		file       string
+		value      uint64
+		optional   bool
+	}{
+		{controller: "cpu", file: "cpu.weight", value: limits.CpuWeight},
+		{controller: "memory", file: "memory.max", value: limits.MemoryBytes},
+		{controller: "memory", file: "memory.swap.max", value: limits.MemoryBytes, optional: true},
+		{controller: "pids", file: "pids.max", value: limits.Pids},
+	}
+	controllers := []string{}
+	for _, setting := range settings {
+		if setting.value == 0 || setting.optional {
+			continue
+		}
+		if !containsField(string(available), setting.controller) {
+			return "", fmt.Errorf("the %s controller is not available in '%s'", setting.controller, parent)
+		}
+		controllers = append(controllers, "+"+setting.controller)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.subtree_control"), []byte(strings.Join(controllers, " ")), 0o644); err != nil {
+		return "", fmt.Errorf("failed to enable controllers %v in '%s': %w", controllers, parent, err)
+	}
+	cgroup := filepath.Join(parent, id)
+	if err := os.Mkdir(cgroup, 0o755); err != nil {
+		return "", err
+	}
+	for _, setting := range settings {
+		if setting.value == 0 {
+			continue
+		}
+		value := strconv.FormatUint(setting.value, 10)
+		if setting.optional {
+			value = "0"
+		}
+		err := os.WriteFile(filepath.Join(cgroup, setting.file), []byte(value), 0o644)
+		if err != nil && !(setting.optional && errors.Is(err, os.ErrNotExist)) {
+			os.Remove(cgroup)
+			return "", fmt.Errorf("failed to set %s of '%s': %w", setting.file, cgroup, err)
+		}
+	}
+	return cgroup, nil
+}
+
+// containsField reports whether the whitespace separated fields of s include
+// field
+func containsField(s string, field string) bool {
+	for _, candidate := range strings.Fields(s) {
+		if candidate == field {
+			return true
+		}
+	}
+	return false
+}
+
+// prepare makes cmd start in the session's cgroup, if any, and through the
+// helper setting the session's rlimits, if any, so that they apply from the
+// start of the command
+func (l *resourceLimits) prepare(cmd *exec.Cmd) {
+	if l == nil {
+		return
+	}
+	if l.cgroupDirectory != nil {
+		if cmd.SysProcAttr == nil {
+			cmd.SysProcAttr = &syscall.SysProcAttr{}
+		}
+		cmd.SysProcAttr.UseCgroupFD = true
+		cmd.SysProcAttr.CgroupFD = int(l.cgroupDirectory.Fd())
+	}
+	if !l.limits.rlimits() {
+		return
+	}
+	rlimits, _ := json.Marshal(l.rlimits())
+	cmd.Env = append(cmd.Env, rlimitsVariable+"="+string(rlimits))
+	cmd.Args = append([]string{rlimitsHelperName, cmd.Path}, cmd.Args...)
+	cmd.Path = "/proc/self/exe"
+}
+
+// rlimits returns the rlimits to set, which children of the command inherit
+func (l *resourceLimits) rlimits() []rlimit {
+	// The hard CPU time limit is a second above the soft one, as processes
+	// exceeding the soft limit are sent SIGXCPU, which they may handle, while
+	// processes reaching the hard limit are killed
+	candidates := []struct {
+		name     string
+		resource int
+		value    uint64
+		slack    uint64
+	}{
+		{name: "RLIMIT_AS", resource: unix.RLIMIT_AS, value: l.limits.AddressSpaceBytes},
+		{name: "RLIMIT_CPU", resource: unix.RLIMIT_CPU, value: uint64((l.limits.CpuTime + time.Second - 1) / time.Second), slack: 1},
+		{name: "RLIMIT_NOFILE", resource: unix.RLIMIT_NOFILE, value: l.limits.OpenFiles},
+		{name: "RLIMIT_NPROC", resource: unix.RLIMIT_NPROC, value: l.limits.Processes},
+	}
+	result := []rlimit{}
+	for _, candidate := range candidates {
+		if candidate.value == 0 {
+			continue
+		}
+		result = append(result, rlimit{
+			Name:     candidate.name,
+			Resource: candidate.resource,
+			Limit:    unix.Rlimit{Cur: candidate.value, Max: candidate.value + candidate.slack},
+		})
+	}
+	return result
+}
+
+// started releases what was only needed to start the process of the session
+func (l *resourceLimits) started() {
+	if l == nil || l.cgroupDirectory == nil {
+		return
+	}
+	l.cgroupDirectory.Close()
+	l.cgroupDirectory = nil
+}
+
+// readCgroupEvent returns the count of event in the events file of the
+// session's cgroup
+func (l *resourceLimits) readCgroupEvent(file string, event string) uint64 {
+	data, err := os.ReadFile(filepath.Join(l.cgroup, file))
+	if err != nil {
+		return 0
+	}
+	for _, line := range strings.Split(string(data), "\n") {
+		if name, value, found := strings.Cut(line, " "); found && name == event {
+			count, _ := strconv.ParseUint(value, 10, 64)
+			return count
+		}
+	}
+	return 0
+}
+
+// violations describes the cgroup limits reached since they were last
+// checked
+func (l *resourceLimits) violations() []string {
+	if l == nil || l.cgroup == "" {
+		return nil
+	}
+	result := []string{}
+	if l.limits.MemoryBytes > 0 {
+		if count := l.readCgroupEvent("memory.events", "oom_kill"); count > l.oomKills {
+			result = append(result, fmt.Sprintf("memory limit of %d bytes reached, %d process(es) killed", l.limits.MemoryBytes, count-l.oomKills))
+			l.oomKills = count
+		}
+	}
+	if l.limits.Pids > 0 {
+		if count := l.readCgroupEvent("pids.events", "max"); count > l.pidsMax {
+			result = append(result, fmt.Sprintf("process limit of %d reached, %d process(es) not started", l.limits.Pids, count-l.pidsMax))
+			l.pidsMax = count
+		}
+	}
+	return result
+}
+
+// exitLimit describes the limit that got the process terminated as state
+// tells, if any
+func (l *resourceLimits) exitLimit(state *os.ProcessState) string {
+	if l == nil || state == nil {
+		return ""
+	}
+	waitStatus, ok := state.Sys().(syscall.WaitStatus)
+	if !ok || !waitStatus.Signaled() {
+		return ""
+	}
+	cpuTime := state.UserTime() + state.SystemTime()
+	switch {
+	case waitStatus.Signal() == syscall.SIGXCPU && l.limits.CpuTime > 0,
+		waitStatus.Signal() == syscall.SIGKILL && l.limits.CpuTime > 0 && cpuTime >= l.limits.CpuTime:
+		return fmt.Sprintf("CPU time limit of %v", l.limits.CpuTime)
+	case waitStatus.Signal() == syscall.SIGKILL && l.cgroup != "" && l.limits.MemoryBytes > 0 && l.readCgroupEvent("memory.events", "oom_kill") > 0:
+		return fmt.Sprintf("memory limit of %d bytes", l.limits.MemoryBytes)
+	}
+	return ""
+}
+
+// release kills the processes left in the session's cgroup and removes it
+func (l *resourceLimits) release() error {
+	if l == nil {
+		return nil
+	}
+	if l.cgroupDirectory != nil {
+		l.cgroupDirectory.Close()
+		l.cgroupDirectory = nil
+	}
+	if l.cgroup == "" {
+		return nil
+	}
+	// cgroup.kill requires Linux 5.14, processes are otherwise left to the
+	// termination of the session
+	os.WriteFile(filepath.Join(l.cgroup, "cgroup.kill"), []byte("1"), 0o644)
+	deadline := time.Now().Add(cgroupRemovalTimeout)
+	for {
+		err := os.Remove(l.cgroup)
+		if err == nil || errors.Is(err, os.ErrNotExist) {
+			return nil
+		}
+		if !time.Now().Before(deadline) {
+			return fmt.Errorf("failed to remove cgroup '%s': %w", l.cgroup, err)
+		}
+		time.Sleep(terminationPollInterval)
+	}
+}
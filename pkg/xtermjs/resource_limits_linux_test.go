@@ -0,0 +1,178 @@
+package xtermjs
+
+import (
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestResourceLimits_prepare(test *testing.T) {
+	testCases := []struct {
+		name      string
+		sandboxed bool
+	}{
+		{name: "unsandboxed"},
+		{name: "sandboxed", sandboxed: true},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			limits, err := newResourceLimits(ResourceLimits{AddressSpaceBytes: 1 << 30, CpuTime: 1500 * time.Millisecond, OpenFiles: 64}, "test", defaultLogger)
+			if err != nil {
+				test.Fatalf("failed to prepare resource limits: %s", err)
+			}
+			// the test binary is started as the helper setting the limits,
+			// which the command reports from its start
+			cmd := exec.Command("cat", "/proc/self/limits")
+			cmd.Env = []string{}
+			limits.prepare(cmd)
+			var sandbox *sandbox
+			if testCase.sandboxed {
+				sandbox, err = newSandbox(Sandbox{Enabled: true}, nil)
+				if err != nil {
+					test.Skipf("sandboxes are not available: %s", err)
+				}
+				if err := sandbox.prepare(cmd, ""); err != nil {
+					test.Fatalf("failed to prepare sandbox: %s", err)
+				}
+			}
+			tty, err := startTTY(cmd, nil, testCase.sandboxed)
+			if err != nil {
+				sandbox.close()
+				test.Skipf("failed to start cat: %s", sandbox.explain(err))
+			}
+			limits.started()
+			if sandbox != nil {
+				tty, err = sandbox.start()
+				if err != nil {
+					cmd.Process.Kill()
+					cmd.Wait()
+					test.Fatalf("failed to start sandbox: %s", err)
+				}
+			}
+			defer tty.Close()
+			output := make(chan string)
+			go func() {
+				data, _ := io.ReadAll(tty)
+				output <- string(data)
+			}()
+			cmd.Wait()
+			actual := map[string]string{}
+			for _, line := range strings.Split(<-output, "\n") {
+				fields := strings.Fields(line)
+				if len(fields) > 3 && fields[0] == "Max" {
+					actual[strings.Join(fields[1:len(fields)-3], " ")] = strings.Join(fields[len(fields)-3:len(fields)-1], " ")
+				}
+			}
+			expected := map[string]string{
+				"address space": "1073741824 1073741824",
+				"cpu time":      "2 3",
+				"open files":    "64 64",
+			}
+			for name, limit := range expected {
+				if actual[name] != limit {
+					test.Errorf("expected %s limits of %q, got %q", name, limit, actual[name])
+				}
+			}
+		})
+	}
+}
+
+func TestCreateCgroup(test *testing.T) {
+	// A directory standing in for cgroupfs, whose files would already exist.
+	parent := test.TempDir()
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpuset cpu io memory pids\n"), 0o644); err != nil {
+		test.Fatal(err)
+	}
+	cgroup, err := createCgroup(parent, "session", ResourceLimits{CpuWeight: 50, MemoryBytes: 1 << 28, Pids: 100})
+	if err != nil {
+		test.Fatalf("failed to create cgroup: %s", err)
+	}
+	expected := map[string]string{
+		filepath.Join(parent, "cgroup.subtree_control"): "+cpu +memory +pids",
+		filepath.Join(cgroup, "cpu.weight"):             "50",
+		filepath.Join(cgroup, "memory.max"):             "268435456",
+		filepath.Join(cgroup, "memory.swap.max"):        "0",
+		filepath.Join(cgroup, "pids.max"):               "100",
+	}
+	for name, value := range expected {
+		if data, err := os.ReadFile(name); err != nil || string(data) != value {
+			test.Errorf("expected %s to be %q, got %q, %v", name, value, data, err)
+		}
+	}
+
+	if _, err := createCgroup(parent, "other", ResourceLimits{Pids: 100}); err != nil {
+		test.Errorf("failed to create cgroup with pids.max only: %s", err)
+	}
+	if err := os.WriteFile(filepath.Join(parent, "cgroup.controllers"), []byte("cpu\n"), 0o644); err != nil {
+		test.Fatal(err)
+	}
+	if _, err := createCgroup(parent, "unavailable", ResourceLimits{MemoryBytes: 1 << 28}); err == nil {
+		test.Errorf("expected an error without the memory controller")
+	}
+	if _, err := createCgroup(test.TempDir(), "v1", ResourceLimits{Pids: 100}); err == nil {
+		test.Errorf("expected an error outside of cgroup v2")
+	}
+	if _, err := createCgroup(filepath.Join(test.TempDir(), "missing"), "v1", ResourceLimits{Pids: 100}); err == nil {
+		test.Errorf("expected an error outside of a cgroup v2 hierarchy")
+	}
+}
+
+func TestResourceLimits_violations(test *testing.T) {
+	cgroup := test.TempDir()
+	limits := &resourceLimits{cgroup: cgroup, limits: ResourceLimits{MemoryBytes: 1024, Pids: 10}}
+	writeEvents := func(memory string, pids string) {
+		os.WriteFile(filepath.Join(cgroup, "memory.events"), []byte(memory), 0o644)
+		os.WriteFile(filepath.Join(cgroup, "pids.events"), []byte(pids), 0o644)
+	}
+	testCases := []struct {
+		name     string
+		memory   string
+		pids     string
+		expected []string
+	}{
+		{name: "none", memory: "low 0\nhigh 0\nmax 3\noom 0\noom_kill 0\n", pids: "max 0\n", expected: []string{}},
+		{name: "both", memory: "low 0\nhigh 0\nmax 5\noom 1\noom_kill 2\n", pids: "max 1\n", expected: []string{
+			"memory limit of 1024 bytes reached, 2 process(es) killed",
+			"process limit of 10 reached, 1 process(es) not started",
+		}},
+		{name: "already reported", memory: "low 0\nhigh 0\nmax 5\noom 1\noom_kill 2\n", pids: "max 1\n", expected: []string{}},
+		{name: "more", memory: "low 0\nhigh 0\nmax 5\noom 1\noom_kill 2\n", pids: "max 4\n", expected: []string{
+			"process limit of 10 reached, 3 process(es) not started",
+		}},
+	}
+	for _, testCase := range testCases {
+		writeEvents(testCase.memory, testCase.pids)
+		actual := limits.violations()
+		if !reflect.DeepEqual(actual, testCase.expected) {
+			test.Errorf("%s: expected %q, got %q", testCase.name, testCase.expected, actual)
+		}
+	}
+}
+
+func TestResourceLimits_exitLimit(test *testing.T) {
+	limits := &resourceLimits{limits: ResourceLimits{CpuTime: time.Minute}}
+	testCases := []struct {
+		script   string
+		expected string
+	}{
+		{script: "kill -XCPU $$", expected: "CPU time limit of 1m0s"},
+		{script: "kill -TERM $$", expected: ""},
+		{script: "exit 1", expected: ""},
+	}
+	for _, testCase := range testCases {
+		cmd := exec.Command("/bin/sh", "-c", testCase.script)
+		cmd.Run()
+		if actual := limits.exitLimit(cmd.ProcessState); actual != testCase.expected {
+			test.Errorf("%s: expected %q, got %q", testCase.script, testCase.expected, actual)
+		}
+	}
+}
@@ -0,0 +1,30 @@
+//go:build !linux
+
+package xtermjs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// resourceLimits is not implemented as only Linux supports resource limits
+type resourceLimits struct{}
+
+// newResourceLimits fails when any limit is to be applied
+func newResourceLimits(limits ResourceLimits, id string, logger Logger) (*resourceLimits, error) {
+	if !limits.rlimits() && !limits.cgroupLimits() {
+		return nil, nil
+	}
+	return nil, errors.New("resource limits are only supported on linux")
+}
+
+func (l *resourceLimits) prepare(cmd *exec.Cmd) {}
+
+func (l *resourceLimits) started() {}
+
+func (l *resourceLimits) violations() []string { return nil }
+
+func (l *resourceLimits) exitLimit(state *os.ProcessState) string { return "" }
+
+func (l *resourceLimits) release() error { return nil }
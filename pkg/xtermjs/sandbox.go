@@ -0,0 +1,40 @@
+package xtermjs
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// DefaultSandboxHomeDirectory is the home directory of sandboxed sessions
+// that are not started as another user
+const DefaultSandboxHomeDirectory = "/home/cloudshell"
+
+// DefaultSandboxReadOnlyPaths are the paths of the handler bind mounted into
+// sandboxes, which hold the programs and configuration of most systems
+var DefaultSandboxReadOnlyPaths = []string{"/bin", "/etc", "/lib", "/lib32", "/lib64", "/libx32", "/opt", "/sbin", "/usr"}
+
+// ValidateSandbox returns an error when a path of sandbox is not absolute
+func ValidateSandbox(sandbox Sandbox) error {
+	paths := append([]string{}, sandbox.ReadOnlyPaths...)
+	if sandbox.HomeDirectory != "" {
+		paths = append(paths, sandbox.HomeDirectory)
+	}
+	for _, path := range paths {
+		if !filepath.IsAbs(path) {
+			return fmt.Errorf("invalid sandbox path '%s', expected an absolute path", path)
+		}
+	}
+	return nil
+}
+
+// sandboxHomeDirectory returns the home directory of sessions in sandbox when
+// they run as runAs, if specified
+func sandboxHomeDirectory(sandbox Sandbox, runAs *account) string {
+	switch {
+	case sandbox.HomeDirectory != "":
+		return sandbox.HomeDirectory
+	case runAs != nil && filepath.IsAbs(runAs.home):
+		return runAs.home
+	}
+	return DefaultSandboxHomeDirectory
+}
@@ -0,0 +1,385 @@
+package xtermjs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"syscall"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// sandboxRoot is where the root file system of a sandbox is assembled before
+// becoming its root. What is mounted there is private to the sandbox
+const sandboxRoot = "/tmp"
+
+// sandboxDevices are the devices of the handler bind mounted into sandboxes
+var sandboxDevices = []string{"full", "null", "random", "tty", "urandom", "zero"}
+
+// sandboxDeviceLinks are the symbolic links created in the /dev of sandboxes
+var sandboxDeviceLinks = map[string]string{
+	"fd":     "/proc/self/fd",
+	"ptmx":   "pts/ptmx",
+	"stderr": "/proc/self/fd/2",
+	"stdin":  "/proc/self/fd/0",
+	"stdout": "/proc/self/fd/1",
+}
+
+// sandboxForwardedSignals are passed on by the init process of a sandbox to
+// the command, as the init process of a PID namespace is immune to the
+// signals it does not handle
+var sandboxForwardedSignals = []os.Signal{syscall.SIGHUP, syscall.SIGINT, syscall.SIGQUIT, syscall.SIGTERM, syscall.SIGUSR1, syscall.SIGUSR2}
+
+// sandboxMount is a path of the handler mounted into a sandbox
+type sandboxMount struct {
+	// fd is a descriptor of the path opened before sandboxRoot is mounted
+	// over, or -1 for a symbolic link
+	fd       int
+	isDir    bool
+	link     string
+	readOnly bool
+	target   string
+}
+
+// runSandboxInit runs as the init process of a sandbox, in the namespaces
+// created for it. Once the handler tells it to, it sets the sandbox up as
+// described by the sandboxConfig it was given, starts the command in it on a
+// tty of the sandbox, whose master it hands over to the handler, and exits as
+// the command does, with 128 plus the number of the signal that terminated it
+// if any. What fails is reported to the handler instead, see sandbox.start
+func runSandboxInit() {
+	// capabilities are per thread and inherited by the command from the
+	// thread starting it
+	runtime.LockOSThread()
+	unix.CloseOnExec(sandboxControlFd)
+	control := os.NewFile(sandboxControlFd, "sandbox")
+	var config sandboxConfig
+	err := json.Unmarshal([]byte(os.Getenv(sandboxConfigVariable)), &config)
+	os.Unsetenv(sandboxConfigVariable)
+	if err == nil {
+		_, err = control.Read(make([]byte, 1))
+	}
+	if err == nil {
+		err = setupSandbox(config)
+	}
+	var ptmx, tty *os.File
+	if err == nil {
+		ptmx, tty, err = pty.Open()
+	}
+	pid := 0
+	if err == nil {
+		pid, err = startSandboxed(config, tty)
+		tty.Close()
+	}
+	if err == nil {
+		err = unix.Sendmsg(int(control.Fd()), []byte{0}, unix.UnixRights(int(ptmx.Fd())), nil, 0)
+		ptmx.Close()
+	}
+	if err != nil {
+		control.WriteString(err.Error())
+		os.Exit(1)
+	}
+	control.Close()
+	os.Exit(superviseSandboxed(pid))
+}
+
+// setupSandbox assembles the root file system of the sandbox and makes it the
+// root, in the sandbox's home directory
+func setupSandbox(config sandboxConfig) error {
+	if err := unix.Mount("", "/", "", unix.MS_REC|unix.MS_PRIVATE, ""); err != nil {
+		return fmt.Errorf("failed to make mounts private: %w", err)
+	}
+	mounts := []sandboxMount{}
+	for _, path := range config.ReadOnlyPaths {
+		mount, err := openSandboxMount(path, path, true)
+		if errors.Is(err, os.ErrNotExist) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, mount)
+	}
+	if config.HomeSource != "" {
+		mount, err := openSandboxMount(config.HomeSource, config.Home, false)
+		if err != nil {
+			return err
+		}
+		mounts = append(mounts, mount)
+	}
+	if err := mountTmpfs(sandboxRoot, "mode=0755"); err != nil {
+		return err
+	}
+	for _, mount := range mounts {
+		if err := mount.mount(sandboxRoot); err != nil {
+			return err
+		}
+	}
+	if config.HomeSource == "" {
+		if err := mountTmpfs(filepath.Join(sandboxRoot, config.Home), "mode=0700"); err != nil {
+			return err
+		}
+	}
+	if err := mountTmpfs(filepath.Join(sandboxRoot, "tmp"), "mode=1777"); err != nil {
+		return err
+	}
+	if err := setupSandboxDevices(filepath.Join(sandboxRoot, "dev")); err != nil {
+		return err
+	}
+	proc := filepath.Join(sandboxRoot, "proc")
+	if err := os.Mkdir(proc, 0o555); err != nil {
+		return err
+	}
+	if err := unix.Mount("proc", proc, "proc", unix.MS_NODEV|unix.MS_NOEXEC|unix.MS_NOSUID, ""); err != nil {
+		return fmt.Errorf("failed to mount /proc: %w", err)
+	}
+	if !config.Network {
+		if err := bringLoopbackUp(); err != nil {
+			return fmt.Errorf("failed to bring the loopback interface up: %w", err)
+		}
+	}
+	if err := unix.Mount("", sandboxRoot, "", unix.MS_BIND|unix.MS_REMOUNT|unix.MS_RDONLY, ""); err != nil {
+		return fmt.Errorf("failed to make the root read-only: %w", err)
+	}
+	// stacking the old root on the new one lets it be detached without a
+	// directory of its own
+	if err := unix.Chdir(sandboxRoot); err != nil {
+		return err
+	}
+	if err := unix.PivotRoot(".", "."); err != nil {
+		return fmt.Errorf("failed to change the root: %w", err)
+	}
+	if err := unix.Unmount(".", unix.MNT_DETACH); err != nil {
+		return fmt.Errorf("failed to detach the handler's root: %w", err)
+	}
+	return unix.Chdir(config.Home)
+}
+
+// openSandboxMount opens path to be mounted at target in a sandbox
+func openSandboxMount(path string, target string, readOnly bool) (sandboxMount, error) {
+	mount := sandboxMount{fd: -1, readOnly: readOnly, target: target}
+	info, err := os.Lstat(path)
+	if err != nil {
+		return mount, err
+	}
+	if info.Mode()&os.ModeSymlink != 0 {
+		mount.link, err = os.Readlink(path)
+		return mount, err
+	}
+	mount.isDir = info.IsDir()
+	mount.fd, err = unix.Open(path, unix.O_PATH|unix.O_CLOEXEC, 0)
+	if err != nil {
+		return mount, fmt.Errorf("failed to open '%s': %w", path, err)
+	}
+	return mount, nil
+}
+
+// mount bind mounts, or links, the path into the root file system being
+// assembled at root
+func (m sandboxMount) mount(root string) error {
+	target := filepath.Join(root, m.target)
+	if err := os.MkdirAll(filepath.Dir(target), 0o755); err != nil {
+		return err
+	}
+	if m.link != "" {
+		return os.Symlink(m.link, target)
+	}
+	defer unix.Close(m.fd)
+	if err := createMountPoint(target, m.isDir); err != nil {
+		return err
+	}
+	source := "/proc/self/fd/" + strconv.Itoa(m.fd)
+	if err := unix.Mount(source, target, "", unix.MS_BIND|unix.MS_REC, ""); err != nil {
+		return fmt.Errorf("failed to mount '%s': %w", m.target, err)
+	}
+	if !m.readOnly {
+		return nil
+	}
+	if err := remountReadOnly(target); err != nil {
+		return fmt.Errorf("failed to make '%s' read-only: %w", m.target, err)
+	}
+	return nil
+}
+
+// createMountPoint creates the directory, or empty file, to mount over
+func createMountPoint(target string, isDir bool) error {
+	if isDir {
+		return os.MkdirAll(target, 0o755)
+	}
+	file, err := os.OpenFile(target, os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	return file.Close()
+}
+
+// remountReadOnly makes the bind mount at target read-only, keeping the flags
+// that a user namespace is not allowed to clear
+func remountReadOnly(target string) error {
+	var stat unix.Statfs_t
+	if err := unix.Statfs(target, &stat); err != nil {
+		return err
+	}
+	flags := uintptr(unix.MS_BIND | unix.MS_REMOUNT | unix.MS_RDONLY)
+	lockedFlags := []struct {
+		stat  int64
+		mount uintptr
+	}{
+		{stat: unix.ST_NODEV, mount: unix.MS_NODEV},
+		{stat: unix.ST_NOEXEC, mount: unix.MS_NOEXEC},
+		{stat: unix.ST_NOSUID, mount: unix.MS_NOSUID},
+		{stat: unix.ST_NOATIME, mount: unix.MS_NOATIME},
+		{stat: unix.ST_NODIRATIME, mount: unix.MS_NODIRATIME},
+		{stat: unix.ST_RELATIME, mount: unix.MS_RELATIME},
+	}
+	for _, locked := range lockedFlags {
+		if int64(stat.Flags)&locked.stat != 0 {
+			flags |= locked.mount
+		}
+	}
+	if int64(stat.Flags)&(unix.ST_NOATIME|unix.ST_RELATIME) == 0 {
+		flags |= unix.MS_STRICTATIME
+	}
+	return unix.Mount("", target, "", flags, "")
+}
+
+// mountTmpfs mounts an empty file system in memory at target, creating it
+func mountTmpfs(target string, options string) error {
+	if err := os.MkdirAll(target, 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount("tmpfs", target, "tmpfs", unix.MS_NODEV|unix.MS_NOSUID, options); err != nil {
+		return fmt.Errorf("failed to mount a tmpfs at '%s': %w", target, err)
+	}
+	return nil
+}
+
+// setupSandboxDevices populates dev with the handler's sandboxDevices and a
+// devpts of its own, in which the tty of the session is the only one
+func setupSandboxDevices(dev string) error {
+	if err := mountTmpfs(dev, "mode=0755"); err != nil {
+		return err
+	}
+	for _, device := range sandboxDevices {
+		target := filepath.Join(dev, device)
+		if err := createMountPoint(target, false); err != nil {
+			return err
+		}
+		if err := unix.Mount(filepath.Join("/dev", device), target, "", unix.MS_BIND, ""); err != nil {
+			return fmt.Errorf("failed to mount /dev/%s: %w", device, err)
+		}
+	}
+	pts := filepath.Join(dev, "pts")
+	if err := os.Mkdir(pts, 0o755); err != nil {
+		return err
+	}
+	if err := unix.Mount("devpts", pts, "devpts", unix.MS_NOEXEC|unix.MS_NOSUID, "newinstance,ptmxmode=0666,mode=0620"); err != nil {
+		return fmt.Errorf("failed to mount /dev/pts: %w", err)
+	}
+	for name, link := range sandboxDeviceLinks {
+		if err := os.Symlink(link, filepath.Join(dev, name)); err != nil {
+			return err
+		}
+	}
+	shm := filepath.Join(dev, "shm")
+	if err := os.Mkdir(shm, 0o755); err != nil {
+		return err
+	}
+	return os.Chmod(shm, os.ModeSticky|0o777)
+}
+
+// bringLoopbackUp brings up the loopback interface of the sandbox's network,
+// which is created down
+func bringLoopbackUp() error {
+	fd, err := unix.Socket(unix.AF_INET, unix.SOCK_DGRAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return err
+	}
+	defer unix.Close(fd)
+	ifreq, err := unix.NewIfreq("lo")
+	if err != nil {
+		return err
+	}
+	if err := unix.IoctlIfreq(fd, unix.SIOCGIFFLAGS, ifreq); err != nil {
+		return err
+	}
+	ifreq.SetUint16(ifreq.Uint16() | unix.IFF_UP)
+	return unix.IoctlIfreq(fd, unix.SIOCSIFFLAGS, ifreq)
+}
+
+// startSandboxed starts the command in the sandbox, in a new session of tty,
+// without the capabilities of the init process
+func startSandboxed(config sandboxConfig, tty *os.File) (int, error) {
+	if err := unix.Prctl(unix.PR_CAP_AMBIENT, unix.PR_CAP_AMBIENT_CLEAR_ALL, 0, 0, 0); err != nil {
+		return 0, fmt.Errorf("failed to clear ambient capabilities: %w", err)
+	}
+	for capability := 0; ; capability++ {
+		err := unix.Prctl(unix.PR_CAPBSET_DROP, uintptr(capability), 0, 0, 0)
+		if errors.Is(err, unix.EINVAL) {
+			break
+		}
+		if err != nil {
+			return 0, fmt.Errorf("failed to drop capability %d: %w", capability, err)
+		}
+	}
+	// root in the sandbox would otherwise regain the inheritable capabilities
+	header := unix.CapUserHeader{Version: unix.LINUX_CAPABILITY_VERSION_3}
+	capabilities := [2]unix.CapUserData{}
+	if err := unix.Capget(&header, &capabilities[0]); err != nil {
+		return 0, fmt.Errorf("failed to get capabilities: %w", err)
+	}
+	capabilities[0].Inheritable = 0
+	capabilities[1].Inheritable = 0
+	if err := unix.Capset(&header, &capabilities[0]); err != nil {
+		return 0, fmt.Errorf("failed to clear inheritable capabilities: %w", err)
+	}
+	if err := unix.Prctl(unix.PR_SET_NO_NEW_PRIVS, 1, 0, 0, 0); err != nil {
+		return 0, fmt.Errorf("failed to forbid new privileges: %w", err)
+	}
+	process, err := os.StartProcess(config.Path, config.Args, &os.ProcAttr{
+		Dir:   config.Home,
+		Env:   os.Environ(),
+		Files: []*os.File{tty, tty, tty},
+		Sys:   &syscall.SysProcAttr{Setctty: true, Setsid: true},
+	})
+	if err != nil {
+		return 0, err
+	}
+	return process.Pid, nil
+}
+
+// superviseSandboxed passes sandboxForwardedSignals on to the command and
+// reaps the processes of the sandbox until the command exits, returning the
+// exit code of the init process
+func superviseSandboxed(pid int) int {
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, sandboxForwardedSignals...)
+	go func() {
+		for sig := range signals {
+			syscall.Kill(pid, sig.(syscall.Signal))
+		}
+	}()
+	for {
+		var status syscall.WaitStatus
+		reaped, err := syscall.Wait4(-1, &status, 0, nil)
+		if errors.Is(err, syscall.EINTR) {
+			continue
+		}
+		if err != nil {
+			return 1
+		}
+		if reaped != pid {
+			continue
+		}
+		if status.Signaled() {
+			return 128 + int(status.Signal())
+		}
+		return status.ExitStatus()
+	}
+}
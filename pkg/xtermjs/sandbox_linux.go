@@ -0,0 +1,243 @@
+package xtermjs
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// sandboxInitName is the name the handler's executable is started with to
+// become the init process of a sandbox, see runSandboxInit
+const sandboxInitName = "cloudshell-sandbox-init"
+
+// sandboxConfigVariable is the environment variable passing the
+// sandboxConfig to the init process of a sandbox, which removes it
+const sandboxConfigVariable = "CLOUDSHELL_SANDBOX_CONFIG"
+
+// sandboxControlFd is the descriptor of the init process of a sandbox
+// connected to the handler, see sandbox.start
+const sandboxControlFd = 3
+
+// sandboxStartTimeout is how long the init process of a sandbox is given to
+// set the sandbox up and start the command
+const sandboxStartTimeout = 10 * time.Second
+
+// sandboxConfig tells the init process of a sandbox how to set it up and what
+// to start in it
+type sandboxConfig struct {
+	Args          []string `json:"args"`
+	Home          string   `json:"home"`
+	HomeSource    string   `json:"homeSource,omitempty"`
+	Network       bool     `json:"network,omitempty"`
+	Path          string   `json:"path"`
+	ReadOnlyPaths []string `json:"readOnlyPaths"`
+}
+
+// sandbox starts the process of a session in namespaces of its own. The
+// handler's executable is started in them as the sandbox's init process,
+// which sets the sandbox up and then starts the session's command
+type sandbox struct {
+	config sandboxConfig
+	// control and initControl are the ends of the connection between the
+	// handler and the init process
+	control     *os.File
+	initControl *os.File
+	gid         int
+	uid         int
+}
+
+// The init process of a sandbox is the handler's executable, which is taken
+// over as soon as this package is initialized
+func init() {
+	if len(os.Args) > 0 && os.Args[0] == sandboxInitName {
+		runSandboxInit()
+	}
+}
+
+// newSandbox prepares a sandbox for a session started as runAs, if specified,
+// when options enable sandboxes
+func newSandbox(options Sandbox, runAs *account) (*sandbox, error) {
+	if !options.Enabled {
+		return nil, nil
+	}
+	if err := checkUserNamespaces(); err != nil {
+		return nil, err
+	}
+	s := &sandbox{
+		config: sandboxConfig{
+			Home:          sandboxHomeDirectory(options, runAs),
+			Network:       options.Network,
+			ReadOnlyPaths: options.ReadOnlyPaths,
+		},
+		gid: os.Getegid(),
+		uid: os.Geteuid(),
+	}
+	if s.config.ReadOnlyPaths == nil {
+		s.config.ReadOnlyPaths = DefaultSandboxReadOnlyPaths
+	}
+	if runAs != nil {
+		s.gid = int(runAs.gid)
+		s.uid = int(runAs.uid)
+	}
+	return s, nil
+}
+
+// checkUserNamespaces tells why user namespaces cannot be created when the
+// kernel settings known to disable them do
+func checkUserNamespaces() error {
+	if _, err := os.Stat("/proc/self/ns/user"); err != nil {
+		return errors.New("sandboxes require user namespaces, which the kernel does not support")
+	}
+	if value, err := os.ReadFile("/proc/sys/user/max_user_namespaces"); err == nil && strings.TrimSpace(string(value)) == "0" {
+		return errors.New("sandboxes require user namespaces, which are disabled as user.max_user_namespaces is 0")
+	}
+	if value, err := os.ReadFile("/proc/sys/kernel/unprivileged_userns_clone"); err == nil && strings.TrimSpace(string(value)) == "0" && os.Geteuid() != 0 {
+		return errors.New("sandboxes require unprivileged user namespaces, which are disabled as kernel.unprivileged_userns_clone is 0")
+	}
+	return nil
+}
+
+// prepare makes cmd start the init process of the sandbox in new namespaces,
+// which runs what cmd would have with its home directory, in the sandbox, at
+// homeSource when specified
+func (s *sandbox) prepare(cmd *exec.Cmd, homeSource string) error {
+	if s == nil {
+		return nil
+	}
+	s.config.Args = cmd.Args
+	s.config.HomeSource = homeSource
+	s.config.Path = cmd.Path
+	config, err := json.Marshal(s.config)
+	if err != nil {
+		return err
+	}
+	fds, err := unix.Socketpair(unix.AF_UNIX, unix.SOCK_STREAM|unix.SOCK_CLOEXEC, 0)
+	if err != nil {
+		return fmt.Errorf("failed to connect to the sandbox: %w", err)
+	}
+	// the handler's end is non-blocking so that reading from it can time out
+	if err := unix.SetNonblock(fds[0], true); err != nil {
+		unix.Close(fds[0])
+		unix.Close(fds[1])
+		return err
+	}
+	s.control = os.NewFile(uintptr(fds[0]), "sandbox")
+	s.initControl = os.NewFile(uintptr(fds[1]), "sandbox-init")
+	cmd.Path = "/proc/self/exe"
+	cmd.Args = []string{sandboxInitName}
+	cmd.Dir = "/"
+	cmd.Env = append(cmd.Env, "HOME="+s.config.Home, sandboxConfigVariable+"="+string(config))
+	cmd.ExtraFiles = []*os.File{s.initControl}
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWIPC | syscall.CLONE_NEWNS | syscall.CLONE_NEWPID | syscall.CLONE_NEWUSER
+	if !s.config.Network {
+		cmd.SysProcAttr.Cloneflags |= syscall.CLONE_NEWNET
+	}
+	// the user and group of the session are the only ones in the sandbox, and
+	// the init process keeps the capabilities it needs to set the sandbox up
+	// when they are not root
+	cmd.SysProcAttr.UidMappings = []syscall.SysProcIDMap{{ContainerID: s.uid, HostID: s.uid, Size: 1}}
+	cmd.SysProcAttr.GidMappings = []syscall.SysProcIDMap{{ContainerID: s.gid, HostID: s.gid, Size: 1}}
+	cmd.SysProcAttr.AmbientCaps = []uintptr{unix.CAP_NET_ADMIN, unix.CAP_SETPCAP, unix.CAP_SYS_ADMIN}
+	return nil
+}
+
+// explain tells when err, from starting the init process, is likely due to
+// user namespaces being unavailable
+func (s *sandbox) explain(err error) error {
+	if s == nil {
+		return err
+	}
+	if errors.Is(err, syscall.EPERM) || errors.Is(err, syscall.ENOSPC) || errors.Is(err, syscall.EUSERS) {
+		return fmt.Errorf("failed to create the namespaces of the sandbox, user namespaces may not be available to the handler (see the user.max_user_namespaces, kernel.unprivileged_userns_clone and kernel.apparmor_restrict_unprivileged_userns settings): %w", err)
+	}
+	return err
+}
+
+// start lets the init process, once started, set the sandbox up and start the
+// command, waiting for it to tell whether it did. The init process sends the
+// master of the command's tty, in the sandbox, once it started the command, or
+// what failed otherwise
+func (s *sandbox) start() (*os.File, error) {
+	defer s.close()
+	s.initControl.Close()
+	s.control.SetDeadline(time.Now().Add(sandboxStartTimeout))
+	if _, err := s.control.Write([]byte{0}); err != nil {
+		return nil, fmt.Errorf("failed to start the sandbox: %w", err)
+	}
+	message, fds, err := s.receive()
+	if err != nil {
+		return nil, fmt.Errorf("failed to set the sandbox up: %w", err)
+	}
+	if len(fds) != 1 {
+		for _, fd := range fds {
+			unix.Close(fd)
+		}
+		if len(fds) == 0 && len(message) > 0 {
+			return nil, fmt.Errorf("failed to set the sandbox up: %s", message)
+		}
+		return nil, fmt.Errorf("failed to set the sandbox up: received %d ttys", len(fds))
+	}
+	return os.NewFile(uintptr(fds[0]), "/dev/ptmx"), nil
+}
+
+// receive reads what the init process sends until it closes the connection,
+// returning the bytes and the descriptors received
+func (s *sandbox) receive() ([]byte, []int, error) {
+	rawConn, err := s.control.SyscallConn()
+	if err != nil {
+		return nil, nil, err
+	}
+	message := []byte{}
+	fds := []int{}
+	buffer := make([]byte, 1024)
+	oob := make([]byte, unix.CmsgSpace(4))
+	for {
+		var n, oobn int
+		var recvErr error
+		err := rawConn.Read(func(fd uintptr) bool {
+			n, oobn, _, _, recvErr = unix.Recvmsg(int(fd), buffer, oob, unix.MSG_CMSG_CLOEXEC)
+			return !errors.Is(recvErr, unix.EAGAIN)
+		})
+		if err == nil {
+			err = recvErr
+		}
+		if err == nil && oobn > 0 {
+			var controlMessages []unix.SocketControlMessage
+			controlMessages, err = unix.ParseSocketControlMessage(oob[:oobn])
+			for i := 0; err == nil && i < len(controlMessages); i++ {
+				var rights []int
+				rights, err = unix.ParseUnixRights(&controlMessages[i])
+				fds = append(fds, rights...)
+			}
+		}
+		if err != nil {
+			for _, fd := range fds {
+				unix.Close(fd)
+			}
+			return nil, nil, err
+		}
+		if n == 0 && oobn == 0 {
+			return message, fds, nil
+		}
+		message = append(message, buffer[:n]...)
+	}
+}
+
+// close closes the connection to the init process
+func (s *sandbox) close() {
+	if s == nil || s.control == nil {
+		return
+	}
+	s.control.Close()
+	s.initControl.Close()
+}
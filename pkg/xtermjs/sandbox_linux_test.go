@@ -0,0 +1,50 @@
+package xtermjs
+
+import (
+	"io"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestSandbox_start(test *testing.T) {
+	sandbox, err := newSandbox(Sandbox{Enabled: true, HomeDirectory: "/home/test"}, nil)
+	if err != nil {
+		test.Skipf("sandboxes are not available: %s", err)
+	}
+	// the test binary is started as the init process of the sandbox
+	// the session's tty is the only one in the sandbox
+	cmd := exec.Command("/bin/sh", "-c", "tr -d '\\0' </proc/1/cmdline; echo; pwd; tty; ls /dev/pts; touch /usr/cloudshell 2>/dev/null || echo read-only; touch ~/file /tmp/file && echo writable")
+	if err := sandbox.prepare(cmd, ""); err != nil {
+		test.Fatalf("failed to prepare sandbox: %s", err)
+	}
+	if _, err := startTTY(cmd, nil, true); err != nil {
+		sandbox.close()
+		test.Skipf("failed to start sandbox: %s", sandbox.explain(err))
+	}
+	tty, err := sandbox.start()
+	if err != nil {
+		cmd.Process.Kill()
+		cmd.Wait()
+		test.Fatalf("failed to start sandbox: %s", err)
+	}
+	defer tty.Close()
+	output := make(chan string)
+	go func() {
+		data, _ := io.ReadAll(tty)
+		output <- string(data)
+	}()
+	cmd.Wait()
+	expected := []string{sandboxInitName, "/home/test", "/dev/pts/0", "0", "ptmx", "read-only", "writable"}
+	actual := strings.Fields(<-output)
+	if strings.Join(actual, " ") != strings.Join(expected, " ") {
+		test.Errorf("expected %q, got %q", expected, actual)
+	}
+	if code := cmd.ProcessState.ExitCode(); code != 0 {
+		test.Errorf("expected exit code 0, got %d", code)
+	}
+}
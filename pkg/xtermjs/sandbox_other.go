@@ -0,0 +1,28 @@
+//go:build !linux
+
+package xtermjs
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+)
+
+// sandbox is not implemented as only Linux supports sandboxes
+type sandbox struct{}
+
+// newSandbox fails when options enable sandboxes
+func newSandbox(options Sandbox, runAs *account) (*sandbox, error) {
+	if !options.Enabled {
+		return nil, nil
+	}
+	return nil, errors.New("sandboxes are only supported on linux")
+}
+
+func (s *sandbox) prepare(cmd *exec.Cmd, homeSource string) error { return nil }
+
+func (s *sandbox) explain(err error) error { return err }
+
+func (s *sandbox) start() (*os.File, error) { return nil, nil }
+
+func (s *sandbox) close() {}
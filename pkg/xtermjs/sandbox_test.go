@@ -0,0 +1,55 @@
+package xtermjs
+
+import (
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestValidateSandbox(test *testing.T) {
+	testCases := []struct {
+		name        string
+		sandbox     Sandbox
+		expectError bool
+	}{
+		{name: "defaults", sandbox: Sandbox{Enabled: true}},
+		{name: "absolute paths", sandbox: Sandbox{HomeDirectory: "/home/sandbox", ReadOnlyPaths: []string{"/usr", "/etc/hosts"}}},
+		{name: "relative read-only path", sandbox: Sandbox{ReadOnlyPaths: []string{"/usr", "bin"}}, expectError: true},
+		{name: "relative home directory", sandbox: Sandbox{HomeDirectory: "home"}, expectError: true},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			err := ValidateSandbox(testCase.sandbox)
+			if testCase.expectError && err == nil {
+				test.Errorf("expected an error")
+			}
+			if !testCase.expectError && err != nil {
+				test.Errorf("expected no error, got %s", err)
+			}
+		})
+	}
+}
+
+func TestSandboxHomeDirectory(test *testing.T) {
+	runAs := &account{home: "/home/alice", name: "alice"}
+	testCases := []struct {
+		name     string
+		sandbox  Sandbox
+		runAs    *account
+		expected string
+	}{
+		{name: "default", expected: DefaultSandboxHomeDirectory},
+		{name: "account", runAs: runAs, expected: "/home/alice"},
+		{name: "account without a home", runAs: &account{name: "daemon"}, expected: DefaultSandboxHomeDirectory},
+		{name: "configured", sandbox: Sandbox{HomeDirectory: "/work"}, runAs: runAs, expected: "/work"},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			if actual := sandboxHomeDirectory(testCase.sandbox, testCase.runAs); actual != testCase.expected {
+				test.Errorf("expected %s, got %s", testCase.expected, actual)
+			}
+		})
+	}
+}
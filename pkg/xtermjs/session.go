@@ -0,0 +1,354 @@
+package xtermjs
+
+import (
+	"os"
+	"os/exec"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/gorilla/websocket"
+)
+
+// session is a spawned tty and the process attached to it. A session can
+// outlive the websocket connection that created it so that the frontend can
+// reattach to it after a disconnect
+type session struct {
+	// account is the name of the Unix account the session runs as, if not the
+	// handler's own
+	account string
+	// command and arguments are what the session runs, which cmd may start
+	// through helpers such as the init process of a sandbox
+	command       string
+	arguments     []string
+	id            string
+	cmd           *exec.Cmd
+	detachTimeout time.Duration
+	logger        Logger
+	// limits applies the resource limits of the session's processes, if any
+	limits *resourceLimits
+	// profile is the name of the profile the session was started from, if any
+	profile   string
+	recorder  *recorder
+	startTime time.Time
+	// terminationGracePeriod is how long the spawned processes are given to
+	// exit after each of the terminationSignals
+	terminationGracePeriod time.Duration
+	tty                    *os.File
+	user                   string
+
+	bytesIn  atomic.Uint64
+	bytesOut atomic.Uint64
+
+	mutex     sync.Mutex
+	closeOnce sync.Once
+	// compression decides which messages sent to connection are compressed
+	compression compressionPolicy
+	connection  *websocket.Conn
+	detachTimer *time.Timer
+	done        chan struct{}
+	exited      chan struct{}
+	// flow decides when output may be read from the tty
+	flow *flowControl
+	// frame is reused to encode the messages sent to connection
+	frame   []byte
+	onClose func(*session)
+	// output keeps the most recent output for frontends that reattach
+	output *outputBuffer
+	// protocol frames the messages sent to connection
+	protocol   protocol
+	remoteAddr string
+	// sent is the sequence number following the last output byte sent to a
+	// connection
+	sent uint64
+}
+
+func newSession(id string, cmd *exec.Cmd, tty *os.File, detachTimeout time.Duration, terminationGracePeriod time.Duration, replayBufferSizeBytes int, logger Logger) *session {
+	s := &session{
+		id:                     id,
+		cmd:                    cmd,
+		detachTimeout:          detachTimeout,
+		done:                   make(chan struct{}),
+		exited:                 make(chan struct{}),
+		flow:                   newFlowControl(DefaultFlowControlHighWatermarkBytes, DefaultFlowControlLowWatermarkBytes),
+		logger:                 logger,
+		output:                 newOutputBuffer(replayBufferSizeBytes),
+		startTime:              time.Now(),
+		terminationGracePeriod: terminationGracePeriod,
+		tty:                    tty,
+	}
+	go func() {
+		if err := cmd.Wait(); err != nil {
+			logger.Debugf("process exited: %s", err)
+		}
+		close(s.exited)
+	}()
+	return s
+}
+
+// info returns a snapshot of the session's details
+func (s *session) info() SessionInfo {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	info := SessionInfo{
+		Account:    s.account,
+		Attached:   s.connection != nil,
+		BytesIn:    s.bytesIn.Load(),
+		BytesOut:   s.bytesOut.Load(),
+		Command:    s.command,
+		ID:         s.id,
+		Profile:    s.profile,
+		Recording:  s.recorder.name(),
+		RemoteAddr: s.remoteAddr,
+		StartTime:  s.startTime,
+		User:       s.user,
+	}
+	if len(s.arguments) > 0 {
+		info.Arguments = s.arguments
+	}
+	if s.cmd.Process != nil {
+		info.PID = s.cmd.Process.Pid
+	}
+	return info
+}
+
+// attach makes connection, speaking proto and compressing messages according
+// to compression, the receiver of the session's output. The buffered output
+// from sequence onwards is replayed or, when sequence is negative, the output
+// that was not sent to the previous connection. A connection that was
+// previously attached is closed
+func (s *session) attach(connection *websocket.Conn, proto protocol, compression compressionPolicy, remoteAddr string, sequence int64) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.detachTimer != nil {
+		s.detachTimer.Stop()
+		s.detachTimer = nil
+	}
+	if s.connection != nil && s.connection != connection {
+		s.logger.Info("closing connection superseded by a newer one...")
+		closeConnection(s.connection, s.protocol.closeCode(CloseSessionSuperseded), "session attached to another connection")
+	}
+	s.flow.reset(proto.acknowledged())
+	s.compression = compression
+	s.connection = connection
+	s.protocol = proto
+	s.remoteAddr = remoteAddr
+	from := s.sent
+	if sequence >= 0 {
+		from = uint64(sequence)
+	}
+	missed, start := s.output.since(from)
+	if start > from {
+		s.logger.Warnf("%v bytes of output are no longer buffered and cannot be replayed", start-from)
+	}
+	if err := writeMessage(connection, proto, compression, message{Type: MessageSequence, Data: strconv.AppendUint(nil, start, 10)}); err != nil {
+		return err
+	}
+	if len(missed) > 0 {
+		s.logger.Infof("replaying %v bytes of output from sequence number %v", len(missed), start)
+		if msg, ok := lengthMessage(proto, missed); ok {
+			if err := writeMessage(connection, proto, compression, msg); err != nil {
+				return err
+			}
+		}
+		if err := writeMessage(connection, proto, compression, message{Type: MessageOutput, Data: missed}); err != nil {
+			return err
+		}
+		s.flow.sent(len(missed))
+	}
+	s.sent = s.output.next()
+	return nil
+}
+
+// detach releases connection from the session. When the session has no
+// detach timeout it is closed immediately, otherwise it is closed once the
+// timeout elapses without another connection being attached
+func (s *session) detach(connection *websocket.Conn) {
+	s.mutex.Lock()
+	if s.connection != connection {
+		s.mutex.Unlock()
+		return
+	}
+	s.connection = nil
+	s.flow.reset(false)
+	if s.detachTimeout <= 0 {
+		s.mutex.Unlock()
+		s.close()
+		return
+	}
+	s.logger.Infof("session detached, keeping it alive for %v...", s.detachTimeout)
+	s.detachTimer = time.AfterFunc(s.detachTimeout, func() {
+		s.logger.Info("detach timeout elapsed without reattachment")
+		s.close()
+	})
+	s.mutex.Unlock()
+}
+
+// write buffers output from the tty and sends it to the attached connection,
+// if any
+func (s *session) write(data []byte) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	s.output.Write(data)
+	if s.connection == nil {
+		return nil
+	}
+	if msg, ok := lengthMessage(s.protocol, data); ok {
+		if err := s.sendLocked(msg); err != nil {
+			return err
+		}
+	}
+	if err := s.sendLocked(message{Type: MessageOutput, Data: data}); err != nil {
+		return err
+	}
+	s.sent = s.output.next()
+	s.flow.sent(len(data))
+	return nil
+}
+
+// send sends a control message to the attached connection, if any
+func (s *session) send(msg message) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.connection == nil {
+		return nil
+	}
+	return s.sendLocked(msg)
+}
+
+func (s *session) sendLocked(msg message) error {
+	messageType, data, ok := s.protocol.encode(s.frame[:0], msg)
+	if !ok {
+		return nil
+	}
+	s.frame = data
+	return s.compression.writeMessage(s.connection, messageType, data)
+}
+
+// resize sets the size of the session's tty
+func (s *session) resize(size TTYSize) error {
+	s.recorder.resize(size.Cols, size.Rows)
+	return pty.Setsize(s.tty, &pty.Winsize{
+		Rows: size.Rows,
+		Cols: size.Cols,
+	})
+}
+
+// notify writes message to the session's terminal as a highlighted banner
+func (s *session) notify(message string) error {
+	return s.write([]byte("\r\n\x1b[1;33m*** " + message + " ***\x1b[0m\r\n"))
+}
+
+// waitForExit waits up to timeout for the spawned process and every other
+// process of its session to exit, reporting whether they did
+func (s *session) waitForExit(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for {
+		select {
+		case <-s.exited:
+			if !processesRemain(s.cmd.Process) {
+				return true
+			}
+		default:
+		}
+		if !time.Now().Before(deadline) {
+			return false
+		}
+		time.Sleep(terminationPollInterval)
+	}
+}
+
+// terminate asks the spawned processes to exit by sending them each of the
+// terminationSignals in turn, waiting up to gracePeriod after each, and then
+// kills whatever is left
+func (s *session) terminate(gracePeriod time.Duration) {
+	for _, sig := range terminationSignals {
+		if s.waitForExit(0) {
+			return
+		}
+		s.logger.Infof("sending %s to spawned processes...", sig)
+		if err := signalProcesses(s.cmd.Process, sig); err != nil {
+			s.logger.Warnf("failed to send %s to spawned processes: %s", sig, err)
+		}
+		s.waitForExit(gracePeriod)
+	}
+	if s.waitForExit(0) {
+		return
+	}
+	s.logger.Info("killing spawned processes...")
+	if err := signalProcesses(s.cmd.Process, os.Kill); err != nil {
+		s.logger.Warnf("failed to kill spawned processes: %s", err)
+	}
+	if !s.waitForExit(gracePeriod) {
+		s.logger.Warn("spawned processes are still running after being killed")
+	}
+}
+
+// exitStatus describes how the spawned process exited, waiting for it to
+func (s *session) exitStatus() ExitStatus {
+	<-s.exited
+	if s.cmd.ProcessState == nil {
+		return ExitStatus{Code: -1, Description: "unknown"}
+	}
+	exitStatus := ExitStatus{
+		Code:        s.cmd.ProcessState.ExitCode(),
+		Description: s.cmd.ProcessState.String(),
+	}
+	if waitStatus, ok := s.cmd.ProcessState.Sys().(syscall.WaitStatus); ok && waitStatus.Signaled() {
+		exitStatus.Signal = waitStatus.Signal().String()
+	}
+	exitStatus.Limit = s.limits.exitLimit(s.cmd.ProcessState)
+	return exitStatus
+}
+
+// close stops the spawned processes, releases the tty and closes the attached
+// connection after telling it how the process exited. It is safe to call
+// close more than once
+func (s *session) close() {
+	s.closeOnce.Do(func() {
+		s.flow.close()
+		s.mutex.Lock()
+		if s.detachTimer != nil {
+			s.detachTimer.Stop()
+			s.detachTimer = nil
+		}
+		compression := s.compression
+		connection := s.connection
+		proto := s.protocol
+		s.connection = nil
+		s.mutex.Unlock()
+
+		s.logger.Info("gracefully stopping spawned tty...")
+		s.terminate(s.terminationGracePeriod)
+		exitStatus := s.exitStatus()
+		reapProcesses(s.cmd.Process)
+		s.logger.Infof("spawned process exited: %s", exitStatus.Description)
+		if exitStatus.Limit != "" {
+			s.logger.Warnf("spawned process reached its %s", exitStatus.Limit)
+		}
+		if err := s.limits.release(); err != nil {
+			s.logger.Warnf("failed to release resource limits: %s", err)
+		}
+		if err := s.tty.Close(); err != nil {
+			s.logger.Warnf("failed to close spawned tty gracefully: %s", err)
+		}
+		if err := s.recorder.close(); err != nil {
+			s.logger.Warnf("failed to close session recording: %s", err)
+		}
+		if connection != nil {
+			if err := writeMessage(connection, proto, compression, message{Type: MessageExit, Exit: exitStatus}); err != nil {
+				s.logger.Warnf("failed to send exit status to xterm.js: %s", err)
+			}
+			if err := closeConnection(connection, proto.closeCode(CloseProcessExited), exitStatus.Description); err != nil {
+				s.logger.Warnf("failed to close webscoket connection: %s", err)
+			}
+		}
+		if s.onClose != nil {
+			s.onClose(s)
+		}
+		close(s.done)
+	})
+}
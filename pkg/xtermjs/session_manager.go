@@ -0,0 +1,170 @@
+package xtermjs
+
+import (
+	"errors"
+	"sort"
+	"sync"
+	"time"
+)
+
+// ErrSessionNotFound is returned when a session ID does not match a live session
+var ErrSessionNotFound = errors.New("session not found")
+
+// SessionInfo describes a live session spawned by the xterm.js handler
+type SessionInfo struct {
+	// Account is the Unix account the session runs as, if not the handler's own
+	Account string `json:"account,omitempty"`
+	// Arguments are the arguments the command was started with
+	Arguments []string `json:"arguments"`
+	// Attached is true when a websocket connection is currently attached
+	Attached bool `json:"attached"`
+	// BytesIn is the number of bytes written to the tty by the frontend
+	BytesIn uint64 `json:"bytesIn"`
+	// BytesOut is the number of bytes read from the tty
+	BytesOut uint64 `json:"bytesOut"`
+	// Command is the path to the binary running in the tty
+	Command string `json:"command"`
+	// ID uniquely identifies the session
+	ID string `json:"id"`
+	// PID is the process ID of the command
+	PID int `json:"pid"`
+	// Profile is the name of the profile the session was started from, if any
+	Profile string `json:"profile,omitempty"`
+	// Recording is the file name of the session's recording, if any
+	Recording string `json:"recording,omitempty"`
+	// RemoteAddr is the address of the most recently attached client
+	RemoteAddr string `json:"remoteAddr"`
+	// StartTime is when the command was started
+	StartTime time.Time `json:"startTime"`
+	// User is the identity of the user who started the session, if known
+	User string `json:"user,omitempty"`
+}
+
+// SessionManager tracks the live sessions of one or more xterm.js handlers so
+// that they can be reattached, inspected and terminated
+type SessionManager struct {
+	mutex    sync.Mutex
+	draining bool
+	sessions map[string]*session
+}
+
+// NewSessionManager returns an empty SessionManager
+func NewSessionManager() *SessionManager {
+	return &SessionManager{
+		sessions: map[string]*session{},
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Internal methods
+// ----------------------------------------------------------------------------
+
+func (manager *SessionManager) add(s *session) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	manager.sessions[s.id] = s
+	s.onClose = manager.remove
+}
+
+func (manager *SessionManager) get(id string) *session {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.sessions[id]
+}
+
+func (manager *SessionManager) remove(s *session) {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	delete(manager.sessions, s.id)
+}
+
+func (manager *SessionManager) all() []*session {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	sessions := make([]*session, 0, len(manager.sessions))
+	for _, s := range manager.sessions {
+		sessions = append(sessions, s)
+	}
+	return sessions
+}
+
+// ----------------------------------------------------------------------------
+// Public methods
+// ----------------------------------------------------------------------------
+
+// List returns the details of every live session, oldest first
+func (manager *SessionManager) List() []SessionInfo {
+	sessions := manager.all()
+	result := make([]SessionInfo, 0, len(sessions))
+	for _, s := range sessions {
+		result = append(result, s.info())
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].StartTime.Before(result[j].StartTime)
+	})
+	return result
+}
+
+// Get returns the details of the session identified by id
+func (manager *SessionManager) Get(id string) (SessionInfo, error) {
+	s := manager.get(id)
+	if s == nil {
+		return SessionInfo{}, ErrSessionNotFound
+	}
+	return s.info(), nil
+}
+
+// Kill stops the processes of the session identified by id, escalating from
+// SIGHUP to SIGKILL, and closes any connection attached to it
+func (manager *SessionManager) Kill(id string) error {
+	s := manager.get(id)
+	if s == nil {
+		return ErrSessionNotFound
+	}
+	s.logger.Info("session killed by session manager")
+	s.close()
+	return nil
+}
+
+// Drain stops new sessions from being started and writes message to the
+// terminal of every live session. Live sessions can still be reattached
+func (manager *SessionManager) Drain(message string) {
+	manager.mutex.Lock()
+	manager.draining = true
+	manager.mutex.Unlock()
+	for _, s := range manager.all() {
+		if err := s.notify(message); err != nil {
+			s.logger.Warnf("failed to notify session: %s", err)
+		}
+	}
+}
+
+// Draining reports whether Drain has been called
+func (manager *SessionManager) Draining() bool {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return manager.draining
+}
+
+// Len returns the number of live sessions
+func (manager *SessionManager) Len() int {
+	manager.mutex.Lock()
+	defer manager.mutex.Unlock()
+	return len(manager.sessions)
+}
+
+// Shutdown closes every live session, sending their processes SIGHUP, then
+// SIGTERM, each followed by the handler's TerminationGracePeriod for them to
+// exit, before killing them. It returns once every session is closed
+func (manager *SessionManager) Shutdown() {
+	waitGroup := sync.WaitGroup{}
+	for _, s := range manager.all() {
+		waitGroup.Add(1)
+		go func(s *session) {
+			defer waitGroup.Done()
+			s.logger.Info("closing session for shutdown")
+			s.close()
+		}(s)
+	}
+	waitGroup.Wait()
+}
@@ -0,0 +1,55 @@
+//go:build !windows
+
+package xtermjs
+
+import (
+	"io"
+	"os/exec"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestSessionManager_Shutdown(test *testing.T) {
+	testCases := []struct {
+		name    string
+		script  string
+		minimum time.Duration
+	}{
+		{name: "exits on SIGHUP", script: "sleep 30", minimum: 0},
+		{name: "ignores SIGHUP", script: "trap '' HUP; sleep 30 & wait", minimum: 100 * time.Millisecond},
+		{name: "ignores SIGHUP and SIGTERM", script: "trap '' HUP TERM; sleep 30 & wait; sleep 30", minimum: 200 * time.Millisecond},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			cmd := exec.Command("/bin/sh", "-c", testCase.script)
+			tty, err := pty.Start(cmd)
+			if err != nil {
+				test.Skipf("failed to start tty: %s", err)
+			}
+			go io.Copy(io.Discard, tty)
+			manager := NewSessionManager()
+			manager.add(newSession("test", cmd, tty, 0, 100*time.Millisecond, 1024, defaultLogger))
+			time.Sleep(100 * time.Millisecond)
+
+			manager.Drain("shutting down")
+			if !manager.Draining() {
+				test.Error("expected manager to be draining")
+			}
+			start := time.Now()
+			manager.Shutdown()
+			elapsed := time.Since(start)
+			if manager.Len() != 0 {
+				test.Errorf("expected no live sessions, got %v", manager.Len())
+			}
+			if elapsed < testCase.minimum || elapsed > testCase.minimum+2*time.Second {
+				test.Errorf("expected shutdown to take about %v, took %v", testCase.minimum, elapsed)
+			}
+		})
+	}
+}
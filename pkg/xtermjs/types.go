@@ -1,6 +1,9 @@
 package xtermjs
 
-import "fmt"
+import (
+	"fmt"
+	"time"
+)
 
 // TTYSize represents a JSON structure to be sent by the frontend
 // xterm.js implementation to the xterm.js websocket handler
@@ -11,6 +14,123 @@ type TTYSize struct {
 	Y    uint16 `json:"y"`
 }
 
+// SessionMessage represents a JSON structure sent as a text message to the
+// frontend xterm.js implementation to identify the session it is attached to
+type SessionMessage struct {
+	Session string `json:"session"`
+}
+
+// Profile is a command that frontends can start by name using the "profile"
+// query parameter
+type Profile struct {
+	// Arguments are passed to Command
+	Arguments []string `json:"arguments,omitempty"`
+	// Command is the path to the binary to create a TTY for
+	Command string `json:"command"`
+	// Description tells users what the profile starts
+	Description string `json:"description,omitempty"`
+	// Env lists "NAME=value" entries added to the environment of Command
+	Env []string `json:"env,omitempty"`
+	// WorkingDirectory is the directory Command is started in, a template like
+	// HandlerOpts.WorkingDirectory. When not specified,
+	// HandlerOpts.WorkingDirectory is used
+	WorkingDirectory string `json:"workingDirectory,omitempty"`
+}
+
+// ResourceLimits are the limits applied to the processes of every session.
+// Limits that are zero are not applied. Only CpuTime, MemoryBytes and Pids
+// being reached is logged and reported in the terminal, as processes reaching
+// AddressSpaceBytes, OpenFiles or Processes are merely refused the memory,
+// file or process they asked for. Only Linux supports resource limits
+type ResourceLimits struct {
+	// AddressSpaceBytes limits the virtual memory of each process (RLIMIT_AS)
+	AddressSpaceBytes uint64
+	// CgroupParent is the cgroup v2 directory under which a cgroup is created
+	// for each session to apply CpuWeight, MemoryBytes and Pids. When not
+	// specified, DefaultCgroupParent is used. When cgroup v2 or its controllers
+	// are not available there, those limits are not applied
+	CgroupParent string
+	// CpuTime limits the CPU time of each process (RLIMIT_CPU), which is sent
+	// SIGXCPU when exceeding it
+	CpuTime time.Duration
+	// CpuWeight is the share of CPU time of each session, from 1 to 10000
+	// where 100 is the share of other processes (cpu.weight)
+	CpuWeight uint64
+	// MemoryBytes limits the memory used by each session, whose processes are
+	// killed when it is exhausted (memory.max)
+	MemoryBytes uint64
+	// OpenFiles limits the file descriptors of each process (RLIMIT_NOFILE)
+	OpenFiles uint64
+	// Pids limits the processes and threads of each session (pids.max)
+	Pids uint64
+	// Processes limits the processes of the user each session runs as
+	// (RLIMIT_NPROC), across sessions. Root is exempt from it, see
+	// HandlerOpts.RunAsUser
+	Processes uint64
+}
+
+// Sandbox isolates every session from the handler's host without a container
+// runtime. Sessions are started in new user, mount, PID, IPC and network
+// namespaces, as the user they would run as otherwise, with a read-only root
+// file system made of bind mounts of the handler's and a private writable
+// /tmp and home directory. The handler must be allowed to create user
+// namespaces, which most distributions allow unprivileged processes to.
+// Sessions are refused, with an error telling why, when it is not. The
+// handler's executable is started as the init process of each sandbox, which
+// this package takes over when it is initialized, so processes terminated by
+// a signal exit with 128 plus its number. Only Linux supports sandboxes
+type Sandbox struct {
+	// Enabled when true starts every session in a sandbox of its own
+	Enabled bool
+	// HomeDirectory is the path, in the sandbox, of the home directory that
+	// sessions start in. It is the session's WorkingDirectory, bind mounted
+	// writable, when one is specified, and an empty directory that is
+	// discarded with the session otherwise. When not specified, the home
+	// directory of the user the session runs as, see HandlerOpts.RunAsUser,
+	// or DefaultSandboxHomeDirectory is used
+	HomeDirectory string
+	// Network when true shares the handler's network with sessions rather than
+	// giving them a network of their own with only a loopback interface
+	Network bool
+	// ReadOnlyPaths are the absolute paths of the files and directories of the
+	// handler bind mounted read-only into sandboxes, which must include the
+	// command started. Symbolic links are copied and missing paths skipped.
+	// When not specified, DefaultSandboxReadOnlyPaths is used
+	ReadOnlyPaths []string
+}
+
+// ExitStatus describes how the process of a session exited
+type ExitStatus struct {
+	// Code is the exit code of the process, or -1 when it was terminated by a
+	// signal
+	Code int `json:"code"`
+	// Description summarises the exit status, for example "exit status 1" or
+	// "signal: hangup"
+	Description string `json:"description"`
+	// Limit describes the resource limit that got the process terminated, if
+	// known, for example "memory limit of 268435456 bytes"
+	Limit string `json:"limit,omitempty"`
+	// Signal is the name of the signal that terminated the process, if any
+	Signal string `json:"signal,omitempty"`
+}
+
+// ExitMessage represents a JSON structure sent as a text message to the
+// frontend xterm.js implementation when the process of its session exits,
+// just before the connection is closed with CloseProcessExited
+type ExitMessage struct {
+	Exit ExitStatus `json:"exit"`
+}
+
+// Close codes sent by the xterm.js websocket handler besides the standard
+// ones defined by RFC 6455
+const (
+	// CloseProcessExited closes connections to a session whose process exited
+	CloseProcessExited = 4000
+	// CloseSessionSuperseded closes connections to a session which another
+	// connection has attached to
+	CloseSessionSuperseded = 4001
+)
+
 // Logger is the logging interface used by the xterm.js handler
 type Logger interface {
 	Trace(...interface{})
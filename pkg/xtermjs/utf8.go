@@ -0,0 +1,53 @@
+package xtermjs
+
+import "unicode/utf8"
+
+// utf8Replacement replaces invalid UTF-8 in output sent in text frames
+const utf8Replacement = "\uFFFD"
+
+// splitIncompleteUTF8 splits p into its longest prefix that does not end in
+// an incomplete UTF-8 sequence and the remaining bytes
+func splitIncompleteUTF8(p []byte) ([]byte, []byte) {
+	for i := 1; i < utf8.UTFMax && i <= len(p); i++ {
+		c := p[len(p)-i]
+		if c < utf8.RuneSelf {
+			break
+		}
+		if utf8.RuneStart(c) {
+			if !utf8.FullRune(p[len(p)-i:]) {
+				return p[:len(p)-i], p[len(p)-i:]
+			}
+			break
+		}
+	}
+	return p, nil
+}
+
+// truncateUTF8 returns the longest prefix of s of at most n bytes that does
+// not end in the middle of a UTF-8 sequence
+func truncateUTF8(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	for n > 0 && !utf8.RuneStart(s[n]) {
+		n--
+	}
+	return s[:n]
+}
+
+// appendValidUTF8 appends p to dst, replacing each invalid byte with U+FFFD
+func appendValidUTF8(dst []byte, p []byte) []byte {
+	if utf8.Valid(p) {
+		return append(dst, p...)
+	}
+	for len(p) > 0 {
+		r, size := utf8.DecodeRune(p)
+		if r == utf8.RuneError && size == 1 {
+			dst = append(dst, utf8Replacement...)
+		} else {
+			dst = append(dst, p[:size]...)
+		}
+		p = p[size:]
+	}
+	return dst
+}
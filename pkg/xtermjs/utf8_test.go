@@ -0,0 +1,38 @@
+package xtermjs
+
+import (
+	"strings"
+	"testing"
+	"unicode/utf8"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestTruncateUTF8(test *testing.T) {
+	testCases := []struct {
+		s        string
+		n        int
+		expected string
+	}{
+		{s: "exit status 1", n: 123, expected: "exit status 1"},
+		{s: "abc", n: 3, expected: "abc"},
+		{s: "abc", n: 2, expected: "ab"},
+		{s: "aé", n: 2, expected: "a"},
+		{s: "aé", n: 3, expected: "aé"},
+		{s: "a€", n: 3, expected: "a"},
+		{s: "😀😀", n: 7, expected: "😀"},
+		{s: "é", n: 0, expected: ""},
+		{s: strings.Repeat("x", 122) + "é", n: maxCloseReasonBytes, expected: strings.Repeat("x", 122)},
+	}
+	for _, testCase := range testCases {
+		actual := truncateUTF8(testCase.s, testCase.n)
+		if actual != testCase.expected {
+			test.Errorf("truncateUTF8(%q, %d): expected %q, got %q", testCase.s, testCase.n, testCase.expected, actual)
+		}
+		if !utf8.ValidString(actual) {
+			test.Errorf("truncateUTF8(%q, %d): got invalid UTF-8 %q", testCase.s, testCase.n, actual)
+		}
+	}
+}
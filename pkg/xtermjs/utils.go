@@ -3,31 +3,87 @@ package xtermjs
 import (
 	"net/http"
 	"strings"
+	"time"
 
 	"github.com/gorilla/websocket"
 )
 
+const (
+	closeFrameTimeout = time.Second
+	// maxCloseReasonBytes is what remains of a control frame's 125 bytes after
+	// the close code
+	maxCloseReasonBytes = 123
+	// maxFrameTypeBytes leaves room for the message type prefixed by protocols
+	maxFrameTypeBytes = 1
+)
+
 func getConnectionUpgrader(
 	allowedHostnames []string,
+	allowedOrigins []originPattern,
+	subprotocols []string,
+	enableCompression bool,
 	maxBufferSizeBytes int,
+	maxFrameSizeBytes int,
+	writeBufferPool websocket.BufferPool,
 	logger Logger,
 ) websocket.Upgrader {
 	return websocket.Upgrader{
 		CheckOrigin: func(r *http.Request) bool {
-			requesterHostname := r.Host
-			if strings.Contains(requesterHostname, ":") {
-				requesterHostname = strings.Split(requesterHostname, ":")[0]
+			if len(allowedHostnames) > 0 {
+				requesterHostname := r.Host
+				if strings.Contains(requesterHostname, ":") {
+					requesterHostname = strings.Split(requesterHostname, ":")[0]
+				}
+				allowed := false
+				for _, allowedHostname := range allowedHostnames {
+					if requesterHostname == allowedHostname {
+						allowed = true
+					}
+				}
+				if !allowed {
+					logger.Warnf("failed to find '%s' in the list of allowed hostnames ('%s')", requesterHostname, strings.Join(allowedHostnames, "', '"))
+					return false
+				}
 			}
-			for _, allowedHostname := range allowedHostnames {
-				if requesterHostname == allowedHostname {
-					return true
+			origin := r.Header.Get("Origin")
+			allowed, err := checkOrigin(allowedOrigins, origin, r.Host)
+			if err != nil {
+				logger.Warnf("rejected malformed origin '%s': %s", origin, err)
+				return false
+			}
+			if !allowed {
+				if len(allowedOrigins) == 0 {
+					logger.Warnf("rejected cross-origin request from '%s' to '%s'; no allowed origins are configured", origin, r.Host)
+				} else {
+					logger.Warnf("failed to find '%s' in the list of allowed origins", origin)
 				}
+				return false
 			}
-			logger.Warnf("failed to find '%s' in the list of allowed hostnames ('%s')", requesterHostname)
-			return false
+			return true
 		},
-		HandshakeTimeout: 0,
-		ReadBufferSize:   maxBufferSizeBytes,
-		WriteBufferSize:  maxBufferSizeBytes,
+		EnableCompression: enableCompression,
+		HandshakeTimeout:  0,
+		ReadBufferSize:    maxBufferSizeBytes,
+		Subprotocols:      subprotocols,
+		// frames are copied into the write buffer and written with a single
+		// write when they fit; buffers are only held while writing
+		WriteBufferPool: writeBufferPool,
+		WriteBufferSize: maxFrameSizeBytes + maxFrameTypeBytes,
 	}
 }
+
+// closeConnection sends a close frame with code and reason before closing
+// connection. The reason is made valid UTF-8, as browsers fail connections
+// closed otherwise, and truncated to fit in a control frame
+func closeConnection(connection *websocket.Conn, code int, reason string) error {
+	reason = truncateUTF8(strings.ToValidUTF8(reason, utf8Replacement), maxCloseReasonBytes)
+	connection.WriteControl(websocket.CloseMessage, websocket.FormatCloseMessage(code, reason), time.Now().Add(closeFrameTimeout))
+	return connection.Close()
+}
+
+// refuseConnection tells the frontend why its request failed, when proto can
+// express it, before closing connection with code
+func refuseConnection(connection *websocket.Conn, proto protocol, compression compressionPolicy, code int, reason string) error {
+	writeMessage(connection, proto, compression, message{Type: MessageError, Data: []byte(reason)})
+	return closeConnection(connection, proto.closeCode(code), reason)
+}
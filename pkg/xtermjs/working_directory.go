@@ -0,0 +1,185 @@
+package xtermjs
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"text/template"
+)
+
+// workingDirectoryMode is the mode of working directories created for
+// sessions, which may be the home of a user
+const workingDirectoryMode = 0o700
+
+// emptyValue stands in for empty template values until the path elements
+// made of nothing else are found
+const emptyValue = "\x00"
+
+// pathElementReplacer keeps template values from adding path elements to
+// working directories
+var pathElementReplacer = strings.NewReplacer("/", "_", `\`, "_", emptyValue, "_")
+
+// pathElement returns value made safe to use as a single path element
+func pathElement(value string) string {
+	switch value {
+	case "":
+		return emptyValue
+	case ".", "..":
+		return "_"
+	}
+	return pathElementReplacer.Replace(value)
+}
+
+// expandWorkingDirectory returns the working directory template executed with
+// data, whose values are made safe to use as path elements so that
+// "/home/{{.User}}" stays within /home whatever the user is called. Path
+// elements that only empty values make up, as "/home/{{.User}}" has without
+// a user, are refused rather than dropped
+func expandWorkingDirectory(directory string, data sessionTemplateData) (string, error) {
+	parsed, err := template.New("workingDirectory").Parse(directory)
+	if err != nil {
+		return "", fmt.Errorf("invalid working directory '%s': %w", directory, err)
+	}
+	safe := sessionTemplateData{
+		Account:    pathElement(data.Account),
+		Profile:    pathElement(data.Profile),
+		RemoteAddr: pathElement(data.RemoteAddr),
+		SessionID:  pathElement(data.SessionID),
+		User:       pathElement(data.User),
+	}
+	var expanded bytes.Buffer
+	if err := parsed.Execute(&expanded, safe); err != nil {
+		return "", fmt.Errorf("failed to expand working directory '%s': %w", directory, err)
+	}
+	elements := strings.Split(expanded.String(), "/")
+	for i, element := range elements {
+		if element != "" && strings.Trim(element, emptyValue) == "" {
+			return "", fmt.Errorf("working directory '%s' has an empty path element for this session", directory)
+		}
+		elements[i] = strings.ReplaceAll(element, emptyValue, "")
+	}
+	return strings.Join(elements, "/"), nil
+}
+
+// workingDirectoryMutex keeps sessions from building the same working
+// directory at once
+var workingDirectoryMutex sync.Mutex
+
+// createWorkingDirectory creates directory unless it exists, copying the
+// contents of skeleton into it when specified. When runAs is specified, what
+// is created is owned by runAs. The directory is built under a temporary name
+// beside it and renamed into place once complete, so that a failure leaves
+// nothing behind for later sessions to take as created
+func createWorkingDirectory(directory string, skeleton string, runAs *account) error {
+	workingDirectoryMutex.Lock()
+	defer workingDirectoryMutex.Unlock()
+	info, err := os.Lstat(directory)
+	if err == nil {
+		if !info.IsDir() {
+			return fmt.Errorf("working directory '%s' is not a directory", directory)
+		}
+		return nil
+	}
+	if !errors.Is(err, fs.ErrNotExist) {
+		return err
+	}
+	parent := filepath.Dir(directory)
+	if err := os.MkdirAll(parent, 0o755); err != nil {
+		return err
+	}
+	temporary, err := os.MkdirTemp(parent, "."+filepath.Base(directory)+".*")
+	if err != nil {
+		return err
+	}
+	err = buildWorkingDirectory(temporary, skeleton, runAs)
+	if err == nil {
+		err = os.Rename(temporary, directory)
+	}
+	if err != nil {
+		os.RemoveAll(temporary)
+	}
+	return err
+}
+
+// buildWorkingDirectory gives the new directory its mode and owner and copies
+// the contents of skeleton, when specified, into it
+func buildWorkingDirectory(directory string, skeleton string, runAs *account) error {
+	if err := os.Chmod(directory, workingDirectoryMode); err != nil {
+		return err
+	}
+	if err := chownToAccount(directory, runAs); err != nil {
+		return err
+	}
+	if skeleton == "" {
+		return nil
+	}
+	return copySkeleton(skeleton, directory, runAs)
+}
+
+// copySkeleton copies the directories, regular files and symbolic links in
+// skeleton into directory, keeping their permissions
+func copySkeleton(skeleton string, directory string, runAs *account) error {
+	return filepath.WalkDir(skeleton, func(source string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		relative, err := filepath.Rel(skeleton, source)
+		if err != nil || relative == "." {
+			return err
+		}
+		target := filepath.Join(directory, relative)
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+		switch {
+		case entry.IsDir():
+			err = os.Mkdir(target, info.Mode().Perm())
+		case entry.Type()&fs.ModeSymlink != 0:
+			var link string
+			if link, err = os.Readlink(source); err == nil {
+				err = os.Symlink(link, target)
+			}
+		case entry.Type().IsRegular():
+			err = copyFile(source, target, info.Mode().Perm())
+		default:
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		return chownToAccount(target, runAs)
+	})
+}
+
+// copyFile copies the regular file source to a new file target
+func copyFile(source string, target string, mode fs.FileMode) error {
+	in, err := os.Open(source)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	out, err := os.OpenFile(target, os.O_WRONLY|os.O_CREATE|os.O_EXCL, mode)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, in); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// chownToAccount makes runAs, when specified, the owner of name
+func chownToAccount(name string, runAs *account) error {
+	if runAs == nil {
+		return nil
+	}
+	return os.Lchown(name, int(runAs.uid), int(runAs.gid))
+}
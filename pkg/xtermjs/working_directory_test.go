@@ -0,0 +1,127 @@
+package xtermjs
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// ----------------------------------------------------------------------------
+// Test interface functions
+// ----------------------------------------------------------------------------
+
+func TestExpandWorkingDirectory(test *testing.T) {
+	testCases := []struct {
+		name      string
+		directory string
+		data      sessionTemplateData
+		expected  string
+		valid     bool
+	}{
+		{name: "static", directory: "/srv/shell", expected: "/srv/shell", valid: true},
+		{name: "user", directory: "/home/{{.User}}", data: sessionTemplateData{User: "alice"}, expected: "/home/alice", valid: true},
+		{name: "session", directory: "/tmp/{{.Account}}/{{.SessionID}}", data: sessionTemplateData{Account: "alice", SessionID: "42"}, expected: "/tmp/alice/42", valid: true},
+		{name: "separators", directory: "/home/{{.User}}", data: sessionTemplateData{User: "../etc/x\\y"}, expected: "/home/.._etc_x_y", valid: true},
+		{name: "parent", directory: "/home/{{.User}}", data: sessionTemplateData{User: ".."}, expected: "/home/_", valid: true},
+		{name: "no user", directory: "/home/{{.User}}", valid: false},
+		{name: "no user or profile", directory: "/home/{{.User}}{{.Profile}}/work", valid: false},
+		{name: "no profile", directory: "/home/{{.User}}/work{{.Profile}}", data: sessionTemplateData{User: "alice"}, expected: "/home/alice/work", valid: true},
+		{name: "null byte", directory: "/home/{{.User}}", data: sessionTemplateData{User: "a\x00"}, expected: "/home/a_", valid: true},
+		{name: "invalid", directory: "/home/{{.User", valid: false},
+		{name: "unknown field", directory: "/home/{{.Host}}", valid: false},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			actual, err := expandWorkingDirectory(testCase.directory, testCase.data)
+			if (err == nil) != testCase.valid {
+				test.Fatalf("expected valid=%v, got error %v", testCase.valid, err)
+			}
+			if actual != testCase.expected {
+				test.Errorf("expected %q, got %q", testCase.expected, actual)
+			}
+		})
+	}
+}
+
+func TestCreateWorkingDirectory(test *testing.T) {
+	root := test.TempDir()
+	skeleton := filepath.Join(root, "skel")
+	if err := os.MkdirAll(filepath.Join(skeleton, ".config"), 0o755); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(skeleton, ".profile"), []byte("umask 022\n"), 0o640); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(skeleton, ".config", "settings"), []byte("x"), 0o600); err != nil {
+		test.Fatal(err)
+	}
+	if err := os.Symlink(".profile", filepath.Join(skeleton, ".bashrc")); err != nil {
+		test.Fatal(err)
+	}
+
+	directory := filepath.Join(root, "home", "alice")
+	if err := createWorkingDirectory(directory, skeleton, nil); err != nil {
+		test.Fatalf("failed to create %s: %s", directory, err)
+	}
+	if info, err := os.Stat(directory); err != nil || info.Mode().Perm() != workingDirectoryMode {
+		test.Errorf("expected directory with mode %v, got %v, %v", os.FileMode(workingDirectoryMode), info, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(directory, ".profile")); err != nil || string(data) != "umask 022\n" {
+		test.Errorf("expected .profile to be copied, got %q, %v", data, err)
+	}
+	if info, err := os.Stat(filepath.Join(directory, ".profile")); err != nil || info.Mode().Perm() != 0o640 {
+		test.Errorf("expected .profile with mode 0640, got %v, %v", info, err)
+	}
+	if data, err := os.ReadFile(filepath.Join(directory, ".config", "settings")); err != nil || string(data) != "x" {
+		test.Errorf("expected .config/settings to be copied, got %q, %v", data, err)
+	}
+	if link, err := os.Readlink(filepath.Join(directory, ".bashrc")); err != nil || link != ".profile" {
+		test.Errorf("expected .bashrc to link to .profile, got %q, %v", link, err)
+	}
+
+	// An existing directory is left as it is.
+	if err := os.WriteFile(filepath.Join(directory, ".profile"), []byte("changed"), 0o640); err != nil {
+		test.Fatal(err)
+	}
+	if err := createWorkingDirectory(directory, skeleton, nil); err != nil {
+		test.Fatalf("failed to reuse %s: %s", directory, err)
+	}
+	if data, _ := os.ReadFile(filepath.Join(directory, ".profile")); string(data) != "changed" {
+		test.Errorf("expected .profile to be kept, got %q", data)
+	}
+
+	// A failure leaves nothing behind, so that the next session tries again.
+	broken := filepath.Join(root, "broken")
+	if err := createWorkingDirectory(filepath.Join(broken, "carol"), filepath.Join(root, "no such skeleton"), nil); err == nil {
+		test.Errorf("expected a missing skeleton to fail")
+	}
+	if entries, err := os.ReadDir(broken); err != nil || len(entries) != 0 {
+		test.Errorf("expected %s to be left empty, got %v, %v", broken, entries, err)
+	}
+	if _, err := os.Lstat(filepath.Join(broken, "carol")); !errors.Is(err, fs.ErrNotExist) {
+		test.Errorf("expected carol not to exist, got %v", err)
+	}
+
+	// A path that exists but is not a directory is refused.
+	target := filepath.Join(root, "target")
+	if err := os.Mkdir(target, 0o700); err != nil {
+		test.Fatal(err)
+	}
+	for _, name := range []string{"link", "file"} {
+		directory := filepath.Join(root, "home", name)
+		var err error
+		if name == "link" {
+			err = os.Symlink(target, directory)
+		} else {
+			err = os.WriteFile(directory, nil, 0o600)
+		}
+		if err != nil {
+			test.Fatal(err)
+		}
+		if err := createWorkingDirectory(directory, "", nil); err == nil {
+			test.Errorf("expected %s to be refused", name)
+		}
+	}
+}
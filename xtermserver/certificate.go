@@ -0,0 +1,197 @@
+package xtermserver
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/docktermj/cloudshell/internal/log"
+	"github.com/fsnotify/fsnotify"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// certificateReloader serves a certificate and key pair from files, reloading
+// them when they change so that rotated certificates are picked up without
+// restarting the server and dropping its terminal sessions.
+type certificateReloader struct {
+	certFile    string
+	keyFile     string
+	mutex       sync.RWMutex
+	certificate *tls.Certificate
+}
+
+// ----------------------------------------------------------------------------
+// Constants
+// ----------------------------------------------------------------------------
+
+const (
+	// certificateReloadDelay lets tools rewriting both files finish before
+	// they are read.
+	certificateReloadDelay   = 500 * time.Millisecond
+	selfSignedCertificateTtl = 365 * 24 * time.Hour
+)
+
+// ----------------------------------------------------------------------------
+// Constructors
+// ----------------------------------------------------------------------------
+
+func newCertificateReloader(certFile string, keyFile string) (*certificateReloader, error) {
+	reloader := &certificateReloader{
+		certFile: certFile,
+		keyFile:  keyFile,
+	}
+	if err := reloader.reload(); err != nil {
+		return nil, err
+	}
+	return reloader, nil
+}
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// generateSelfSignedCertificate returns a certificate for development use,
+// valid for hostnames, "localhost" and the loopback addresses.
+func generateSelfSignedCertificate(hostnames []string) (tls.Certificate, error) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	serialNumber, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	notBefore := time.Now().Add(-time.Hour)
+	template := &x509.Certificate{
+		BasicConstraintsValid: true,
+		DNSNames:              []string{"localhost"},
+		ExtKeyUsage:           []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		IPAddresses:           []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback},
+		KeyUsage:              x509.KeyUsageDigitalSignature,
+		NotAfter:              notBefore.Add(selfSignedCertificateTtl),
+		NotBefore:             notBefore,
+		SerialNumber:          serialNumber,
+		Subject:               pkix.Name{CommonName: "cloudshell self-signed", Organization: []string{"cloudshell"}},
+	}
+	for _, hostname := range hostnames {
+		if ip := net.ParseIP(hostname); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else if hostname != "" && hostname != "localhost" {
+			template.DNSNames = append(template.DNSNames, hostname)
+		}
+	}
+	certificateDer, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		return tls.Certificate{}, err
+	}
+	return tls.Certificate{
+		Certificate: [][]byte{certificateDer},
+		PrivateKey:  key,
+	}, nil
+}
+
+// loadCertificatePool returns a pool of the PEM certificates in filename.
+func loadCertificatePool(filename string) (*x509.CertPool, error) {
+	pemBytes, err := os.ReadFile(filename)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read TLS client CA file: %w", err)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(pemBytes) {
+		return nil, fmt.Errorf("no certificates found in TLS client CA file '%s'", filename)
+	}
+	return pool, nil
+}
+
+// ----------------------------------------------------------------------------
+// Internal methods
+// ----------------------------------------------------------------------------
+
+// reload reads the certificate and key pair, keeping the current one when
+// the files cannot be read or do not match.
+func (reloader *certificateReloader) reload() error {
+	certificate, err := tls.LoadX509KeyPair(reloader.certFile, reloader.keyFile)
+	if err != nil {
+		return fmt.Errorf("failed to load TLS certificate: %w", err)
+	}
+	if len(certificate.Certificate) > 0 {
+		certificate.Leaf, _ = x509.ParseCertificate(certificate.Certificate[0])
+	}
+	reloader.mutex.Lock()
+	reloader.certificate = &certificate
+	reloader.mutex.Unlock()
+	return nil
+}
+
+// getCertificate is used as tls.Config.GetCertificate.
+func (reloader *certificateReloader) getCertificate(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	reloader.mutex.RLock()
+	defer reloader.mutex.RUnlock()
+	return reloader.certificate, nil
+}
+
+// watch reloads the certificate whenever the directories holding the files
+// change, until ctx is done. Directories rather than files are watched so
+// that files replaced by renaming, or symlinks swapped as with Kubernetes
+// secrets, are followed.
+func (reloader *certificateReloader) watch(ctx context.Context) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	directories := map[string]bool{
+		filepath.Dir(reloader.certFile): true,
+		filepath.Dir(reloader.keyFile):  true,
+	}
+	for directory := range directories {
+		if err := watcher.Add(directory); err != nil {
+			watcher.Close()
+			return err
+		}
+	}
+
+	go func() {
+		defer watcher.Close()
+		var timer <-chan time.Time
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if event.Op != fsnotify.Chmod {
+					timer = time.After(certificateReloadDelay)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Warnf("failed to watch TLS certificate: %s", err)
+			case <-timer:
+				timer = nil
+				if err := reloader.reload(); err != nil {
+					log.Warnf("%s; keeping the current certificate", err)
+					continue
+				}
+				log.Infof("reloaded TLS certificate from '%s'", reloader.certFile)
+			}
+		}
+	}()
+	return nil
+}
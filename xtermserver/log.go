@@ -2,6 +2,7 @@ package xtermserver
 
 import (
 	"net/http"
+	"net/url"
 
 	"github.com/docktermj/cloudshell/internal/log"
 )
@@ -18,13 +19,46 @@ func createRequestLog(r *http.Request, additionalFields ...map[string]interface{
 		fields["method"] = r.Method
 		fields["protocol"] = r.Proto
 		fields["path"] = r.URL.Path
-		fields["request_url"] = r.URL.String()
+		fields["request_url"] = redactedUrl(r.URL)
 		fields["user_agent"] = r.UserAgent()
-		fields["cookies"] = r.Cookies()
+		fields["cookies"] = cookieNames(r)
 	}
 	return log.WithFields(fields)
 }
 
+// credentialQueryParameters are the query parameters carrying credentials,
+// such as the bearer tokens of browsers and OpenID Connect authorization
+// codes, whose values are not logged
+var credentialQueryParameters = []string{"access_token", "code", "id_token"}
+
+// redactedUrl returns u with the values of credentialQueryParameters redacted
+func redactedUrl(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, name := range credentialQueryParameters {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	result := *u
+	result.RawQuery = query.Encode()
+	return result.String()
+}
+
+// cookieNames returns the names of the cookies of r, leaving out their
+// values, which include signed sessions and identity tokens
+func cookieNames(r *http.Request) []string {
+	names := []string{}
+	for _, cookie := range r.Cookies() {
+		names = append(names, cookie.Name)
+	}
+	return names
+}
+
 // func createMemoryLog() log.Logger {
 // 	var memStats runtime.MemStats
 // 	runtime.ReadMemStats(&memStats)
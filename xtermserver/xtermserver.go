@@ -2,9 +2,14 @@ package xtermserver
 
 import (
 	"context"
+	"crypto/tls"
+	"errors"
 	"fmt"
 	"net/http"
+	"time"
 
+	"github.com/docktermj/cloudshell/internal/log"
+	"github.com/docktermj/cloudshell/pkg/xtermjs"
 	"github.com/docktermj/cloudshell/xtermservice"
 )
 
@@ -14,16 +19,89 @@ import (
 
 // XtermServerImpl is the default implementation of the HttpServer interface.
 type XtermServerImpl struct {
-	AllowedHostnames     []string
-	Arguments            []string
-	Command              string
-	ConnectionErrorLimit int
-	HtmlTitle            string
-	KeepalivePingTimeout int
-	MaxBufferSizeBytes   int
-	ServerAddress        string
-	ServerPort           int
-	UrlRoutePrefix       string
+	Administrators                []string
+	AllowedHostnames              []string
+	AllowedOrigins                []string
+	Authenticator                 xtermservice.Authenticator
+	Arguments                     []string
+	Command                       string
+	CompressionLevel              int
+	CompressionThresholdBytes     int
+	ConnectionErrorLimit          int
+	EnableCompression             bool
+	Env                           []string
+	EnvAllowlist                  []string
+	EnvDenylist                   []string
+	FlowControlHighWatermarkBytes int
+	FlowControlLowWatermarkBytes  int
+	HtmlTitle                     string
+	KeepalivePingTimeout          int
+	MaxBufferSizeBytes            int
+	MaxOutputFrameSizeBytes       int
+	OutputLatencyWindow           int
+	OutputTextFrames              bool
+	Profiles                      map[string]xtermjs.Profile
+	RecordInput                   bool
+	RecordingDirectory            string
+	ResourceLimits                xtermjs.ResourceLimits
+	RunAsUser                     string
+	Sandbox                       xtermjs.Sandbox
+	ServerAddress                 string
+	ServerPort                    int
+	ServerShutdownDrainPeriod     int
+	ServerTlsCertFile             string
+	ServerTlsClientCaFile         string
+	ServerTlsKeyFile              string
+	ServerTlsSelfSigned           bool
+	SessionDetachTimeout          int
+	SessionReplayBufferSizeBytes  int
+	Subreaper                     bool
+	TerminationGracePeriod        int
+	TtydProtocol                  bool
+	UrlRoutePrefix                string
+	UserAccounts                  map[string]string
+	UserCommands                  map[string][]string
+	WorkingDirectory              string
+	WorkingDirectorySkeleton      string
+}
+
+// ----------------------------------------------------------------------------
+// Constants
+// ----------------------------------------------------------------------------
+
+const (
+	shutdownPollInterval = 250 * time.Millisecond
+	shutdownTimeout      = 5 * time.Second
+)
+
+// ----------------------------------------------------------------------------
+// Internal methods
+// ----------------------------------------------------------------------------
+
+// shutdown refuses new sessions and warns the users of live sessions, gives
+// them the drain period to finish, then terminates whatever is still running
+// and stops the server.
+func (xtermServer *XtermServerImpl) shutdown(server *http.Server, sessionManager *xtermjs.SessionManager) error {
+	drainPeriod := time.Duration(xtermServer.ServerShutdownDrainPeriod) * time.Second
+
+	if drainPeriod > 0 && sessionManager.Len() > 0 {
+		log.Infof("shutting down; draining %v sessions for %v...", sessionManager.Len(), drainPeriod)
+		sessionManager.Drain(fmt.Sprintf("This server is shutting down in %v. Save your work.", drainPeriod))
+		deadline := time.Now().Add(drainPeriod)
+		for sessionManager.Len() > 0 && time.Now().Before(deadline) {
+			time.Sleep(shutdownPollInterval)
+		}
+	}
+	sessionManager.Drain("This server is shutting down now.")
+	if sessionManager.Len() > 0 {
+		log.Infof("terminating %v remaining sessions...", sessionManager.Len())
+		sessionManager.Shutdown()
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+	defer cancel()
+	log.Info("stopping server...")
+	return server.Shutdown(ctx)
 }
 
 // ----------------------------------------------------------------------------
@@ -40,17 +118,59 @@ Input
 func (xtermServer *XtermServerImpl) Serve(ctx context.Context) error {
 	rootMux := http.NewServeMux()
 
+	// Identify users by their client certificates when they are required.
+
+	authenticator := xtermServer.Authenticator
+	if len(xtermServer.ServerTlsClientCaFile) > 0 {
+		authenticators := xtermservice.Authenticators{&xtermservice.ClientCertificateAuthenticator{}}
+		if authenticator != nil {
+			authenticators = append(authenticators, authenticator)
+		}
+		authenticator = authenticators
+	}
+
 	// Add XtermService.
 
+	sessionManager := xtermjs.NewSessionManager()
 	xtermService := &xtermservice.XtermServiceImpl{
-		AllowedHostnames:     xtermServer.AllowedHostnames,
-		Arguments:            xtermServer.Arguments,
-		Command:              xtermServer.Command,
-		ConnectionErrorLimit: xtermServer.ConnectionErrorLimit,
-		HtmlTitle:            xtermServer.HtmlTitle,
-		KeepalivePingTimeout: xtermServer.KeepalivePingTimeout,
-		MaxBufferSizeBytes:   xtermServer.MaxBufferSizeBytes,
-		UrlRoutePrefix:       xtermServer.UrlRoutePrefix,
+		Administrators:                xtermServer.Administrators,
+		AllowedHostnames:              xtermServer.AllowedHostnames,
+		AllowedOrigins:                xtermServer.AllowedOrigins,
+		Authenticator:                 authenticator,
+		Arguments:                     xtermServer.Arguments,
+		Command:                       xtermServer.Command,
+		CompressionLevel:              xtermServer.CompressionLevel,
+		CompressionThresholdBytes:     xtermServer.CompressionThresholdBytes,
+		ConnectionErrorLimit:          xtermServer.ConnectionErrorLimit,
+		EnableCompression:             xtermServer.EnableCompression,
+		Env:                           xtermServer.Env,
+		EnvAllowlist:                  xtermServer.EnvAllowlist,
+		EnvDenylist:                   xtermServer.EnvDenylist,
+		FlowControlHighWatermarkBytes: xtermServer.FlowControlHighWatermarkBytes,
+		FlowControlLowWatermarkBytes:  xtermServer.FlowControlLowWatermarkBytes,
+		HtmlTitle:                     xtermServer.HtmlTitle,
+		KeepalivePingTimeout:          xtermServer.KeepalivePingTimeout,
+		MaxBufferSizeBytes:            xtermServer.MaxBufferSizeBytes,
+		MaxOutputFrameSizeBytes:       xtermServer.MaxOutputFrameSizeBytes,
+		OutputLatencyWindow:           xtermServer.OutputLatencyWindow,
+		OutputTextFrames:              xtermServer.OutputTextFrames,
+		Profiles:                      xtermServer.Profiles,
+		RecordInput:                   xtermServer.RecordInput,
+		RecordingDirectory:            xtermServer.RecordingDirectory,
+		ResourceLimits:                xtermServer.ResourceLimits,
+		RunAsUser:                     xtermServer.RunAsUser,
+		Sandbox:                       xtermServer.Sandbox,
+		SessionDetachTimeout:          xtermServer.SessionDetachTimeout,
+		SessionManager:                sessionManager,
+		SessionReplayBufferSizeBytes:  xtermServer.SessionReplayBufferSizeBytes,
+		Subreaper:                     xtermServer.Subreaper,
+		TerminationGracePeriod:        xtermServer.TerminationGracePeriod,
+		TtydProtocol:                  xtermServer.TtydProtocol,
+		UrlRoutePrefix:                xtermServer.UrlRoutePrefix,
+		UserAccounts:                  xtermServer.UserAccounts,
+		UserCommands:                  xtermServer.UserCommands,
+		WorkingDirectory:              xtermServer.WorkingDirectory,
+		WorkingDirectorySkeleton:      xtermServer.WorkingDirectorySkeleton,
 	}
 	xtermMux := xtermService.Handler(ctx)
 	rootMux.Handle("/", xtermMux)
@@ -62,6 +182,66 @@ func (xtermServer *XtermServerImpl) Serve(ctx context.Context) error {
 		Addr:    listenOnAddress,
 		Handler: addIncomingRequestLogging(rootMux),
 	}
-	fmt.Printf("starting server on interface:port '%s'...", listenOnAddress)
-	return server.ListenAndServe()
+
+	// Serve HTTPS when a certificate is configured.
+
+	switch {
+	case len(xtermServer.ServerTlsCertFile) > 0 || len(xtermServer.ServerTlsKeyFile) > 0:
+		if len(xtermServer.ServerTlsCertFile) == 0 || len(xtermServer.ServerTlsKeyFile) == 0 {
+			return errors.New("both a TLS certificate file and a TLS key file are required")
+		}
+		reloader, err := newCertificateReloader(xtermServer.ServerTlsCertFile, xtermServer.ServerTlsKeyFile)
+		if err != nil {
+			return err
+		}
+		if err := reloader.watch(ctx); err != nil {
+			return fmt.Errorf("failed to watch TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{
+			GetCertificate: reloader.getCertificate,
+			MinVersion:     tls.VersionTLS12,
+		}
+	case xtermServer.ServerTlsSelfSigned:
+		certificate, err := generateSelfSignedCertificate(xtermServer.AllowedHostnames)
+		if err != nil {
+			return fmt.Errorf("failed to generate self-signed TLS certificate: %w", err)
+		}
+		server.TLSConfig = &tls.Config{
+			Certificates: []tls.Certificate{certificate},
+			MinVersion:   tls.VersionTLS12,
+		}
+	}
+
+	// Require client certificates signed by the client CA.
+
+	if len(xtermServer.ServerTlsClientCaFile) > 0 {
+		if server.TLSConfig == nil {
+			return errors.New("a TLS client CA requires a TLS certificate or a self-signed certificate")
+		}
+		clientCaPool, err := loadCertificatePool(xtermServer.ServerTlsClientCaFile)
+		if err != nil {
+			return err
+		}
+		server.TLSConfig.ClientAuth = tls.RequireAndVerifyClientCert
+		server.TLSConfig.ClientCAs = clientCaPool
+	}
+
+	// Serve until ctx is done, then shut down gracefully.
+
+	serverErrors := make(chan error, 1)
+	go func() {
+		if server.TLSConfig != nil {
+			fmt.Printf("starting server with TLS on interface:port '%s'...", listenOnAddress)
+			serverErrors <- server.ListenAndServeTLS("", "")
+			return
+		}
+		fmt.Printf("starting server on interface:port '%s'...", listenOnAddress)
+		serverErrors <- server.ListenAndServe()
+	}()
+	select {
+	case err := <-serverErrors:
+		return err
+	case <-ctx.Done():
+	}
+	return xtermServer.shutdown(&server, sessionManager)
 }
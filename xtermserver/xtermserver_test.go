@@ -1,9 +1,20 @@
 package xtermserver
 
 import (
+	"context"
+	"crypto/x509"
+	"encoding/pem"
 	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/sirupsen/logrus"
 )
 
 // ----------------------------------------------------------------------------
@@ -42,6 +53,149 @@ func TestXtermServerImpl_Serve(test *testing.T) {
 
 }
 
+func TestXtermServerImpl_Serve_Shutdown(test *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		test.Fatal(err)
+	}
+	port := listener.Addr().(*net.TCPAddr).Port
+	listener.Close()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	testObject := &XtermServerImpl{
+		ServerAddress:             "127.0.0.1",
+		ServerPort:                port,
+		ServerShutdownDrainPeriod: 1,
+	}
+	served := make(chan error, 1)
+	go func() {
+		served <- testObject.Serve(ctx)
+	}()
+
+	url := fmt.Sprintf("http://127.0.0.1:%d/readiness", port)
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		response, err := http.Get(url)
+		if err == nil {
+			response.Body.Close()
+			break
+		}
+		if time.Now().After(deadline) {
+			test.Fatalf("server did not start: %s", err)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	cancel()
+	select {
+	case err := <-served:
+		if err != nil {
+			test.Errorf("expected Serve to return nil after shutdown, got %s", err)
+		}
+	case <-time.After(5 * time.Second):
+		test.Fatal("Serve did not return after its context was cancelled")
+	}
+	if _, err := http.Get(url); err == nil {
+		test.Error("expected server to stop accepting connections")
+	}
+}
+
+func TestCertificateReloader(test *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	directory := test.TempDir()
+	certFile := filepath.Join(directory, "tls.crt")
+	keyFile := filepath.Join(directory, "tls.key")
+	writeCertificate := func(hostname string) {
+		certificate, err := generateSelfSignedCertificate([]string{hostname})
+		if err != nil {
+			test.Fatal(err)
+		}
+		keyDer, err := x509.MarshalPKCS8PrivateKey(certificate.PrivateKey)
+		if err != nil {
+			test.Fatal(err)
+		}
+		// Write the key first so the pair is consistent once the certificate lands.
+		if err := os.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: keyDer}), 0600); err != nil {
+			test.Fatal(err)
+		}
+		if err := os.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: certificate.Certificate[0]}), 0600); err != nil {
+			test.Fatal(err)
+		}
+	}
+	servedHostnames := func(reloader *certificateReloader) []string {
+		certificate, err := reloader.getCertificate(nil)
+		if err != nil {
+			test.Fatal(err)
+		}
+		return certificate.Leaf.DNSNames
+	}
+
+	writeCertificate("first.example.com")
+	reloader, err := newCertificateReloader(certFile, keyFile)
+	if err != nil {
+		test.Fatal(err)
+	}
+	if err := reloader.watch(ctx); err != nil {
+		test.Fatal(err)
+	}
+	if hostnames := servedHostnames(reloader); hostnames[len(hostnames)-1] != "first.example.com" {
+		test.Fatalf("expected certificate for first.example.com, got %v", hostnames)
+	}
+
+	writeCertificate("second.example.com")
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		hostnames := servedHostnames(reloader)
+		if hostnames[len(hostnames)-1] == "second.example.com" {
+			break
+		}
+		if time.Now().After(deadline) {
+			test.Fatalf("expected certificate for second.example.com, got %v", hostnames)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+
+	// A broken pair leaves the current certificate in place.
+
+	if err := os.WriteFile(certFile, []byte("not a certificate"), 0600); err != nil {
+		test.Fatal(err)
+	}
+	time.Sleep(2 * certificateReloadDelay)
+	if hostnames := servedHostnames(reloader); hostnames[len(hostnames)-1] != "second.example.com" {
+		test.Errorf("expected certificate for second.example.com to be kept, got %v", hostnames)
+	}
+}
+
+func TestCreateRequestLog(test *testing.T) {
+	testCases := []struct {
+		target   string
+		expected string
+	}{
+		{target: "/xterm.html", expected: "/xterm.html"},
+		{target: "/xterm.html?profile=admin", expected: "/xterm.html?profile=admin"},
+		{target: "/xterm.html?access_token=s3cret", expected: "/xterm.html?access_token=REDACTED"},
+		{target: "/ws?access_token=s3cret&profile=admin", expected: "/ws?access_token=REDACTED&profile=admin"},
+		{target: "/oidc/callback?code=s3cret&state=abc", expected: "/oidc/callback?code=REDACTED&state=abc"},
+		{target: "/xterm.html?id_token=s3cret", expected: "/xterm.html?id_token=REDACTED"},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, testCase.target, nil)
+		request.AddCookie(&http.Cookie{Name: "cloudshell_session", Value: "s3cret"})
+		request.AddCookie(&http.Cookie{Name: "cloudshell_oidc_id_token", Value: "s3cret"})
+		fields := createRequestLog(request).(*logrus.Entry).Data
+		if actual := fields["request_url"]; actual != testCase.expected {
+			test.Errorf("createRequestLog(%q): expected request_url %q, got %q", testCase.target, testCase.expected, actual)
+		}
+		if actual := fmt.Sprint(fields["cookies"]); actual != "[cloudshell_session cloudshell_oidc_id_token]" {
+			test.Errorf("createRequestLog(%q): expected cookie names, got %s", testCase.target, actual)
+		}
+		if logged := fmt.Sprint(fields); strings.Contains(logged, "s3cret") {
+			test.Errorf("createRequestLog(%q): credential logged in %s", testCase.target, logged)
+		}
+	}
+}
+
 // ----------------------------------------------------------------------------
 // Examples for godoc documentation
 // ----------------------------------------------------------------------------
@@ -0,0 +1,141 @@
+package xtermservice
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// The Authenticator interface identifies the user making a request.
+type Authenticator interface {
+	// Authenticate returns the identity of the user making the request or
+	// ErrUnauthenticated when the request carries no valid credentials.
+	Authenticate(r *http.Request) (string, error)
+	// Challenge responds to a request that failed authentication, for example
+	// by asking the browser for credentials.
+	Challenge(w http.ResponseWriter, r *http.Request)
+}
+
+// The RouteProvider interface is implemented by authenticators serving routes
+// of their own, such as login callbacks.
+type RouteProvider interface {
+	AddRoutes(mux *http.ServeMux, urlRoutePrefix string)
+}
+
+// Authenticators is an Authenticator which accepts a request when any of its
+// members does. Challenges are delegated to the first member.
+type Authenticators []Authenticator
+
+type contextKey string
+
+// ----------------------------------------------------------------------------
+// Constants and variables
+// ----------------------------------------------------------------------------
+
+const userContextKey contextKey = "user"
+
+// ErrUnauthenticated is returned by an Authenticator when a request carries no
+// valid credentials.
+var ErrUnauthenticated = errors.New("unauthenticated")
+
+// ----------------------------------------------------------------------------
+// Interface methods
+// ----------------------------------------------------------------------------
+
+// Authenticate returns the identity found by the first member accepting r.
+func (authenticators Authenticators) Authenticate(r *http.Request) (string, error) {
+	for _, authenticator := range authenticators {
+		user, err := authenticator.Authenticate(r)
+		if err == nil {
+			return user, nil
+		}
+		if !errors.Is(err, ErrUnauthenticated) {
+			return "", err
+		}
+	}
+	return "", ErrUnauthenticated
+}
+
+// Challenge delegates to the first member.
+func (authenticators Authenticators) Challenge(w http.ResponseWriter, r *http.Request) {
+	if len(authenticators) == 0 {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	authenticators[0].Challenge(w, r)
+}
+
+// AddRoutes adds the routes of every member that is a RouteProvider.
+func (authenticators Authenticators) AddRoutes(mux *http.ServeMux, urlRoutePrefix string) {
+	for _, authenticator := range authenticators {
+		if routeProvider, ok := authenticator.(RouteProvider); ok {
+			routeProvider.AddRoutes(mux, urlRoutePrefix)
+		}
+	}
+}
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// requireAuthentication wraps next so that it is only served to requests the
+// authenticator accepts. The identity of the user is added to the request's
+// context. When authenticator is nil, next is returned unchanged.
+func requireAuthentication(authenticator Authenticator, next http.HandlerFunc) http.HandlerFunc {
+	if authenticator == nil {
+		return next
+	}
+	return func(w http.ResponseWriter, r *http.Request) {
+		user, err := authenticator.Authenticate(r)
+		if err != nil {
+			if !errors.Is(err, ErrUnauthenticated) {
+				createRequestLog(r).Warnf("failed to authenticate request: %s", err)
+			}
+			authenticator.Challenge(w, r)
+			return
+		}
+		createRequestLog(r, map[string]interface{}{"user": user}).Debug("authenticated request")
+		next(w, r.WithContext(context.WithValue(r.Context(), userContextKey, user)))
+	}
+}
+
+// userFromRequest returns the identity added to the request's context by
+// requireAuthentication, if any.
+func userFromRequest(r *http.Request) string {
+	user, _ := r.Context().Value(userContextKey).(string)
+	return user
+}
+
+// isAuthorized reports whether user may access what belongs to owner, which
+// is only what is their own unless they are one of administrators.
+func isAuthorized(administrators []string, user string, owner string) bool {
+	if user == "" {
+		return false
+	}
+	if user == owner {
+		return true
+	}
+	for _, administrator := range administrators {
+		if administrator == user {
+			return true
+		}
+	}
+	return false
+}
+
+func unauthorized(w http.ResponseWriter, wwwAuthenticate string) {
+	w.Header().Set("WWW-Authenticate", wwwAuthenticate)
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
+
+func quoteRealm(realm string) string {
+	if realm == "" {
+		realm = defaultRealm
+	}
+	return fmt.Sprintf("%q", realm)
+}
@@ -0,0 +1,85 @@
+package xtermservice
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// BearerTokenAuthenticator authenticates requests carrying one of a set of
+// static bearer tokens, either in the Authorization header or, for clients
+// unable to set headers such as browsers, in the "access_token" query
+// parameter. The bundled pages opened with the parameter forward it to the
+// scripts, APIs and websocket they load.
+type BearerTokenAuthenticator struct {
+	Realm string
+	// Tokens maps each accepted token to the identity of its user.
+	Tokens map[string]string
+}
+
+// ----------------------------------------------------------------------------
+// Constants
+// ----------------------------------------------------------------------------
+
+const defaultBearerTokenUser = "bearer"
+
+// ----------------------------------------------------------------------------
+// Constructors
+// ----------------------------------------------------------------------------
+
+// NewBearerTokenAuthenticator returns a BearerTokenAuthenticator accepting
+// tokens given as "user:token" or as a bare "token", in which case the user
+// is identified as "bearer".
+func NewBearerTokenAuthenticator(tokens []string, realm string) *BearerTokenAuthenticator {
+	authenticator := &BearerTokenAuthenticator{
+		Realm:  realm,
+		Tokens: map[string]string{},
+	}
+	for _, entry := range tokens {
+		user, token, found := strings.Cut(entry, ":")
+		if !found {
+			user, token = "", entry
+		}
+		if user == "" {
+			user = defaultBearerTokenUser
+		}
+		if token != "" {
+			authenticator.Tokens[token] = user
+		}
+	}
+	return authenticator
+}
+
+// ----------------------------------------------------------------------------
+// Interface methods
+// ----------------------------------------------------------------------------
+
+// Authenticate compares the request's bearer token with the accepted tokens.
+func (authenticator *BearerTokenAuthenticator) Authenticate(r *http.Request) (string, error) {
+	presented := r.URL.Query().Get("access_token")
+	if scheme, token, found := strings.Cut(r.Header.Get("Authorization"), " "); found && strings.EqualFold(scheme, "Bearer") {
+		presented = strings.TrimSpace(token)
+	}
+	if presented == "" {
+		return "", ErrUnauthenticated
+	}
+	user := ""
+	for token, tokenUser := range authenticator.Tokens {
+		if subtle.ConstantTimeCompare([]byte(token), []byte(presented)) == 1 {
+			user = tokenUser
+		}
+	}
+	if user == "" {
+		return "", ErrUnauthenticated
+	}
+	return user, nil
+}
+
+// Challenge responds with a Bearer challenge.
+func (authenticator *BearerTokenAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) {
+	unauthorized(w, "Bearer realm="+quoteRealm(authenticator.Realm))
+}
@@ -0,0 +1,60 @@
+package xtermservice
+
+import (
+	"crypto/x509"
+	"net/http"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// ClientCertificateAuthenticator identifies users by the TLS client certificate
+// verified during the handshake. The server must be configured to request and
+// verify client certificates.
+type ClientCertificateAuthenticator struct{}
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// certificateUser returns the identity of a client certificate: its subject's
+// common name or, failing that, its first email, DNS or URI subject
+// alternative name.
+func certificateUser(certificate *x509.Certificate) string {
+	if certificate.Subject.CommonName != "" {
+		return certificate.Subject.CommonName
+	}
+	if len(certificate.EmailAddresses) > 0 {
+		return certificate.EmailAddresses[0]
+	}
+	if len(certificate.DNSNames) > 0 {
+		return certificate.DNSNames[0]
+	}
+	if len(certificate.URIs) > 0 {
+		return certificate.URIs[0].String()
+	}
+	return ""
+}
+
+// ----------------------------------------------------------------------------
+// Interface methods
+// ----------------------------------------------------------------------------
+
+// Authenticate returns the identity of the request's verified client certificate.
+func (authenticator *ClientCertificateAuthenticator) Authenticate(r *http.Request) (string, error) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return "", ErrUnauthenticated
+	}
+	user := certificateUser(r.TLS.VerifiedChains[0][0])
+	if user == "" {
+		return "", ErrUnauthenticated
+	}
+	return user, nil
+}
+
+// Challenge rejects the request; browsers prompt for certificates during the
+// TLS handshake, not in response to HTTP challenges.
+func (authenticator *ClientCertificateAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) {
+	http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+}
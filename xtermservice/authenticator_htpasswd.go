@@ -0,0 +1,223 @@
+package xtermservice
+
+import (
+	"bufio"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/bcrypt"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// HtpasswdAuthenticator authenticates requests using HTTP Basic authentication
+// against an htpasswd file. Passwords hashed with bcrypt, APR1-MD5 and SHA-1
+// are supported. The file is re-read when it changes.
+type HtpasswdAuthenticator struct {
+	Filename string
+	Realm    string
+
+	mutex   sync.Mutex
+	modTime time.Time
+	users   map[string]string
+}
+
+// ----------------------------------------------------------------------------
+// Constants
+// ----------------------------------------------------------------------------
+
+const (
+	apr1Magic    = "$apr1$"
+	defaultRealm = "cloudshell"
+	itoa64       = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	shaPrefix    = "{SHA}"
+)
+
+// unknownUserHash is verified against when the user is not in the file, so an
+// unknown user takes as long to refuse as a wrong password and users cannot
+// be enumerated by timing.
+const unknownUserHash = "$2a$10$1E8ZheWqXHOpfMWyz3.A9e0wJlmee2Nb4b6V7lhIJhqq5fppZcE5C"
+
+// ----------------------------------------------------------------------------
+// Constructors
+// ----------------------------------------------------------------------------
+
+// NewHtpasswdAuthenticator returns an HtpasswdAuthenticator for filename,
+// failing when the file cannot be read.
+func NewHtpasswdAuthenticator(filename string, realm string) (*HtpasswdAuthenticator, error) {
+	authenticator := &HtpasswdAuthenticator{
+		Filename: filename,
+		Realm:    realm,
+	}
+	if err := authenticator.load(); err != nil {
+		return nil, err
+	}
+	return authenticator, nil
+}
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// apr1 computes the Apache variant of the MD5-based crypt(3) hash.
+func apr1(password string, salt string) string {
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	digest := md5.New()
+	digest.Write([]byte(password + apr1Magic + salt))
+	alternate := md5.Sum([]byte(password + salt + password))
+	for i := len(password); i > 0; i -= 16 {
+		if i > 16 {
+			digest.Write(alternate[:])
+		} else {
+			digest.Write(alternate[:i])
+		}
+	}
+	for i := len(password); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			digest.Write([]byte{0})
+		} else {
+			digest.Write([]byte{password[0]})
+		}
+	}
+	final := digest.Sum(nil)
+	for i := 0; i < 1000; i++ {
+		round := md5.New()
+		if i&1 != 0 {
+			round.Write([]byte(password))
+		} else {
+			round.Write(final)
+		}
+		if i%3 != 0 {
+			round.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			round.Write([]byte(password))
+		}
+		if i&1 != 0 {
+			round.Write(final)
+		} else {
+			round.Write([]byte(password))
+		}
+		final = round.Sum(nil)
+	}
+
+	var encoded strings.Builder
+	encode := func(value uint32, length int) {
+		for ; length > 0; length-- {
+			encoded.WriteByte(itoa64[value&0x3f])
+			value >>= 6
+		}
+	}
+	for _, group := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		encode(uint32(final[group[0]])<<16|uint32(final[group[1]])<<8|uint32(final[group[2]]), 4)
+	}
+	encode(uint32(final[11]), 2)
+	return apr1Magic + salt + "$" + encoded.String()
+}
+
+// verifyHtpasswdHash reports whether password matches an htpasswd hash.
+func verifyHtpasswdHash(hash string, password string) bool {
+	switch {
+	case strings.HasPrefix(hash, "$2a$"), strings.HasPrefix(hash, "$2b$"), strings.HasPrefix(hash, "$2y$"):
+		return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+	case strings.HasPrefix(hash, apr1Magic):
+		salt := strings.SplitN(strings.TrimPrefix(hash, apr1Magic), "$", 2)[0]
+		return subtle.ConstantTimeCompare([]byte(apr1(password, salt)), []byte(hash)) == 1
+	case strings.HasPrefix(hash, shaPrefix):
+		sum := sha1.Sum([]byte(password))
+		expected := shaPrefix + base64.StdEncoding.EncodeToString(sum[:])
+		return subtle.ConstantTimeCompare([]byte(expected), []byte(hash)) == 1
+	}
+	return false
+}
+
+// ----------------------------------------------------------------------------
+// Internal methods
+// ----------------------------------------------------------------------------
+
+// load reads the htpasswd file if it changed since it was last read.
+func (authenticator *HtpasswdAuthenticator) load() error {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+
+	fileInfo, err := os.Stat(authenticator.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+	if authenticator.users != nil && fileInfo.ModTime().Equal(authenticator.modTime) {
+		return nil
+	}
+	file, err := os.Open(authenticator.Filename)
+	if err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+	defer file.Close()
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, found := strings.Cut(line, ":")
+		if !found {
+			continue
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("failed to read htpasswd file: %w", err)
+	}
+	authenticator.users = users
+	authenticator.modTime = fileInfo.ModTime()
+	return nil
+}
+
+func (authenticator *HtpasswdAuthenticator) hash(user string) (string, bool) {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	hash, ok := authenticator.users[user]
+	return hash, ok
+}
+
+// ----------------------------------------------------------------------------
+// Interface methods
+// ----------------------------------------------------------------------------
+
+// Authenticate verifies the request's Basic credentials against the htpasswd file.
+func (authenticator *HtpasswdAuthenticator) Authenticate(r *http.Request) (string, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", ErrUnauthenticated
+	}
+	if err := authenticator.load(); err != nil {
+		return "", err
+	}
+	hash, ok := authenticator.hash(user)
+	if !ok {
+		hash = unknownUserHash
+	}
+	verified := verifyHtpasswdHash(hash, password)
+	if !ok || !verified {
+		return "", ErrUnauthenticated
+	}
+	return user, nil
+}
+
+// Challenge asks the browser for Basic credentials.
+func (authenticator *HtpasswdAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) {
+	unauthorized(w, "Basic realm="+quoteRealm(authenticator.Realm)+", charset=\"UTF-8\"")
+}
@@ -0,0 +1,518 @@
+package xtermservice
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html/template"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/coreos/go-oidc/v3/oidc"
+	"golang.org/x/oauth2"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// OidcAuthenticator authenticates browser users with an OpenID Connect
+// provider using the authorization code flow. Once logged in, users are
+// identified by a signed session cookie. Users log out by posting to the
+// "/oidc/logout" route, which asks them to confirm when loaded.
+type OidcAuthenticator struct {
+	ClientId     string
+	ClientSecret string
+	// HttpClient is used to talk to the provider. When nil, http.DefaultClient is used.
+	HttpClient *http.Client
+	// IssuerUrl is the provider's issuer, from which its configuration is discovered.
+	IssuerUrl string
+	// PostLogoutRedirectUrl is the absolute URL the provider returns users to
+	// once logged out. When empty, it is the root route next to RedirectUrl.
+	PostLogoutRedirectUrl string
+	// RedirectUrl is the absolute URL of the "/oidc/callback" route.
+	RedirectUrl string
+	Scopes      []string
+	// SessionDuration is how long a login remains valid.
+	SessionDuration time.Duration
+	// SessionSecret signs the session cookie.
+	SessionSecret []byte
+	// UsernameClaim is the ID token claim identifying the user. When empty,
+	// "preferred_username", "email" and "sub" are tried in that order.
+	UsernameClaim string
+
+	mutex          sync.Mutex
+	provider       *oidc.Provider
+	urlRoutePrefix string
+}
+
+// oidcLoginState is kept in a signed cookie between login and callback.
+type oidcLoginState struct {
+	Expiry   int64  `json:"exp"`
+	Nonce    string `json:"nonce"`
+	Redirect string `json:"redirect"`
+	State    string `json:"state"`
+}
+
+// oidcSession is kept in the signed session cookie.
+type oidcSession struct {
+	Expiry int64  `json:"exp"`
+	User   string `json:"user"`
+}
+
+// ----------------------------------------------------------------------------
+// Constants and variables
+// ----------------------------------------------------------------------------
+
+const (
+	defaultOidcSessionDuration = 8 * time.Hour
+	oidcCsrfTokenField         = "csrf_token"
+	// oidcIdTokenCookie keeps the ID token of the session apart from the
+	// session cookie, which stays small, to be sent as a hint when logging out
+	oidcIdTokenCookie      = "cloudshell_oidc_id_token"
+	oidcLoginStateCookie   = "cloudshell_oidc_state"
+	oidcLoginStateDuration = 10 * time.Minute
+	oidcSessionCookie      = "cloudshell_session"
+)
+
+var defaultOidcScopes = []string{"openid", "profile", "email"}
+
+// oidcLogoutTemplate asks users to confirm logging out, as logging out from a
+// page loaded by a link could be forced on them by other sites.
+var oidcLogoutTemplate = template.Must(template.New("logout").Parse(`<!DOCTYPE html>
+<html>
+
+<head>
+  <title>Log out</title>
+</head>
+
+<body>
+  <form method="post">
+    <input type="hidden" name="` + oidcCsrfTokenField + `" value="{{.}}" />
+    <button type="submit">Log out</button>
+  </form>
+</body>
+
+</html>
+`))
+
+// ----------------------------------------------------------------------------
+// Constructors
+// ----------------------------------------------------------------------------
+
+// NewOidcSessionSecret returns a random secret for signing session cookies.
+// Sessions signed with it do not survive a restart of the server.
+func NewOidcSessionSecret() ([]byte, error) {
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+func randomString() (string, error) {
+	value := make([]byte, 24)
+	if _, err := rand.Read(value); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(value), nil
+}
+
+// isBrowserNavigation reports whether r looks like a page load rather than an
+// API or websocket request.
+func isBrowserNavigation(r *http.Request) bool {
+	return r.Method == http.MethodGet &&
+		r.Header.Get("Upgrade") == "" &&
+		strings.Contains(r.Header.Get("Accept"), "text/html")
+}
+
+// ----------------------------------------------------------------------------
+// Internal methods
+// ----------------------------------------------------------------------------
+
+func (authenticator *OidcAuthenticator) httpClient() *http.Client {
+	if authenticator.HttpClient != nil {
+		return authenticator.HttpClient
+	}
+	return http.DefaultClient
+}
+
+// clientContext returns ctx carrying the client used to talk to the provider.
+func (authenticator *OidcAuthenticator) clientContext(ctx context.Context) context.Context {
+	return oidc.ClientContext(ctx, authenticator.httpClient())
+}
+
+// discover fetches and caches the provider's configuration.
+func (authenticator *OidcAuthenticator) discover(ctx context.Context) (*oidc.Provider, error) {
+	authenticator.mutex.Lock()
+	defer authenticator.mutex.Unlock()
+	if authenticator.provider != nil {
+		return authenticator.provider, nil
+	}
+	provider, err := oidc.NewProvider(authenticator.clientContext(ctx), authenticator.IssuerUrl)
+	if err != nil {
+		return nil, fmt.Errorf("failed to discover OpenID Connect provider: %w", err)
+	}
+	authenticator.provider = provider
+	return provider, nil
+}
+
+// oauth2Config returns the configuration of the authorization code flow with
+// provider.
+func (authenticator *OidcAuthenticator) oauth2Config(provider *oidc.Provider) *oauth2.Config {
+	scopes := authenticator.Scopes
+	if len(scopes) == 0 {
+		scopes = defaultOidcScopes
+	}
+	return &oauth2.Config{
+		ClientID:     authenticator.ClientId,
+		ClientSecret: authenticator.ClientSecret,
+		Endpoint:     provider.Endpoint(),
+		RedirectURL:  authenticator.RedirectUrl,
+		Scopes:       scopes,
+	}
+}
+
+// verifyIdToken checks the signature and claims of an ID token and returns
+// its claims.
+func (authenticator *OidcAuthenticator) verifyIdToken(ctx context.Context, provider *oidc.Provider, rawIdToken string, nonce string) (map[string]interface{}, error) {
+	idToken, err := provider.Verifier(&oidc.Config{ClientID: authenticator.ClientId}).Verify(ctx, rawIdToken)
+	if err != nil {
+		return nil, err
+	}
+	if !hmac.Equal([]byte(idToken.Nonce), []byte(nonce)) {
+		return nil, errors.New("ID token nonce does not match")
+	}
+	claims := map[string]interface{}{}
+	if err := idToken.Claims(&claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims: %w", err)
+	}
+
+	// The verifier only requires the client to be one of the audiences. When
+	// there are others, the token must have been issued to the client.
+
+	authorizedParty, _ := claims["azp"].(string)
+	if (len(idToken.Audience) > 1 || authorizedParty != "") && authorizedParty != authenticator.ClientId {
+		return nil, fmt.Errorf("ID token was issued to '%s', not to this client", authorizedParty)
+	}
+	return claims, nil
+}
+
+// endSessionUrl returns the provider's URL ending the session of the user
+// identified by idToken, or "" when the provider does not support it.
+func (authenticator *OidcAuthenticator) endSessionUrl(provider *oidc.Provider, idToken string) string {
+	metadata := struct {
+		EndSessionEndpoint string `json:"end_session_endpoint"`
+	}{}
+	if err := provider.Claims(&metadata); err != nil || metadata.EndSessionEndpoint == "" {
+		return ""
+	}
+	endSessionUrl, err := url.Parse(metadata.EndSessionEndpoint)
+	if err != nil {
+		return ""
+	}
+	postLogoutRedirectUrl := authenticator.PostLogoutRedirectUrl
+	if postLogoutRedirectUrl == "" {
+		postLogoutRedirectUrl = strings.TrimSuffix(authenticator.RedirectUrl, "/oidc/callback") + "/"
+	}
+	query := endSessionUrl.Query()
+	query.Set("client_id", authenticator.ClientId)
+	query.Set("post_logout_redirect_uri", postLogoutRedirectUrl)
+	if idToken != "" {
+		query.Set("id_token_hint", idToken)
+	}
+	endSessionUrl.RawQuery = query.Encode()
+	return endSessionUrl.String()
+}
+
+// username returns the identity of the user from the ID token's claims.
+func (authenticator *OidcAuthenticator) username(claims map[string]interface{}) string {
+	candidates := []string{"preferred_username", "email", "sub"}
+	if authenticator.UsernameClaim != "" {
+		candidates = []string{authenticator.UsernameClaim}
+	}
+	for _, candidate := range candidates {
+		if value, ok := claims[candidate].(string); ok && value != "" {
+			return value
+		}
+	}
+	return ""
+}
+
+// sign returns value as a JSON payload followed by its HMAC signature.
+func (authenticator *OidcAuthenticator) sign(value interface{}) (string, error) {
+	payload, err := json.Marshal(value)
+	if err != nil {
+		return "", err
+	}
+	mac := hmac.New(sha256.New, authenticator.SessionSecret)
+	mac.Write(payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil)), nil
+}
+
+// verify checks a value produced by sign and decodes it into value.
+func (authenticator *OidcAuthenticator) verify(signed string, value interface{}) error {
+	encodedPayload, encodedSignature, found := strings.Cut(signed, ".")
+	if !found {
+		return ErrUnauthenticated
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return ErrUnauthenticated
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(encodedSignature)
+	if err != nil {
+		return ErrUnauthenticated
+	}
+	mac := hmac.New(sha256.New, authenticator.SessionSecret)
+	mac.Write(payload)
+	if !hmac.Equal(signature, mac.Sum(nil)) {
+		return ErrUnauthenticated
+	}
+	if err := json.Unmarshal(payload, value); err != nil {
+		return ErrUnauthenticated
+	}
+	return nil
+}
+
+// csrfToken returns the token that requests changing the state of the login
+// signed as session must carry.
+func (authenticator *OidcAuthenticator) csrfToken(session string) string {
+	mac := hmac.New(sha256.New, authenticator.SessionSecret)
+	mac.Write([]byte("csrf:" + session))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+func (authenticator *OidcAuthenticator) setCookie(w http.ResponseWriter, name string, value string, maxAge time.Duration) {
+	http.SetCookie(w, &http.Cookie{
+		HttpOnly: true,
+		MaxAge:   int(maxAge.Seconds()),
+		Name:     name,
+		Path:     authenticator.urlRoutePrefix + "/",
+		SameSite: http.SameSiteLaxMode,
+		Secure:   strings.HasPrefix(authenticator.RedirectUrl, "https://"),
+		Value:    value,
+	})
+}
+
+func (authenticator *OidcAuthenticator) clearCookie(w http.ResponseWriter, name string) {
+	authenticator.setCookie(w, name, "", -time.Second)
+}
+
+// login redirects the browser to the provider's authorization endpoint.
+func (authenticator *OidcAuthenticator) login(w http.ResponseWriter, r *http.Request) {
+	provider, err := authenticator.discover(r.Context())
+	if err != nil {
+		createRequestLog(r).Warn(err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	redirect := r.URL.Query().Get("redirect")
+	if !strings.HasPrefix(redirect, "/") || strings.HasPrefix(redirect, "//") {
+		redirect = authenticator.urlRoutePrefix + "/"
+	}
+	state, err := randomString()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	nonce, err := randomString()
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	loginState, err := authenticator.sign(oidcLoginState{
+		Expiry:   time.Now().Add(oidcLoginStateDuration).Unix(),
+		Nonce:    nonce,
+		Redirect: redirect,
+		State:    state,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	authenticator.setCookie(w, oidcLoginStateCookie, loginState, oidcLoginStateDuration)
+	http.Redirect(w, r, authenticator.oauth2Config(provider).AuthCodeURL(state, oidc.Nonce(nonce)), http.StatusFound)
+}
+
+// callback completes the login by exchanging the authorization code for an
+// ID token and setting the session cookie.
+func (authenticator *OidcAuthenticator) callback(w http.ResponseWriter, r *http.Request) {
+	ctx := authenticator.clientContext(r.Context())
+	requestLog := createRequestLog(r)
+
+	if errorCode := r.URL.Query().Get("error"); errorCode != "" {
+		requestLog.Warnf("OpenID Connect provider returned error '%s': %s", errorCode, r.URL.Query().Get("error_description"))
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	cookie, err := r.Cookie(oidcLoginStateCookie)
+	if err != nil {
+		http.Error(w, "missing login state", http.StatusBadRequest)
+		return
+	}
+	loginState := oidcLoginState{}
+	if err := authenticator.verify(cookie.Value, &loginState); err != nil || time.Unix(loginState.Expiry, 0).Before(time.Now()) {
+		http.Error(w, "invalid login state", http.StatusBadRequest)
+		return
+	}
+	if !hmac.Equal([]byte(loginState.State), []byte(r.URL.Query().Get("state"))) {
+		http.Error(w, "login state does not match", http.StatusBadRequest)
+		return
+	}
+	authenticator.clearCookie(w, oidcLoginStateCookie)
+
+	provider, err := authenticator.discover(ctx)
+	if err != nil {
+		requestLog.Warn(err)
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	// Exchange the authorization code.
+
+	token, err := authenticator.oauth2Config(provider).Exchange(ctx, r.URL.Query().Get("code"))
+	if err != nil {
+		requestLog.Warnf("failed to exchange authorization code: %s", err)
+		var retrieveError *oauth2.RetrieveError
+		if errors.As(err, &retrieveError) {
+			http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+			return
+		}
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+	rawIdToken, _ := token.Extra("id_token").(string)
+	if rawIdToken == "" {
+		requestLog.Warn("failed to read ID token from token response")
+		http.Error(w, http.StatusText(http.StatusBadGateway), http.StatusBadGateway)
+		return
+	}
+
+	// Verify the ID token and start a session.
+
+	claims, err := authenticator.verifyIdToken(ctx, provider, rawIdToken, loginState.Nonce)
+	if err != nil {
+		requestLog.Warnf("failed to verify ID token: %s", err)
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	user := authenticator.username(claims)
+	if user == "" {
+		requestLog.Warn("ID token does not identify the user")
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	sessionDuration := authenticator.SessionDuration
+	if sessionDuration <= 0 {
+		sessionDuration = defaultOidcSessionDuration
+	}
+	session, err := authenticator.sign(oidcSession{
+		Expiry: time.Now().Add(sessionDuration).Unix(),
+		User:   user,
+	})
+	if err != nil {
+		http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+		return
+	}
+	authenticator.setCookie(w, oidcSessionCookie, session, sessionDuration)
+	authenticator.setCookie(w, oidcIdTokenCookie, rawIdToken, sessionDuration)
+	createRequestLog(r, map[string]interface{}{"user": user}).Info("user logged in")
+	http.Redirect(w, r, loginState.Redirect, http.StatusFound)
+}
+
+// logout asks users to confirm logging out when loaded and, when posted to,
+// clears the session cookie and ends the session at the provider when
+// supported. Posts must carry the CSRF token of the session.
+func (authenticator *OidcAuthenticator) logout(w http.ResponseWriter, r *http.Request) {
+	session := ""
+	if cookie, err := r.Cookie(oidcSessionCookie); err == nil {
+		session = cookie.Value
+	}
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		if session == "" {
+			http.Redirect(w, r, authenticator.urlRoutePrefix+"/", http.StatusFound)
+			return
+		}
+		w.Header().Set("Content-Type", "text/html")
+		if err := oidcLogoutTemplate.Execute(w, authenticator.csrfToken(session)); err != nil {
+			createRequestLog(r).Warnf("failed to render logout page: %s", err)
+		}
+		return
+	case http.MethodPost:
+	default:
+		w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead, http.MethodPost}, ", "))
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+		return
+	}
+	if session != "" && !hmac.Equal([]byte(r.PostFormValue(oidcCsrfTokenField)), []byte(authenticator.csrfToken(session))) {
+		http.Error(w, "invalid CSRF token", http.StatusForbidden)
+		return
+	}
+	idToken := ""
+	if cookie, err := r.Cookie(oidcIdTokenCookie); err == nil {
+		idToken = cookie.Value
+	}
+	authenticator.clearCookie(w, oidcSessionCookie)
+	authenticator.clearCookie(w, oidcIdTokenCookie)
+	if session != "" {
+		if provider, err := authenticator.discover(r.Context()); err == nil {
+			if endSessionUrl := authenticator.endSessionUrl(provider, idToken); endSessionUrl != "" {
+				http.Redirect(w, r, endSessionUrl, http.StatusFound)
+				return
+			}
+		}
+	}
+	http.Redirect(w, r, authenticator.urlRoutePrefix+"/", http.StatusFound)
+}
+
+// ----------------------------------------------------------------------------
+// Interface methods
+// ----------------------------------------------------------------------------
+
+// Authenticate identifies the user from the session cookie.
+func (authenticator *OidcAuthenticator) Authenticate(r *http.Request) (string, error) {
+	cookie, err := r.Cookie(oidcSessionCookie)
+	if err != nil {
+		return "", ErrUnauthenticated
+	}
+	session := oidcSession{}
+	if err := authenticator.verify(cookie.Value, &session); err != nil {
+		return "", err
+	}
+	if session.User == "" || time.Unix(session.Expiry, 0).Before(time.Now()) {
+		return "", ErrUnauthenticated
+	}
+	return session.User, nil
+}
+
+// Challenge sends browsers to the login route and rejects other requests.
+func (authenticator *OidcAuthenticator) Challenge(w http.ResponseWriter, r *http.Request) {
+	if !isBrowserNavigation(r) {
+		http.Error(w, http.StatusText(http.StatusUnauthorized), http.StatusUnauthorized)
+		return
+	}
+	query := url.Values{"redirect": {authenticator.urlRoutePrefix + r.URL.RequestURI()}}
+	http.Redirect(w, r, authenticator.urlRoutePrefix+"/oidc/login?"+query.Encode(), http.StatusFound)
+}
+
+// AddRoutes adds the login, callback and logout routes.
+func (authenticator *OidcAuthenticator) AddRoutes(mux *http.ServeMux, urlRoutePrefix string) {
+	authenticator.urlRoutePrefix = urlRoutePrefix
+	mux.HandleFunc("/oidc/login", authenticator.login)
+	mux.HandleFunc("/oidc/callback", authenticator.callback)
+	mux.HandleFunc("/oidc/logout", authenticator.logout)
+}
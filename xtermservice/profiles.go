@@ -0,0 +1,61 @@
+package xtermservice
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/docktermj/cloudshell/pkg/xtermjs"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// ProfileInfo describes a profile that browsers can start a session from.
+type ProfileInfo struct {
+	Description string `json:"description,omitempty"`
+	Name        string `json:"name"`
+}
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// listProfiles returns the descriptions of profiles, sorted by name.
+func listProfiles(profiles map[string]xtermjs.Profile) []ProfileInfo {
+	result := []ProfileInfo{}
+	for name, profile := range profiles {
+		result = append(result, ProfileInfo{
+			Description: profile.Description,
+			Name:        name,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].Name < result[j].Name
+	})
+	return result
+}
+
+/*
+The getProfilesHandler function returns the handler of the profiles API.
+
+  - GET /profiles lists the profiles sessions can be started from.
+
+Input
+  - profiles: The profiles by name.
+
+Output
+  - A handler to be mounted on "/profiles".
+*/
+func getProfilesHandler(profiles map[string]xtermjs.Profile) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead}, ", "))
+			writeJsonError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		writeJson(w, http.StatusOK, listProfiles(profiles))
+	}
+}
@@ -0,0 +1,149 @@
+package xtermservice
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/docktermj/cloudshell/pkg/xtermjs"
+)
+
+// ----------------------------------------------------------------------------
+// Types
+// ----------------------------------------------------------------------------
+
+// RecordingInfo describes a session recording stored in the recording directory.
+type RecordingInfo struct {
+	ModTime time.Time `json:"modTime"`
+	Name    string    `json:"name"`
+	Size    int64     `json:"size"`
+	User    string    `json:"user,omitempty"`
+}
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+// recordingUser returns the user who made the recording read from file, as
+// written in its header, or "" when it is not known yet.
+func recordingUser(file *os.File) string {
+	header := struct {
+		Env map[string]string `json:"env"`
+	}{}
+	if err := json.NewDecoder(file).Decode(&header); err != nil {
+		return ""
+	}
+	return header.Env["USER"]
+}
+
+// readRecordingUser returns the user who made the recording at path.
+func readRecordingUser(path string) string {
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+	return recordingUser(file)
+}
+
+// listRecordings returns the recordings in directory that user may access,
+// most recent first.
+func listRecordings(directory string, administrators []string, user string) ([]RecordingInfo, error) {
+	result := []RecordingInfo{}
+	entries, err := os.ReadDir(directory)
+	if err != nil {
+		if errors.Is(err, os.ErrNotExist) {
+			return result, nil
+		}
+		return result, err
+	}
+	for _, entry := range entries {
+		if entry.IsDir() || filepath.Ext(entry.Name()) != xtermjs.RecordingFileExtension {
+			continue
+		}
+		fileInfo, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		recordingUser := readRecordingUser(filepath.Join(directory, entry.Name()))
+		if !isAuthorized(administrators, user, recordingUser) {
+			continue
+		}
+		result = append(result, RecordingInfo{
+			ModTime: fileInfo.ModTime(),
+			Name:    entry.Name(),
+			Size:    fileInfo.Size(),
+			User:    recordingUser,
+		})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return result[i].ModTime.After(result[j].ModTime)
+	})
+	return result, nil
+}
+
+/*
+The getRecordingsHandler function returns the handler of the recordings API.
+Users only see and replay the recordings of their own sessions, unless they are
+administrators; the recordings of others are reported as not found, as are
+those whose header is not written yet.
+
+  - GET /recordings lists the stored recordings.
+  - GET /recordings/{name} returns the asciicast file of a recording.
+
+Input
+  - directory: The directory in which sessions are recorded.
+  - administrators: The users allowed to see and replay every recording.
+
+Output
+  - A handler to be mounted on both "/recordings" and "/recordings/".
+*/
+func getRecordingsHandler(directory string, administrators []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodHead}, ", "))
+			writeJsonError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+
+		name := strings.Trim(strings.TrimPrefix(r.URL.Path, "/recordings"), "/")
+		if name == "" {
+			recordings, err := listRecordings(directory, administrators, userFromRequest(r))
+			if err != nil {
+				createRequestLog(r).Warnf("failed to list recordings: %s", err)
+				writeJsonError(w, http.StatusInternalServerError, errors.New(http.StatusText(http.StatusInternalServerError)))
+				return
+			}
+			writeJson(w, http.StatusOK, recordings)
+			return
+		}
+
+		if name != filepath.Base(name) || filepath.Ext(name) != xtermjs.RecordingFileExtension {
+			http.NotFound(w, r)
+			return
+		}
+		file, err := os.Open(filepath.Join(directory, name))
+		if err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		defer file.Close()
+		fileInfo, err := file.Stat()
+		if err != nil || fileInfo.IsDir() || !isAuthorized(administrators, userFromRequest(r), recordingUser(file)) {
+			http.NotFound(w, r)
+			return
+		}
+		if _, err := file.Seek(0, io.SeekStart); err != nil {
+			http.NotFound(w, r)
+			return
+		}
+		w.Header().Set("Content-Type", "application/x-asciicast")
+		http.ServeContent(w, r, name, fileInfo.ModTime(), file)
+	}
+}
@@ -0,0 +1,92 @@
+package xtermservice
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/docktermj/cloudshell/pkg/xtermjs"
+)
+
+// ----------------------------------------------------------------------------
+// Internal functions
+// ----------------------------------------------------------------------------
+
+func writeJson(w http.ResponseWriter, statusCode int, value interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(statusCode)
+	if err := json.NewEncoder(w).Encode(value); err != nil {
+		createRequestLog(nil).Warnf("failed to encode json response: %s", err)
+	}
+}
+
+func writeJsonError(w http.ResponseWriter, statusCode int, err error) {
+	writeJson(w, statusCode, map[string]string{"error": err.Error()})
+}
+
+/*
+The getSessionsHandler function returns the handler of the session API. Users
+only see and terminate the sessions they started, unless they are
+administrators; the sessions of others are reported as not found.
+
+  - GET /sessions lists the live sessions.
+  - GET /sessions/{id} returns the details of a session.
+  - DELETE /sessions/{id} terminates a session.
+
+Input
+  - sessionManager: The SessionManager used by the xterm.js handler.
+  - administrators: The users allowed to see and terminate every session.
+
+Output
+  - A handler to be mounted on both "/sessions" and "/sessions/".
+*/
+func getSessionsHandler(sessionManager *xtermjs.SessionManager, administrators []string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		id := strings.Trim(strings.TrimPrefix(r.URL.Path, "/sessions"), "/")
+		user := userFromRequest(r)
+
+		if id == "" {
+			if r.Method != http.MethodGet {
+				w.Header().Set("Allow", http.MethodGet)
+				writeJsonError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+				return
+			}
+			sessionInfos := []xtermjs.SessionInfo{}
+			for _, sessionInfo := range sessionManager.List() {
+				if isAuthorized(administrators, user, sessionInfo.User) {
+					sessionInfos = append(sessionInfos, sessionInfo)
+				}
+			}
+			writeJson(w, http.StatusOK, sessionInfos)
+			return
+		}
+
+		sessionInfo, err := sessionManager.Get(id)
+		if err == nil && !isAuthorized(administrators, user, sessionInfo.User) {
+			err = fmt.Errorf("session '%s' not found", id)
+		}
+		switch r.Method {
+		case http.MethodGet:
+			if err != nil {
+				writeJsonError(w, http.StatusNotFound, err)
+				return
+			}
+			writeJson(w, http.StatusOK, sessionInfo)
+		case http.MethodDelete:
+			if err == nil {
+				err = sessionManager.Kill(id)
+			}
+			if err != nil {
+				writeJsonError(w, http.StatusNotFound, err)
+				return
+			}
+			createRequestLog(r).Infof("killed session '%s'", id)
+			w.WriteHeader(http.StatusNoContent)
+		default:
+			w.Header().Set("Allow", strings.Join([]string{http.MethodGet, http.MethodDelete}, ", "))
+			writeJsonError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+		}
+	}
+}
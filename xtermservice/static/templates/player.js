@@ -0,0 +1,118 @@
+(function () {
+  var params = new URLSearchParams(location.search);
+  var name = params.get("recording");
+  var playButton = document.getElementById("play");
+  var speedSelect = document.getElementById("speed");
+  var seekInput = document.getElementById("seek");
+  var timeLabel = document.getElementById("time");
+
+  var terminal = null;
+  var header = null;
+  var events = [];
+  var duration = 0;
+  var index = 0;
+  var position = 0;
+  var playing = false;
+  var lastTick = 0;
+
+  var formatTime = function (seconds) {
+    var minutes = Math.floor(seconds / 60);
+    var rest = Math.floor(seconds % 60);
+    return minutes + ":" + (rest < 10 ? "0" : "") + rest;
+  };
+
+  var updateControls = function () {
+    playButton.textContent = playing ? "pause" : "play";
+    seekInput.value = position;
+    timeLabel.textContent = formatTime(position) + " / " + formatTime(duration);
+  };
+
+  // Apply every event up to the given position in one terminal write.
+  var advance = function (target) {
+    var output = "";
+    while (index < events.length && events[index][0] <= target) {
+      var event = events[index];
+      if (event[1] === "o") {
+        output += event[2];
+      } else if (event[1] === "r") {
+        var size = event[2].split("x");
+        terminal.write(output);
+        output = "";
+        terminal.resize(parseInt(size[0], 10), parseInt(size[1], 10));
+      }
+      index++;
+    }
+    if (output.length > 0) {
+      terminal.write(output);
+    }
+    position = Math.min(target, duration);
+  };
+
+  var seek = function (target) {
+    if (target < position) {
+      terminal.reset();
+      terminal.resize(header.width, header.height);
+      index = 0;
+    }
+    advance(target);
+    updateControls();
+  };
+
+  var tick = function (now) {
+    if (playing) {
+      var elapsed = (now - lastTick) / 1000 * parseFloat(speedSelect.value);
+      advance(position + elapsed);
+      if (position >= duration) {
+        playing = false;
+      }
+      updateControls();
+    }
+    lastTick = now;
+    window.requestAnimationFrame(tick);
+  };
+
+  playButton.onclick = function () {
+    if (!playing && position >= duration) {
+      seek(0);
+    }
+    playing = !playing;
+    updateControls();
+  };
+  seekInput.oninput = function () {
+    seek(parseFloat(seekInput.value));
+  };
+
+  var recordingUrl = "{{.UrlRoutePrefix}}/recordings/" + encodeURIComponent(name);
+  if (params.get("access_token")) {
+    recordingUrl += "?access_token=" + encodeURIComponent(params.get("access_token"));
+  }
+  fetch(recordingUrl)
+    .then(function (response) {
+      if (!response.ok) {
+        throw new Error(response.status + " " + response.statusText);
+      }
+      return response.text();
+    })
+    .then(function (text) {
+      var lines = text.split("\n").filter(function (line) { return line.length > 0; });
+      header = JSON.parse(lines[0]);
+      events = lines.slice(1).map(function (line) { return JSON.parse(line); });
+      duration = events.length > 0 ? events[events.length - 1][0] : 0;
+      document.title = document.title + " - " + name;
+      terminal = new Terminal({
+        cols: header.width,
+        rows: header.height,
+        disableStdin: true,
+        convertEol: false,
+      });
+      var unicode11Addon = new Unicode11Addon.Unicode11Addon();
+      terminal.loadAddon(unicode11Addon);
+      terminal.open(document.getElementById("terminal"));
+      seekInput.max = duration;
+      updateControls();
+      window.requestAnimationFrame(tick);
+    })
+    .catch(function (error) {
+      timeLabel.textContent = "failed to load recording: " + error.message;
+    });
+})();
@@ -9,10 +9,8 @@
     cols: 128,
   });
   terminal.open(document.getElementById("terminal"));
+  var params = new URLSearchParams(location.search);
   var protocol = (location.protocol === "https:") ? "wss://" : "ws://";
-  var url = protocol + location.host + "{{.UrlRoutePrefix}}/xterm.js"
-  var ws = new WebSocket(url);
-  var attachAddon = new AttachAddon.AttachAddon(ws);
   var fitAddon = new FitAddon.FitAddon();
   terminal.loadAddon(fitAddon);
   var webLinksAddon = new WebLinksAddon.WebLinksAddon();
@@ -21,28 +19,234 @@
   terminal.loadAddon(unicode11Addon);
   var serializeAddon = new SerializeAddon.SerializeAddon();
   terminal.loadAddon(serializeAddon);
-  ws.onclose = function (event) {
-    console.log(event);
-    terminal.write('\r\n\nconnection has been terminated from the server-side (hit refresh to restart)\n')
+
+  // Close codes sent by the server, see pkg/xtermjs/types.go.
+  var CLOSE_PROCESS_EXITED = 4000;
+  var CLOSE_SESSION_SUPERSEDED = 4001;
+
+  // Framing protocol and message types, see pkg/xtermjs/protocol.go.
+  var PROTOCOL = "cloudshell.v1";
+  var MESSAGE_INPUT = "i";
+  var MESSAGE_OUTPUT = "o";
+  var MESSAGE_RESIZE = "r";
+  var MESSAGE_ACK = "a";
+  var MESSAGE_TITLE = "t";
+  var MESSAGE_SESSION = "s";
+  var MESSAGE_SEQUENCE = "n";
+  var MESSAGE_LENGTH = "l";
+  var MESSAGE_EXIT = "x";
+  var MESSAGE_ERROR = "e";
+  var encoder = new TextEncoder();
+  var decoder = new TextDecoder();
+  var baseTitle = document.title;
+
+  // Output is acknowledged once xterm.js has processed it, so that the server
+  // stops sending while this browser is behind. Acknowledgements are batched
+  // but always sent when nothing remains to be processed.
+  var ACK_BYTES = 65536;
+  var pendingWrites = 0;
+  var processedBytes = 0;
+  // The number of output bytes carried by the next output message, when the
+  // server re-encoded them as text
+  var outputLength = null;
+
+  var overlay = document.getElementById("overlay");
+  var overlayMessage = document.getElementById("overlay-message");
+  var restartButton = document.getElementById("restart");
+  var reconnectButton = document.getElementById("reconnect");
+  var ws = null;
+  var exitStatus = null;
+
+  // The sequence number following the last output byte received, so that a
+  // reconnect is sent only the output it missed. Connections lost for another
+  // reason than the shell exiting are retried a few times before giving up.
+  var sequence = null;
+  var RECONNECT_ATTEMPTS = 5;
+  var RECONNECT_DELAY_MS = 500;
+  var reconnectAttempts = 0;
+  var reconnectTimer = null;
+
+  // Remember the session in the page URL so that a refresh reattaches to it.
+  var onSession = function (session) {
+    params.set("session", session);
+    history.replaceState(null, "", location.pathname + "?" + params.toString());
+  };
+
+  var forgetSession = function () {
+    params.delete("session");
+    var query = params.toString();
+    history.replaceState(null, "", location.pathname + (query ? "?" + query : ""));
+  };
+
+  var showOverlay = function (message, canReconnect) {
+    overlayMessage.textContent = message;
+    reconnectButton.style.display = canReconnect ? "" : "none";
+    overlay.style.display = "flex";
+    restartButton.focus();
   };
-  ws.onopen = function () {
-    terminal.loadAddon(attachAddon);
-    terminal._initialized = true;
+
+  var hideOverlay = function () {
+    overlay.style.display = "none";
     terminal.focus();
-    setTimeout(function () { fitAddon.fit() });
-    terminal.onResize(function (event) {
-      var rows = event.rows;
-      var cols = event.cols;
-      var size = JSON.stringify({ cols: cols, rows: rows + 1 });
-      var send = new TextEncoder().encode("\x01" + size);
-      console.log('resizing to', size);
-      ws.send(send);
-    });
-    terminal.onTitleChange(function (event) {
+  };
+
+  var describeExit = function (exit) {
+    if (exit.limit) {
+      return "The shell was terminated as it reached its " + exit.limit + ".";
+    }
+    if (exit.signal) {
+      return "The shell was terminated by signal: " + exit.signal + ".";
+    }
+    return "The shell exited with code " + exit.code + ".";
+  };
+
+  var send = function (type, payload) {
+    if (ws && ws.readyState === WebSocket.OPEN) {
+      var frame = new Uint8Array(payload.length + 1);
+      frame[0] = type.charCodeAt(0);
+      frame.set(payload, 1);
+      ws.send(frame);
+    }
+  };
+
+  var sendSize = function () {
+    var size = JSON.stringify({ cols: terminal.cols, rows: terminal.rows + 1 });
+    send(MESSAGE_RESIZE, encoder.encode(size));
+  };
+
+  var connect = function () {
+    var url = protocol + location.host + "{{.UrlRoutePrefix}}/xterm.js";
+    var query = new URLSearchParams();
+    if (params.get("access_token")) {
+      query.set("access_token", params.get("access_token"));
+    }
+    if (params.get("profile")) {
+      query.set("profile", params.get("profile"));
+    }
+    if (params.get("session")) {
+      query.set("session", params.get("session"));
+      if (sequence !== null) {
+        query.set("sequence", sequence);
+      }
+    }
+    if (query.toString()) {
+      url += "?" + query.toString();
+    }
+    clearTimeout(reconnectTimer);
+    exitStatus = null;
+    pendingWrites = 0;
+    processedBytes = 0;
+    outputLength = null;
+    ws = new WebSocket(url, [PROTOCOL]);
+    ws.binaryType = "arraybuffer";
+
+    ws.onmessage = function (event) {
+      // text frames are sent when the server sends output as text
+      var data = typeof event.data === "string" ? encoder.encode(event.data) : new Uint8Array(event.data);
+      if (data.length === 0) {
+        return;
+      }
+      var payload = data.subarray(1);
+      switch (String.fromCharCode(data[0])) {
+        case MESSAGE_OUTPUT:
+          // acks and sequence numbers count the bytes read from the tty,
+          // which the length message gives when they were re-encoded as text
+          var length = outputLength !== null ? outputLength : payload.length;
+          outputLength = null;
+          sequence += length;
+          var socket = ws;
+          pendingWrites++;
+          terminal.write(payload, function () {
+            if (socket !== ws) {
+              return;
+            }
+            pendingWrites--;
+            processedBytes += length;
+            if (processedBytes >= ACK_BYTES || pendingWrites === 0) {
+              send(MESSAGE_ACK, encoder.encode(String(processedBytes)));
+              processedBytes = 0;
+            }
+          });
+          break;
+        case MESSAGE_TITLE:
+          document.title = baseTitle + " - " + decoder.decode(payload);
+          break;
+        case MESSAGE_SESSION:
+          onSession(decoder.decode(payload));
+          break;
+        case MESSAGE_SEQUENCE:
+          sequence = parseInt(decoder.decode(payload), 10);
+          break;
+        case MESSAGE_LENGTH:
+          outputLength = parseInt(decoder.decode(payload), 10);
+          break;
+        case MESSAGE_EXIT:
+          exitStatus = JSON.parse(decoder.decode(payload));
+          break;
+        case MESSAGE_ERROR:
+          console.warn("server error: " + decoder.decode(payload));
+          break;
+      }
+    };
+    ws.onclose = function (event) {
       console.log(event);
-    });
-    window.onresize = function () {
-      fitAddon.fit();
+      if (event.code === CLOSE_PROCESS_EXITED) {
+        forgetSession();
+        showOverlay(exitStatus ? describeExit(exitStatus) : "The shell exited.", false);
+      } else if (event.code === CLOSE_SESSION_SUPERSEDED) {
+        showOverlay("This session was opened in another window.", true);
+      } else if (params.get("session") && reconnectAttempts < RECONNECT_ATTEMPTS) {
+        showOverlay("The connection was lost, reconnecting...", true);
+        reconnectTimer = setTimeout(connect, RECONNECT_DELAY_MS << reconnectAttempts);
+        reconnectAttempts++;
+      } else {
+        showOverlay("The connection was closed" + (event.reason ? ": " + event.reason : "."), true);
+      }
+    };
+    ws.onopen = function () {
+      reconnectAttempts = 0;
+      hideOverlay();
+      setTimeout(function () {
+        fitAddon.fit();
+        sendSize();
+      });
     };
   };
+
+  terminal.onData(function (data) {
+    send(MESSAGE_INPUT, encoder.encode(data));
+  });
+  terminal.onBinary(function (data) {
+    var buffer = new Uint8Array(data.length);
+    for (var i = 0; i < data.length; ++i) {
+      buffer[i] = data.charCodeAt(i) & 255;
+    }
+    send(MESSAGE_INPUT, buffer);
+  });
+  terminal.onResize(function (event) {
+    console.log('resizing to', event.cols, event.rows);
+    sendSize();
+  });
+  terminal.onTitleChange(function (event) {
+    console.log(event);
+  });
+  window.onresize = function () {
+    fitAddon.fit();
+  };
+
+  // Restart opens a new session in place of the one that ended; reconnect
+  // reattaches to the current session.
+  restartButton.onclick = function () {
+    forgetSession();
+    sequence = null;
+    terminal.reset();
+    connect();
+  };
+  reconnectButton.onclick = function () {
+    connect();
+  };
+
+  terminal._initialized = true;
+  terminal.focus();
+  connect();
 })();
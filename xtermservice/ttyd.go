@@ -0,0 +1,24 @@
+package xtermservice
+
+import (
+	"errors"
+	"net/http"
+)
+
+/*
+The getTtydTokenHandler function returns the handler of the route from which
+the ttyd web UI fetches the token sent in the first message of its websocket
+connection.
+
+  - GET /token returns {"token": "..."}.
+*/
+func getTtydTokenHandler(token string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet {
+			w.Header().Set("Allow", http.MethodGet)
+			writeJsonError(w, http.StatusMethodNotAllowed, errors.New(http.StatusText(http.StatusMethodNotAllowed)))
+			return
+		}
+		writeJson(w, http.StatusOK, map[string]string{"token": token})
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"io/fs"
 	"net/http"
+	"net/url"
 	"text/template"
 	"time"
 
@@ -20,17 +21,51 @@ import (
 
 // XtermServiceImpl is the default implementation of the HttpServer interface.
 type XtermServiceImpl struct {
-	AllowedHostnames     []string
-	Arguments            []string
-	Command              string
-	ConnectionErrorLimit int
-	HtmlTitle            string
-	KeepalivePingTimeout int
-	MaxBufferSizeBytes   int
-	UrlRoutePrefix       string
+	Administrators                []string
+	AllowedHostnames              []string
+	AllowedOrigins                []string
+	Authenticator                 Authenticator
+	Arguments                     []string
+	Command                       string
+	CompressionLevel              int
+	CompressionThresholdBytes     int
+	ConnectionErrorLimit          int
+	EnableCompression             bool
+	Env                           []string
+	EnvAllowlist                  []string
+	EnvDenylist                   []string
+	FlowControlHighWatermarkBytes int
+	FlowControlLowWatermarkBytes  int
+	HtmlTitle                     string
+	KeepalivePingTimeout          int
+	MaxBufferSizeBytes            int
+	MaxOutputFrameSizeBytes       int
+	OutputLatencyWindow           int
+	OutputTextFrames              bool
+	Profiles                      map[string]xtermjs.Profile
+	RecordInput                   bool
+	RecordingDirectory            string
+	ResourceLimits                xtermjs.ResourceLimits
+	RunAsUser                     string
+	Sandbox                       xtermjs.Sandbox
+	SessionDetachTimeout          int
+	SessionManager                *xtermjs.SessionManager
+	SessionReplayBufferSizeBytes  int
+	Subreaper                     bool
+	TerminationGracePeriod        int
+	TtydProtocol                  bool
+	UrlRoutePrefix                string
+	UserAccounts                  map[string]string
+	UserCommands                  map[string][]string
+	WorkingDirectory              string
+	WorkingDirectorySkeleton      string
 }
 
 type TemplateVariables struct {
+	// AccessToken is the query-escaped "access_token" query parameter of the
+	// page requested, which pages forward to the routes they load as browsers
+	// do not send bearer tokens by themselves
+	AccessToken    string
 	HtmlTitle      string
 	UrlRoutePrefix string
 }
@@ -58,13 +93,46 @@ func createRequestLog(r *http.Request, additionalFields ...map[string]interface{
 		fields["method"] = r.Method
 		fields["protocol"] = r.Proto
 		fields["path"] = r.URL.Path
-		fields["request_url"] = r.URL.String()
+		fields["request_url"] = redactedUrl(r.URL)
 		fields["user_agent"] = r.UserAgent()
-		fields["cookies"] = r.Cookies()
+		fields["cookies"] = cookieNames(r)
 	}
 	return log.WithFields(fields)
 }
 
+// credentialQueryParameters are the query parameters carrying credentials,
+// such as the bearer tokens of browsers and OpenID Connect authorization
+// codes, whose values are not logged
+var credentialQueryParameters = []string{"access_token", "code", "id_token"}
+
+// redactedUrl returns u with the values of credentialQueryParameters redacted
+func redactedUrl(u *url.URL) string {
+	query := u.Query()
+	redacted := false
+	for _, name := range credentialQueryParameters {
+		if query.Has(name) {
+			query.Set(name, "REDACTED")
+			redacted = true
+		}
+	}
+	if !redacted {
+		return u.String()
+	}
+	result := *u
+	result.RawQuery = query.Encode()
+	return result.String()
+}
+
+// cookieNames returns the names of the cookies of r, leaving out their
+// values, which include signed sessions and identity tokens
+func cookieNames(r *http.Request) []string {
+	names := []string{}
+	for _, cookie := range r.Cookies() {
+		names = append(names, cookie.Name)
+	}
+	return names
+}
+
 func getCreateLogger(connectionUUID string, r *http.Request) xtermjs.Logger {
 	createRequestLog(r, map[string]interface{}{"connection_uuid": connectionUUID}).Infof("created logger for connection '%s'", connectionUUID)
 	return createRequestLog(nil, map[string]interface{}{"connection_uuid": connectionUUID})
@@ -82,6 +150,7 @@ func (xtermService *XtermServiceImpl) populateStaticTemplate(responseWriter http
 		return
 	}
 
+	templateVariables.AccessToken = url.QueryEscape(request.URL.Query().Get("access_token"))
 	templateParsed, err := template.New("HtmlTemplate").Parse(string(templateBytes))
 	if err != nil {
 		http.Error(responseWriter, http.StatusText(500), 500)
@@ -113,18 +182,79 @@ Output
 func (xtermService *XtermServiceImpl) Handler(ctx context.Context) *http.ServeMux {
 	rootMux := http.NewServeMux()
 
+	sessionManager := xtermService.SessionManager
+	if sessionManager == nil {
+		sessionManager = xtermjs.NewSessionManager()
+	}
+
 	// Add route to xterm.js.
 
 	xtermjsHandlerOptions := xtermjs.HandlerOpts{
-		AllowedHostnames:     xtermService.AllowedHostnames,
-		Arguments:            xtermService.Arguments,
-		Command:              xtermService.Command,
-		ConnectionErrorLimit: xtermService.ConnectionErrorLimit,
+		AllowedHostnames:              xtermService.AllowedHostnames,
+		AllowedOrigins:                xtermService.AllowedOrigins,
+		Arguments:                     xtermService.Arguments,
+		Command:                       xtermService.Command,
+		CompressionLevel:              xtermService.CompressionLevel,
+		CompressionThresholdBytes:     xtermService.CompressionThresholdBytes,
+		ConnectionErrorLimit:          xtermService.ConnectionErrorLimit,
+		EnableCompression:             xtermService.EnableCompression,
+		Env:                           xtermService.Env,
+		EnvAllowlist:                  xtermService.EnvAllowlist,
+		EnvDenylist:                   xtermService.EnvDenylist,
+		FlowControlHighWatermarkBytes: xtermService.FlowControlHighWatermarkBytes,
+		FlowControlLowWatermarkBytes:  xtermService.FlowControlLowWatermarkBytes,
 		// CreateLogger:         getCreateLogger,
-		KeepalivePingTimeout: time.Duration(xtermService.KeepalivePingTimeout) * time.Second,
-		MaxBufferSizeBytes:   xtermService.MaxBufferSizeBytes,
+		GetUser:                      userFromRequest,
+		KeepalivePingTimeout:         time.Duration(xtermService.KeepalivePingTimeout) * time.Second,
+		MaxBufferSizeBytes:           xtermService.MaxBufferSizeBytes,
+		MaxOutputFrameSizeBytes:      xtermService.MaxOutputFrameSizeBytes,
+		OutputLatencyWindow:          time.Duration(xtermService.OutputLatencyWindow) * time.Millisecond,
+		OutputTextFrames:             xtermService.OutputTextFrames,
+		Profiles:                     xtermService.Profiles,
+		RecordInput:                  xtermService.RecordInput,
+		RecordingDirectory:           xtermService.RecordingDirectory,
+		ResourceLimits:               xtermService.ResourceLimits,
+		RunAsUser:                    xtermService.RunAsUser,
+		Sandbox:                      xtermService.Sandbox,
+		SessionDetachTimeout:         time.Duration(xtermService.SessionDetachTimeout) * time.Second,
+		SessionManager:               sessionManager,
+		SessionReplayBufferSizeBytes: xtermService.SessionReplayBufferSizeBytes,
+		Subreaper:                    xtermService.Subreaper,
+		TerminationGracePeriod:       time.Duration(xtermService.TerminationGracePeriod) * time.Second,
+		TtydProtocol:                 xtermService.TtydProtocol,
+		UserAccounts:                 xtermService.UserAccounts,
+		UserCommands:                 xtermService.UserCommands,
+		WorkingDirectory:             xtermService.WorkingDirectory,
+		WorkingDirectorySkeleton:     xtermService.WorkingDirectorySkeleton,
+	}
+
+	// The ttyd web UI fetches a token from the "token" route and connects to
+	// the "ws" route, sending the token in its first message. As both routes
+	// require authentication, a token generated for the life of the service
+	// is enough to tie websocket connections to authenticated pages.
+
+	if xtermService.TtydProtocol {
+		ttydAuthToken, err := randomString()
+		if err != nil {
+			panic(err)
+		}
+		xtermjsHandlerOptions.TtydAuthToken = ttydAuthToken
+	}
+	xtermjsHandler := requireAuthentication(xtermService.Authenticator, xtermjs.GetHandler(xtermjsHandlerOptions))
+	rootMux.HandleFunc("/xterm.js", xtermjsHandler)
+	if xtermService.TtydProtocol {
+		rootMux.HandleFunc("/ws", xtermjsHandler)
+		rootMux.HandleFunc("/token", requireAuthentication(xtermService.Authenticator, getTtydTokenHandler(xtermjsHandlerOptions.TtydAuthToken)))
+	}
+
+	// Add routes for session management API. Sessions belong to the users who
+	// started them, so the API is only served when users are authenticated.
+
+	if xtermService.Authenticator != nil {
+		sessionsHandler := getSessionsHandler(sessionManager, xtermService.Administrators)
+		rootMux.HandleFunc("/sessions", requireAuthentication(xtermService.Authenticator, sessionsHandler))
+		rootMux.HandleFunc("/sessions/", requireAuthentication(xtermService.Authenticator, sessionsHandler))
 	}
-	rootMux.HandleFunc("/xterm.js", xtermjs.GetHandler(xtermjsHandlerOptions))
 
 	// Create replacement variables for template pages.
 
@@ -137,17 +267,68 @@ func (xtermService *XtermServiceImpl) Handler(ctx context.Context) *http.ServeMu
 		UrlRoutePrefix: urlRoutePrefix,
 	}
 
+	// Add route for profiles API.
+
+	rootMux.HandleFunc("/profiles", requireAuthentication(xtermService.Authenticator, getProfilesHandler(xtermService.Profiles)))
+
+	// Add routes for authentication.
+
+	if routeProvider, ok := xtermService.Authenticator.(RouteProvider); ok {
+		routeProvider.AddRoutes(rootMux, urlRoutePrefix)
+	}
+
 	// Add routes for template pages.
 
-	rootMux.HandleFunc("/xterm.html", func(w http.ResponseWriter, r *http.Request) {
+	// The landing page lists the profiles to start a session from, or goes
+	// straight to the terminal when there are none.
+
+	indexHandler := requireAuthentication(xtermService.Authenticator, func(w http.ResponseWriter, r *http.Request) {
+		if len(xtermService.Profiles) == 0 {
+			location := "xterm.html"
+			if r.URL.RawQuery != "" {
+				location += "?" + r.URL.RawQuery
+			}
+			http.Redirect(w, r, location, http.StatusFound)
+			return
+		}
 		w.Header().Set("Content-Type", "text/html")
-		xtermService.populateStaticTemplate(w, r, "static/templates/xterm.html", templateVariables)
+		xtermService.populateStaticTemplate(w, r, "static/templates/index.html", templateVariables)
 	})
 
-	rootMux.HandleFunc("/terminal.js", func(w http.ResponseWriter, r *http.Request) {
+	rootMux.HandleFunc("/xterm.html", requireAuthentication(xtermService.Authenticator, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/html")
+		xtermService.populateStaticTemplate(w, r, "static/templates/xterm.html", templateVariables)
+	}))
+
+	rootMux.HandleFunc("/terminal.js", requireAuthentication(xtermService.Authenticator, func(w http.ResponseWriter, r *http.Request) {
 		w.Header().Set("Content-Type", "text/javascript")
 		xtermService.populateStaticTemplate(w, r, "static/templates/terminal.js", templateVariables)
-	})
+	}))
+
+	// Add routes for session recordings. Like sessions, recordings belong to
+	// the users who made them and are only served when users are
+	// authenticated.
+
+	if len(xtermService.RecordingDirectory) > 0 && xtermService.Authenticator != nil {
+		recordingsHandler := getRecordingsHandler(xtermService.RecordingDirectory, xtermService.Administrators)
+		rootMux.HandleFunc("/recordings", requireAuthentication(xtermService.Authenticator, recordingsHandler))
+		rootMux.HandleFunc("/recordings/", requireAuthentication(xtermService.Authenticator, recordingsHandler))
+
+		rootMux.HandleFunc("/recordings.html", requireAuthentication(xtermService.Authenticator, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			xtermService.populateStaticTemplate(w, r, "static/templates/recordings.html", templateVariables)
+		}))
+
+		rootMux.HandleFunc("/player.html", requireAuthentication(xtermService.Authenticator, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/html")
+			xtermService.populateStaticTemplate(w, r, "static/templates/player.html", templateVariables)
+		}))
+
+		rootMux.HandleFunc("/player.js", requireAuthentication(xtermService.Authenticator, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "text/javascript")
+			xtermService.populateStaticTemplate(w, r, "static/templates/player.js", templateVariables)
+		}))
+	}
 
 	// Add route for readiness probe.
 
@@ -173,7 +354,14 @@ func (xtermService *XtermServiceImpl) Handler(ctx context.Context) *http.ServeMu
 	if err != nil {
 		panic(err)
 	}
-	rootMux.Handle("/", http.StripPrefix("/", http.FileServer(http.FS(rootDir))))
+	rootFileServer := http.StripPrefix("/", http.FileServer(http.FS(rootDir)))
+	rootMux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/" || r.URL.Path == "/index.html" {
+			indexHandler(w, r)
+			return
+		}
+		rootFileServer.ServeHTTP(w, r)
+	})
 
 	return rootMux
 }
@@ -1,9 +1,33 @@
 package xtermservice
 
 import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/json"
 	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
 	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
 	"testing"
+	"time"
+
+	"github.com/docktermj/cloudshell/pkg/xtermjs"
+	"github.com/gorilla/websocket"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // ----------------------------------------------------------------------------
@@ -42,6 +66,634 @@ func TestXtermServiceImpl_Handler(test *testing.T) {
 
 }
 
+func TestXtermServiceImpl_Handler_Sessions(test *testing.T) {
+	ctx := context.TODO()
+
+	// Without authentication, sessions have no owners and are not served.
+
+	handler := (&XtermServiceImpl{}).Handler(ctx)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/sessions", nil))
+	if recorder.Code != http.StatusNotFound {
+		test.Errorf("GET /sessions without authentication: expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	if runtime.GOOS == "windows" {
+		test.Skip("requires a unix shell")
+	}
+	testObject := &XtermServiceImpl{
+		Administrators:     []string{"admin"},
+		Authenticator:      NewBearerTokenAuthenticator([]string{"alice:alice-token", "bob:bob-token", "admin:admin-token"}, ""),
+		Command:            "/bin/sh",
+		MaxBufferSizeBytes: 512,
+	}
+	server := httptest.NewServer(testObject.Handler(ctx))
+	defer server.Close()
+	header := http.Header{"Authorization": []string{"Bearer alice-token"}}
+	connection, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(server.URL, "http")+"/xterm.js", header)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer connection.Close()
+
+	request := func(method string, path string, token string) (int, []xtermjs.SessionInfo) {
+		request, err := http.NewRequest(method, server.URL+path, nil)
+		if err != nil {
+			test.Fatal(err)
+		}
+		request.Header.Set("Authorization", "Bearer "+token)
+		response, err := http.DefaultClient.Do(request)
+		if err != nil {
+			test.Fatal(err)
+		}
+		defer response.Body.Close()
+		sessionInfos := []xtermjs.SessionInfo{}
+		if path == "/sessions" {
+			json.NewDecoder(response.Body).Decode(&sessionInfos)
+		}
+		return response.StatusCode, sessionInfos
+	}
+	var sessionInfos []xtermjs.SessionInfo
+	for deadline := time.Now().Add(5 * time.Second); len(sessionInfos) == 0 && time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		_, sessionInfos = request(http.MethodGet, "/sessions", "alice-token")
+	}
+	if len(sessionInfos) != 1 || sessionInfos[0].User != "alice" {
+		test.Fatalf("expected alice to see her session, got %v", sessionInfos)
+	}
+	path := "/sessions/" + sessionInfos[0].ID
+
+	testCases := []struct {
+		method     string
+		path       string
+		token      string
+		statusCode int
+		sessions   int
+	}{
+		{method: http.MethodGet, path: "/sessions", token: "bob-token", statusCode: http.StatusOK, sessions: 0},
+		{method: http.MethodGet, path: "/sessions", token: "admin-token", statusCode: http.StatusOK, sessions: 1},
+		{method: http.MethodGet, path: "/sessions", token: "wrong", statusCode: http.StatusUnauthorized},
+		{method: http.MethodPost, path: "/sessions", token: "alice-token", statusCode: http.StatusMethodNotAllowed},
+		{method: http.MethodGet, path: "/sessions/unknown", token: "alice-token", statusCode: http.StatusNotFound},
+		{method: http.MethodGet, path: path, token: "alice-token", statusCode: http.StatusOK},
+		{method: http.MethodGet, path: path, token: "admin-token", statusCode: http.StatusOK},
+		{method: http.MethodGet, path: path, token: "bob-token", statusCode: http.StatusNotFound},
+		{method: http.MethodDelete, path: path, token: "bob-token", statusCode: http.StatusNotFound},
+		{method: http.MethodDelete, path: "/sessions/unknown", token: "admin-token", statusCode: http.StatusNotFound},
+		{method: http.MethodDelete, path: path, token: "admin-token", statusCode: http.StatusNoContent},
+	}
+	for _, testCase := range testCases {
+		statusCode, sessionInfos := request(testCase.method, testCase.path, testCase.token)
+		if statusCode != testCase.statusCode {
+			test.Errorf("%s %s (%s): expected status %d, got %d", testCase.method, testCase.path, testCase.token, testCase.statusCode, statusCode)
+		}
+		if statusCode == http.StatusOK && testCase.path == "/sessions" && len(sessionInfos) != testCase.sessions {
+			test.Errorf("%s %s (%s): expected %d sessions, got %d", testCase.method, testCase.path, testCase.token, testCase.sessions, len(sessionInfos))
+		}
+	}
+}
+
+func TestXtermServiceImpl_Handler_Ttyd(test *testing.T) {
+	if runtime.GOOS == "windows" {
+		test.Skip("requires a unix shell")
+	}
+	ctx := context.TODO()
+	testObject := &XtermServiceImpl{
+		Command:            "/bin/sh",
+		MaxBufferSizeBytes: 512,
+		TtydProtocol:       true,
+	}
+	server := httptest.NewServer(testObject.Handler(ctx))
+	defer server.Close()
+
+	response, err := http.Get(server.URL + "/token")
+	if err != nil {
+		test.Fatal(err)
+	}
+	token := struct {
+		Token string `json:"token"`
+	}{}
+	err = json.NewDecoder(response.Body).Decode(&token)
+	response.Body.Close()
+	if err != nil || token.Token == "" {
+		test.Fatalf("failed to get a token: %v", err)
+	}
+
+	dialer := websocket.Dialer{Subprotocols: []string{xtermjs.ProtocolTtyd}}
+	wsUrl := "ws" + strings.TrimPrefix(server.URL, "http") + "/ws"
+
+	// A wrong token is refused.
+
+	connection, _, err := dialer.Dial(wsUrl, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	connection.WriteMessage(websocket.BinaryMessage, []byte(`{"AuthToken":"wrong","columns":80,"rows":24}`))
+	connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	if _, _, err := connection.ReadMessage(); !websocket.IsCloseError(err, websocket.ClosePolicyViolation) {
+		test.Errorf("expected close %d, got %v", websocket.ClosePolicyViolation, err)
+	}
+	connection.Close()
+
+	// The right token starts a session sized by the init message.
+
+	connection, _, err = dialer.Dial(wsUrl, nil)
+	if err != nil {
+		test.Fatal(err)
+	}
+	defer connection.Close()
+	if connection.Subprotocol() != xtermjs.ProtocolTtyd {
+		test.Fatalf("expected subprotocol %s, got %q", xtermjs.ProtocolTtyd, connection.Subprotocol())
+	}
+	connection.WriteMessage(websocket.BinaryMessage, []byte(`{"AuthToken":"`+token.Token+`","columns":81,"rows":25}`))
+	connection.WriteMessage(websocket.BinaryMessage, []byte("0stty size; exit\n"))
+	connection.SetReadDeadline(time.Now().Add(5 * time.Second))
+	received := map[byte]string{}
+	for {
+		_, data, err := connection.ReadMessage()
+		if err != nil {
+			if !websocket.IsCloseError(err, websocket.CloseNormalClosure) {
+				test.Errorf("expected close %d, got %v", websocket.CloseNormalClosure, err)
+			}
+			break
+		}
+		if len(data) > 0 {
+			received[data[0]] += string(data[1:])
+		}
+	}
+	if received['1'] == "" {
+		test.Error("expected a window title")
+	}
+	if !strings.Contains(received['0'], "25 81") {
+		test.Errorf("expected output of the resized tty, got %q", received['0'])
+	}
+}
+
+func TestXtermServiceImpl_Handler_Recordings(test *testing.T) {
+	ctx := context.TODO()
+	recordingDirectory := test.TempDir()
+	recordings := map[string]string{
+		"alice.cast":   `{"version":2,"width":80,"height":24,"env":{"USER":"alice"}}` + "\n",
+		"bob.cast":     `{"version":2,"width":80,"height":24,"env":{"USER":"bob"}}` + "\n",
+		"unknown.cast": `{"version":2,"width":80,"height":24}` + "\n",
+		"secret.txt":   "secret",
+	}
+	for name, content := range recordings {
+		if err := os.WriteFile(filepath.Join(recordingDirectory, name), []byte(content), 0o600); err != nil {
+			test.Fatal(err)
+		}
+	}
+
+	// Without authentication, recordings have no owners and are not served.
+
+	handler := (&XtermServiceImpl{RecordingDirectory: recordingDirectory}).Handler(ctx)
+	recorder := httptest.NewRecorder()
+	handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/recordings", nil))
+	if recorder.Code != http.StatusNotFound {
+		test.Errorf("GET /recordings without authentication: expected status %d, got %d", http.StatusNotFound, recorder.Code)
+	}
+
+	testObject := &XtermServiceImpl{
+		Administrators:     []string{"admin"},
+		Authenticator:      NewBearerTokenAuthenticator([]string{"alice:alice-token", "admin:admin-token"}, ""),
+		RecordingDirectory: recordingDirectory,
+	}
+	handler = testObject.Handler(ctx)
+
+	testCases := []struct {
+		path       string
+		token      string
+		statusCode int
+		recordings []string
+	}{
+		{path: "/recordings", token: "alice-token", statusCode: http.StatusOK, recordings: []string{"alice.cast"}},
+		{path: "/recordings", token: "admin-token", statusCode: http.StatusOK, recordings: []string{"alice.cast", "bob.cast", "unknown.cast"}},
+		{path: "/recordings", token: "wrong", statusCode: http.StatusUnauthorized},
+		{path: "/recordings/alice.cast", token: "alice-token", statusCode: http.StatusOK},
+		{path: "/recordings/bob.cast", token: "alice-token", statusCode: http.StatusNotFound},
+		{path: "/recordings/unknown.cast", token: "alice-token", statusCode: http.StatusNotFound},
+		{path: "/recordings/bob.cast", token: "admin-token", statusCode: http.StatusOK},
+		{path: "/recordings/secret.txt", token: "admin-token", statusCode: http.StatusNotFound},
+		{path: "/recordings/missing.cast", token: "admin-token", statusCode: http.StatusNotFound},
+		{path: "/recordings.html", token: "alice-token", statusCode: http.StatusOK},
+		{path: "/player.html", token: "alice-token", statusCode: http.StatusOK},
+		{path: "/player.js", token: "alice-token", statusCode: http.StatusOK},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+		request.Header.Set("Authorization", "Bearer "+testCase.token)
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.statusCode {
+			test.Errorf("GET %s (%s): expected status %d, got %d", testCase.path, testCase.token, testCase.statusCode, recorder.Code)
+		}
+		if strings.HasSuffix(testCase.path, ".cast") && recorder.Code == http.StatusOK && recorder.Body.String() != recordings[strings.TrimPrefix(testCase.path, "/recordings/")] {
+			test.Errorf("GET %s (%s): expected the whole recording, got %q", testCase.path, testCase.token, recorder.Body.String())
+		}
+		if testCase.recordings != nil {
+			recordingInfos := []RecordingInfo{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &recordingInfos); err != nil {
+				test.Fatal(err)
+			}
+			names := []string{}
+			for _, recordingInfo := range recordingInfos {
+				names = append(names, recordingInfo.Name)
+			}
+			sort.Strings(names)
+			if strings.Join(names, ",") != strings.Join(testCase.recordings, ",") {
+				test.Errorf("GET %s (%s): expected recordings %v, got %v", testCase.path, testCase.token, testCase.recordings, names)
+			}
+		}
+	}
+}
+
+func TestXtermServiceImpl_Handler_Profiles(test *testing.T) {
+	ctx := context.TODO()
+	testCases := []struct {
+		name             string
+		profiles         map[string]xtermjs.Profile
+		expectedIndex    int
+		expectedProfiles []ProfileInfo
+	}{
+		{name: "none", expectedIndex: http.StatusFound, expectedProfiles: []ProfileInfo{}},
+		{name: "some", profiles: map[string]xtermjs.Profile{
+			"psql": {Command: "psql", Description: "PostgreSQL"},
+			"k9s":  {Command: "k9s", Arguments: []string{"--readonly"}},
+		}, expectedIndex: http.StatusOK, expectedProfiles: []ProfileInfo{{Name: "k9s"}, {Name: "psql", Description: "PostgreSQL"}}},
+	}
+	for _, testCase := range testCases {
+		test.Run(testCase.name, func(test *testing.T) {
+			testObject := &XtermServiceImpl{
+				Profiles: testCase.profiles,
+			}
+			handler := testObject.Handler(ctx)
+
+			recorder := httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if recorder.Code != testCase.expectedIndex {
+				test.Errorf("GET /: expected status %d, got %d", testCase.expectedIndex, recorder.Code)
+			}
+
+			recorder = httptest.NewRecorder()
+			handler.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/profiles", nil))
+			if recorder.Code != http.StatusOK {
+				test.Fatalf("GET /profiles: expected status %d, got %d", http.StatusOK, recorder.Code)
+			}
+			profiles := []ProfileInfo{}
+			if err := json.Unmarshal(recorder.Body.Bytes(), &profiles); err != nil {
+				test.Fatal(err)
+			}
+			if fmt.Sprint(profiles) != fmt.Sprint(testCase.expectedProfiles) {
+				test.Errorf("expected profiles %v, got %v", testCase.expectedProfiles, profiles)
+			}
+		})
+	}
+}
+
+func TestXtermServiceImpl_Handler_Authentication(test *testing.T) {
+	ctx := context.TODO()
+	testObject := &XtermServiceImpl{
+		Authenticator: NewBearerTokenAuthenticator([]string{"alice:s3cret"}, ""),
+	}
+	handler := testObject.Handler(ctx)
+
+	testCases := []struct {
+		authorization string
+		path          string
+		statusCode    int
+		contains      string
+	}{
+		{path: "/xterm.html", statusCode: http.StatusUnauthorized},
+		{path: "/terminal.js", statusCode: http.StatusUnauthorized},
+		{path: "/xterm.js", statusCode: http.StatusUnauthorized},
+		{path: "/xterm.html", authorization: "Bearer wrong", statusCode: http.StatusUnauthorized},
+		{path: "/xterm.html", authorization: "Bearer s3cret", statusCode: http.StatusOK},
+		{path: "/xterm.html?access_token=s3cret", statusCode: http.StatusOK},
+		{path: "/terminal.js?access_token=s3cret", statusCode: http.StatusOK},
+		{path: "/?access_token=s3cret", statusCode: http.StatusFound, contains: "xterm.html?access_token=s3cret"},
+		{path: "/xterm.html?access_token=s3cret", statusCode: http.StatusOK, contains: "/terminal.js?access_token=s3cret"},
+		{path: "/readiness", statusCode: http.StatusOK},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, testCase.path, nil)
+		if testCase.authorization != "" {
+			request.Header.Set("Authorization", testCase.authorization)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		if recorder.Code != testCase.statusCode {
+			test.Errorf("GET %s (%s): expected status %d, got %d", testCase.path, testCase.authorization, testCase.statusCode, recorder.Code)
+		}
+		if !strings.Contains(recorder.Body.String(), testCase.contains) {
+			test.Errorf("GET %s (%s): expected the response to forward the access token as %q", testCase.path, testCase.authorization, testCase.contains)
+		}
+	}
+}
+
+func TestXtermServiceImpl_Handler_Oidc(test *testing.T) {
+	ctx := context.TODO()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		test.Fatal(err)
+	}
+
+	// A minimal OpenID Connect provider issuing ID tokens for "alice". The
+	// "other" code returns a token also intended for, and issued to, another
+	// client.
+
+	var issuer *httptest.Server
+	nonces := map[string]string{}
+	issuer = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/.well-known/openid-configuration":
+			json.NewEncoder(w).Encode(map[string]string{
+				"authorization_endpoint": issuer.URL + "/authorize",
+				"end_session_endpoint":   issuer.URL + "/logout",
+				"issuer":                 issuer.URL,
+				"jwks_uri":               issuer.URL + "/jwks",
+				"token_endpoint":         issuer.URL + "/token",
+			})
+		case "/jwks":
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"keys": []map[string]string{{
+					"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+					"kid": "test",
+					"kty": "RSA",
+					"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				}},
+			})
+		case "/token":
+			if clientId, clientSecret, _ := r.BasicAuth(); clientId != "cloudshell" || clientSecret != "secret" {
+				http.Error(w, "invalid client", http.StatusUnauthorized)
+				return
+			}
+			header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": "test"})
+			claimValues := map[string]interface{}{
+				"aud":                "cloudshell",
+				"exp":                time.Now().Add(time.Hour).Unix(),
+				"iss":                issuer.URL,
+				"nonce":              nonces[r.FormValue("code")],
+				"preferred_username": "alice",
+			}
+			if r.FormValue("code") == "other" {
+				claimValues["aud"] = []string{"cloudshell", "other"}
+				claimValues["azp"] = "other"
+			}
+			claims, _ := json.Marshal(claimValues)
+			signingInput := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+			digest := sha256.Sum256([]byte(signingInput))
+			signature, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+			w.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(w).Encode(map[string]string{
+				"access_token": "access",
+				"id_token":     signingInput + "." + base64.RawURLEncoding.EncodeToString(signature),
+				"token_type":   "Bearer",
+			})
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer issuer.Close()
+
+	sessionSecret, err := NewOidcSessionSecret()
+	if err != nil {
+		test.Fatal(err)
+	}
+	testObject := &XtermServiceImpl{
+		Authenticator: &OidcAuthenticator{
+			ClientId:      "cloudshell",
+			ClientSecret:  "secret",
+			IssuerUrl:     issuer.URL,
+			RedirectUrl:   "http://cloudshell.example/oidc/callback",
+			SessionSecret: sessionSecret,
+		},
+	}
+	handler := testObject.Handler(ctx)
+	serveMethod := func(method string, path string, form url.Values, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		request := httptest.NewRequest(method, path, strings.NewReader(form.Encode()))
+		request.Header.Set("Accept", "text/html")
+		if form != nil {
+			request.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		}
+		for _, cookie := range cookies {
+			request.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder
+	}
+	serve := func(path string, cookies []*http.Cookie) *httptest.ResponseRecorder {
+		return serveMethod(http.MethodGet, path, nil, cookies)
+	}
+
+	// Unauthenticated page loads are sent to the login route.
+
+	response := serve("/xterm.html", nil)
+	if response.Code != http.StatusFound || !strings.HasPrefix(response.Header().Get("Location"), "/oidc/login?") {
+		test.Fatalf("GET /xterm.html: expected redirect to login, got %d %s", response.Code, response.Header().Get("Location"))
+	}
+
+	// The login route redirects to the provider.
+
+	response = serve(response.Header().Get("Location"), nil)
+	authorizeUrl, err := url.Parse(response.Header().Get("Location"))
+	if err != nil || response.Code != http.StatusFound || authorizeUrl.Path != "/authorize" {
+		test.Fatalf("GET /oidc/login: expected redirect to provider, got %d %s", response.Code, response.Header().Get("Location"))
+	}
+	nonces["code"] = authorizeUrl.Query().Get("nonce")
+	nonces["other"] = authorizeUrl.Query().Get("nonce")
+	loginCookies := response.Result().Cookies()
+	state := url.QueryEscape(authorizeUrl.Query().Get("state"))
+
+	// A callback with a forged state is refused.
+
+	response = serve("/oidc/callback?code=code&state=forged", loginCookies)
+	if response.Code != http.StatusBadRequest {
+		test.Errorf("GET /oidc/callback with forged state: expected status %d, got %d", http.StatusBadRequest, response.Code)
+	}
+
+	// A token issued to another client is refused.
+
+	response = serve("/oidc/callback?code=other&state="+state, loginCookies)
+	if response.Code != http.StatusUnauthorized {
+		test.Errorf("GET /oidc/callback with token issued to another client: expected status %d, got %d", http.StatusUnauthorized, response.Code)
+	}
+
+	// The callback starts a session and returns to the original page.
+
+	response = serve("/oidc/callback?code=code&state="+state, loginCookies)
+	if response.Code != http.StatusFound || response.Header().Get("Location") != "/xterm.html" {
+		test.Fatalf("GET /oidc/callback: expected redirect to /xterm.html, got %d %s", response.Code, response.Header().Get("Location"))
+	}
+	sessionCookies := []*http.Cookie{}
+	idToken := ""
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == oidcSessionCookie || cookie.Name == oidcIdTokenCookie {
+			sessionCookies = append(sessionCookies, cookie)
+		}
+		if cookie.Name == oidcIdTokenCookie {
+			idToken = cookie.Value
+		}
+	}
+	response = serve("/xterm.html", sessionCookies)
+	if response.Code != http.StatusOK {
+		test.Errorf("GET /xterm.html with session: expected status %d, got %d", http.StatusOK, response.Code)
+	}
+	if user, err := testObject.Authenticator.Authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); err == nil {
+		test.Errorf("Authenticate without session: expected error, got user '%s'", user)
+	}
+
+	// Logging out is confirmed with a form carrying a CSRF token.
+
+	response = serve("/oidc/logout", sessionCookies)
+	csrfToken := ""
+	if _, rest, found := strings.Cut(response.Body.String(), `name="csrf_token" value="`); found {
+		csrfToken, _, _ = strings.Cut(rest, `"`)
+	}
+	if response.Code != http.StatusOK || csrfToken == "" || len(response.Result().Cookies()) != 0 {
+		test.Fatalf("GET /oidc/logout: expected a confirmation form, got %d %q", response.Code, response.Body.String())
+	}
+	response = serveMethod(http.MethodPost, "/oidc/logout", url.Values{"csrf_token": {"forged"}}, sessionCookies)
+	if response.Code != http.StatusForbidden {
+		test.Errorf("POST /oidc/logout with forged CSRF token: expected status %d, got %d", http.StatusForbidden, response.Code)
+	}
+
+	// Logging out ends the session at the provider.
+
+	response = serveMethod(http.MethodPost, "/oidc/logout", url.Values{"csrf_token": {csrfToken}}, sessionCookies)
+	endSessionUrl, err := url.Parse(response.Header().Get("Location"))
+	if err != nil || response.Code != http.StatusFound || endSessionUrl.Path != "/logout" {
+		test.Fatalf("POST /oidc/logout: expected redirect to provider, got %d %s", response.Code, response.Header().Get("Location"))
+	}
+	if endSessionUrl.Query().Get("id_token_hint") != idToken || endSessionUrl.Query().Get("post_logout_redirect_uri") != "http://cloudshell.example/" {
+		test.Errorf("POST /oidc/logout: expected ID token hint and post-logout redirect, got %s", endSessionUrl.RawQuery)
+	}
+	for _, cookie := range response.Result().Cookies() {
+		if cookie.Name == oidcSessionCookie && cookie.MaxAge >= 0 {
+			test.Errorf("POST /oidc/logout: expected the session cookie to be cleared, got %v", cookie)
+		}
+	}
+}
+
+func TestClientCertificateAuthenticator_Authenticate(test *testing.T) {
+	spiffeId, _ := url.Parse("spiffe://example.com/alice")
+	testCases := []struct {
+		certificate *x509.Certificate
+		expected    string
+	}{
+		{certificate: &x509.Certificate{Subject: pkix.Name{CommonName: "alice"}, EmailAddresses: []string{"bob@example.com"}}, expected: "alice"},
+		{certificate: &x509.Certificate{EmailAddresses: []string{"alice@example.com"}, DNSNames: []string{"alice.example.com"}}, expected: "alice@example.com"},
+		{certificate: &x509.Certificate{DNSNames: []string{"alice.example.com"}}, expected: "alice.example.com"},
+		{certificate: &x509.Certificate{URIs: []*url.URL{spiffeId}}, expected: "spiffe://example.com/alice"},
+		{certificate: &x509.Certificate{}, expected: ""},
+		{certificate: nil, expected: ""},
+	}
+	authenticator := &ClientCertificateAuthenticator{}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, "/", nil)
+		if testCase.certificate != nil {
+			request.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{testCase.certificate}}}
+		}
+		user, err := authenticator.Authenticate(request)
+		if user != testCase.expected || (err == nil) != (testCase.expected != "") {
+			test.Errorf("Authenticate(%v): expected user '%s', got '%s' (%v)", testCase.certificate, testCase.expected, user, err)
+		}
+	}
+}
+
+func TestHtpasswdAuthenticator_Authenticate(test *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("p@ssw0rd"), bcrypt.MinCost)
+	if err != nil {
+		test.Fatal(err)
+	}
+	filename := filepath.Join(test.TempDir(), "htpasswd")
+	if err := os.WriteFile(filename, []byte("alice:"+string(bcryptHash)+"\n"), 0600); err != nil {
+		test.Fatal(err)
+	}
+	testObject, err := NewHtpasswdAuthenticator(filename, "")
+	if err != nil {
+		test.Fatal(err)
+	}
+	if _, err := bcrypt.Cost([]byte(unknownUserHash)); err != nil {
+		test.Fatalf("unknownUserHash is not a bcrypt hash: %v", err)
+	}
+
+	testCases := []struct {
+		user     string
+		password string
+		expected string
+	}{
+		{user: "alice", password: "p@ssw0rd", expected: "alice"},
+		{user: "alice", password: "wrong", expected: ""},
+		{user: "mallory", password: "p@ssw0rd", expected: ""},
+		{user: "mallory", password: "cloudshell-unknown-user", expected: ""},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, "/xterm.html", nil)
+		request.SetBasicAuth(testCase.user, testCase.password)
+		actual, err := testObject.Authenticate(request)
+		if actual != testCase.expected {
+			test.Errorf("Authenticate(%q, %q): expected user %q, got %q", testCase.user, testCase.password, testCase.expected, actual)
+		}
+		if testCase.expected == "" && err != ErrUnauthenticated {
+			test.Errorf("Authenticate(%q, %q): expected %v, got %v", testCase.user, testCase.password, ErrUnauthenticated, err)
+		}
+	}
+}
+
+func TestVerifyHtpasswdHash(test *testing.T) {
+	bcryptHash, err := bcrypt.GenerateFromPassword([]byte("p@ssw0rd"), bcrypt.MinCost)
+	if err != nil {
+		test.Fatal(err)
+	}
+	testCases := []struct {
+		hash     string
+		password string
+		expected bool
+	}{
+		{hash: "$apr1$abcdefgh$r/p0TRuenjeL4j5EsxbGb/", password: "p@ssw0rd", expected: true},
+		{hash: "$apr1$abcdefgh$r/p0TRuenjeL4j5EsxbGb/", password: "wrong", expected: false},
+		{hash: "$apr1$xy$tXk3v7ICQJrmb1v3BGhw40", password: "averyveryverylongpassword1234567890", expected: true},
+		{hash: "{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", password: "password", expected: true},
+		{hash: string(bcryptHash), password: "p@ssw0rd", expected: true},
+		{hash: string(bcryptHash), password: "wrong", expected: false},
+		{hash: "p@ssw0rd", password: "p@ssw0rd", expected: false},
+	}
+	for _, testCase := range testCases {
+		if actual := verifyHtpasswdHash(testCase.hash, testCase.password); actual != testCase.expected {
+			test.Errorf("verifyHtpasswdHash(%q, %q): expected %v, got %v", testCase.hash, testCase.password, testCase.expected, actual)
+		}
+	}
+}
+
+func TestCreateRequestLog(test *testing.T) {
+	testCases := []struct {
+		target   string
+		expected string
+	}{
+		{target: "/xterm.html", expected: "/xterm.html"},
+		{target: "/xterm.html?profile=admin", expected: "/xterm.html?profile=admin"},
+		{target: "/xterm.html?access_token=s3cret", expected: "/xterm.html?access_token=REDACTED"},
+		{target: "/ws?access_token=s3cret&profile=admin", expected: "/ws?access_token=REDACTED&profile=admin"},
+		{target: "/oidc/callback?code=s3cret&state=abc", expected: "/oidc/callback?code=REDACTED&state=abc"},
+		{target: "/xterm.html?id_token=s3cret", expected: "/xterm.html?id_token=REDACTED"},
+	}
+	for _, testCase := range testCases {
+		request := httptest.NewRequest(http.MethodGet, testCase.target, nil)
+		request.AddCookie(&http.Cookie{Name: "cloudshell_session", Value: "s3cret"})
+		request.AddCookie(&http.Cookie{Name: "cloudshell_oidc_id_token", Value: "s3cret"})
+		fields := createRequestLog(request).(*logrus.Entry).Data
+		if actual := fields["request_url"]; actual != testCase.expected {
+			test.Errorf("createRequestLog(%q): expected request_url %q, got %q", testCase.target, testCase.expected, actual)
+		}
+		if actual := fmt.Sprint(fields["cookies"]); actual != "[cloudshell_session cloudshell_oidc_id_token]" {
+			test.Errorf("createRequestLog(%q): expected cookie names, got %s", testCase.target, actual)
+		}
+		if logged := fmt.Sprint(fields); strings.Contains(logged, "s3cret") {
+			test.Errorf("createRequestLog(%q): credential logged in %s", testCase.target, logged)
+		}
+	}
+}
+
 // ----------------------------------------------------------------------------
 // Examples for godoc documentation
 // ----------------------------------------------------------------------------